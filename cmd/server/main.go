@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -22,7 +23,9 @@ import (
 	"github.com/isqad/livelook-sfu/internal/config"
 	"github.com/isqad/livelook-sfu/internal/core"
 	"github.com/isqad/livelook-sfu/internal/eventbus"
+	"github.com/isqad/livelook-sfu/internal/rtmp"
 	"github.com/isqad/livelook-sfu/internal/service"
+	"github.com/isqad/livelook-sfu/internal/turn"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
@@ -76,6 +79,7 @@ func main() {
 	}
 
 	sessionsStorer := core.NewSessionsRepository(db)
+	recordingsStorer := core.NewRecordingsRepository(db)
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr: fmt.Sprintf("%s:%s", viper.GetString("redis.host"), viper.GetString("redis.port")),
@@ -88,26 +92,80 @@ func main() {
 		log.Fatal().Err(err).Msg("")
 	}
 
-	apiApp := api.NewApp(
-		api.AppOptions{
-			DB:                 db,
-			EventsPublisher:    redisPubSub,
-			EventsSubscriber:   redisPubSub,
-			SessionsRepository: sessionsStorer,
-		},
-	)
-
 	sfuRouter, err := eventbus.NewRouter(redisPubSub)
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
 
 	sfuConfig := config.NewConfig()
-	sessionManager, err := service.NewSessionsManager(sfuConfig, sfuRouter, redisPubSub, sessionsStorer, nc)
+	if viper.IsSet("rtc.ice_udp_port") {
+		sfuConfig.RTC.ICEUDPPort = viper.GetInt("rtc.ice_udp_port")
+	}
+	if viper.IsSet("rtc.ice_tcp_port") {
+		sfuConfig.RTC.ICETCPPort = viper.GetInt("rtc.ice_tcp_port")
+	}
+	if viper.IsSet("transcoder.transport") {
+		sfuConfig.RTC.Transcoder.Transport = viper.GetString("transcoder.transport")
+	}
+	if viper.IsSet("transcoder.host") {
+		sfuConfig.RTC.Transcoder.Host = viper.GetString("transcoder.host")
+	}
+	if viper.IsSet("transcoder.socket_dir") {
+		sfuConfig.RTC.Transcoder.SocketDir = viper.GetString("transcoder.socket_dir")
+	}
+	if viper.IsSet("transcoder.ports_lock_dir") {
+		sfuConfig.RTC.Transcoder.PortsLockDir = viper.GetString("transcoder.ports_lock_dir")
+	}
+	if viper.IsSet("recording.dir") {
+		sfuConfig.Recording.Dir = viper.GetString("recording.dir")
+	}
+	if viper.GetBool("turn.enabled") {
+		sfuConfig.RTC.TURN.Enabled = true
+		sfuConfig.RTC.TURN.Host = viper.GetString("turn.host")
+		sfuConfig.RTC.TURN.PublicIP = viper.GetString("turn.public_ip")
+		sfuConfig.RTC.TURN.Secret = viper.GetString("turn.secret")
+		if viper.IsSet("turn.port") {
+			sfuConfig.RTC.TURN.Port = viper.GetInt("turn.port")
+		}
+	}
+
+	var turnServer *turn.Server
+	if sfuConfig.RTC.TURN.Enabled {
+		if turnServer, err = turn.NewServer(sfuConfig.RTC.TURN); err != nil {
+			log.Fatal().Err(err).Msg("can't start TURN server")
+		}
+	}
+	sessionManager, err := service.NewSessionsManager(sfuConfig, sfuRouter, redisPubSub, sessionsStorer, recordingsStorer, nc)
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
 
+	// The encoders publish over RTMP with the stream keys of the users
+	var rtmpServer *rtmp.Server
+	if viper.GetBool("rtmp.enabled") {
+		rtmpServer = rtmp.NewServer(service.NewRTMPIngest(core.NewUserRepository(db), sessionManager))
+		go func() {
+			if err := rtmpServer.ListenAndServe(viper.GetString("rtmp.addr")); err != nil && !errors.Is(err, rtmp.ErrServerClosed) {
+				log.Fatal().Err(err).Msg("RTMP server has been closed immediatelly")
+			}
+		}()
+	}
+
+	apiApp := api.NewApp(
+		api.AppOptions{
+			DB:                 db,
+			EventsPublisher:    redisPubSub,
+			EventsSubscriber:   redisPubSub,
+			SessionsRepository: sessionsStorer,
+			StatsProvider:      sessionManager,
+			TrackModerator:     sessionManager,
+			TrackForwarder:     sessionManager,
+			StreamRecorder:     sessionManager,
+			WHIPPublisher:      sessionManager,
+			WHEPSubscriber:     sessionManager,
+		},
+	)
+
 	<-sfuRouter.Start()
 
 	r := chi.NewRouter()
@@ -181,12 +239,26 @@ func main() {
 	server.RegisterOnShutdown(func() {
 		log.Warn().Msg("received signal to terminate the server")
 
+		if rtmpServer != nil {
+			log.Info().Msg("stop RTMP server")
+			if err := rtmpServer.Close(); err != nil {
+				log.Error().Err(err).Msg("")
+			}
+		}
+
 		log.Info().Msg("send terminate signal to clients")
 		sessionManager.Close()
 
 		log.Info().Msg("stop router")
 		<-sfuRouter.Stop()
 
+		if turnServer != nil {
+			log.Info().Msg("stop TURN server")
+			if err := turnServer.Close(); err != nil {
+				log.Error().Err(err).Msg("")
+			}
+		}
+
 		log.Info().Msg("close redis link")
 		if err := rdb.Close(); err != nil {
 			log.Error().Err(err).Msg("")
@@ -0,0 +1,25 @@
+CREATE TYPE recording_status AS ENUM (
+  'recording',
+  'finished',
+  'failed'
+);
+
+CREATE TABLE recordings (
+  id bigint NOT NULL PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+  user_id varchar(255) NOT NULL,
+  track_id varchar(255) NOT NULL,
+  kind varchar(16) NOT NULL,
+  mime_type varchar(64) NOT NULL,
+  filename varchar(1024) NOT NULL,
+  size bigint NOT NULL DEFAULT 0,
+  status recording_status NOT NULL DEFAULT 'recording',
+  started_at timestamp with time zone NOT NULL,
+  finished_at timestamp with time zone,
+  created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX index_recordings_user_id ON recordings (user_id);
+CREATE UNIQUE INDEX uniq_recordings_filename ON recordings (filename);
+
+ALTER TABLE recordings ADD CONSTRAINT fk_recordings_user_id FOREIGN KEY (user_id) REFERENCES users(id)
+  ON DELETE CASCADE;
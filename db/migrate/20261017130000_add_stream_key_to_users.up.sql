@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN stream_key varchar(64);
+
+CREATE UNIQUE INDEX uniq_users_stream_key ON users (stream_key) WHERE stream_key IS NOT NULL;
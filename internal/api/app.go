@@ -28,6 +28,12 @@ type AppOptions struct {
 	EventsPublisher    eventbus.Publisher
 	EventsSubscriber   eventbus.Subscriber
 	SessionsRepository core.SessionsDBStorer
+	StatsProvider      StatsProvider
+	TrackModerator     TrackModerator
+	TrackForwarder     TrackForwarder
+	StreamRecorder     StreamRecorder
+	WHIPPublisher      WHIPPublisher
+	WHEPSubscriber     WHEPSubscriber
 
 	router         *chi.Mux
 	authMiddleware AuthHandler
@@ -142,9 +148,44 @@ func (app *App) Router() http.Handler {
 	// TODO: protect it
 	app.router.Get("/api/v1/streams", StreamListHandler(app.DB))
 
+	// The broadcast software is authenticated by the stream key of the URL
+	app.router.Route("/whip/{streamKey}", func(r chi.Router) {
+		r.Post("/", WHIPPublishHandler(app.userRepository, app.WHIPPublisher))
+		r.Patch("/", WHIPTrickleHandler(app.userRepository, app.WHIPPublisher))
+		r.Delete("/", WHIPStopHandler(app.userRepository, app.WHIPPublisher))
+	})
+
+	// The third-party players watch the live streams without the authentication
+	app.router.Post("/whep/{streamID}", WHEPSubscribeHandler(app.WHEPSubscriber))
+	app.router.Patch("/whep/{streamID}/{viewerID}", WHEPTrickleHandler(app.WHEPSubscriber))
+	app.router.Delete("/whep/{streamID}/{viewerID}", WHEPStopHandler(app.WHEPSubscriber))
+
 	app.router.With(app.authMiddleware).Route("/api/v1", func(r chi.Router) {
 		r.Put("/stream", StreamUpdateHandler(app.SessionsRepository, app.DB))
 
+		// PUT /api/v1/stream/key
+		r.Put("/stream/key", StreamKeyHandler(app.userRepository))
+
+		// GET /api/v1/streams/{id}/stats
+		r.Get("/streams/{id}/stats", StreamStatsHandler(app.StatsProvider))
+
+		// PUT /api/v1/streams/{id}/mute
+		r.Put("/streams/{id}/mute", StreamMuteHandler(app.TrackModerator))
+
+		// GET, POST /api/v1/streams/{id}/forwards
+		r.Get("/streams/{id}/forwards", StreamForwardsHandler(app.TrackForwarder))
+		r.Post("/streams/{id}/forwards", StreamForwardsHandler(app.TrackForwarder))
+
+		// DELETE /api/v1/streams/{id}/forwards/{targetID}
+		r.Delete("/streams/{id}/forwards/{targetID}", StreamForwardHandler(app.TrackForwarder))
+
+		// POST, DELETE /api/v1/streams/{id}/recording
+		r.Post("/streams/{id}/recording", StreamRecordingHandler(app.StreamRecorder))
+		r.Delete("/streams/{id}/recording", StreamRecordingHandler(app.StreamRecorder))
+
+		// GET /api/v1/streams/{id}/recordings
+		r.Get("/streams/{id}/recordings", StreamRecordingsHandler(app.StreamRecorder))
+
 		// r.Get("/streams", StreamListHandler(app.DB))
 
 		r.Post("/users", func(w http.ResponseWriter, r *http.Request) {
@@ -5,13 +5,56 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 
 	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/rtc"
 	"github.com/jmoiron/sqlx"
 )
 
+// StatsProvider reads the statistics of the media of the live streams
+type StatsProvider interface {
+	// StreamStats returns an error if the stream is not live
+	StreamStats(userID core.UserSessionID) ([]rtc.ParticipantStats, error)
+}
+
+// TrackModerator force-mutes the tracks of the publishers of the live streams
+type TrackModerator interface {
+	// ForceMuteTrack mutes or unmutes the track, every track of the publisher if the track ID is empty
+	ForceMuteTrack(roomID core.UserSessionID, publisherID core.UserSessionID, trackID string, muted bool) error
+}
+
+// StreamRecorder records the tracks of the streamers into the files
+type StreamRecorder interface {
+	StartRecording(userID core.UserSessionID) error
+	StopRecording(userID core.UserSessionID) error
+	// Recordings returns the recorded files of the streamer, the latest first
+	Recordings(userID core.UserSessionID) ([]*core.Recording, error)
+}
+
+// TrackForwarder forwards the tracks of the publishers of the live streams to the external receivers
+type TrackForwarder interface {
+	AddForwardTarget(roomID core.UserSessionID, publisherID core.UserSessionID, trackID string, params rtc.ForwardTargetParams) (*rtc.ForwardTargetInfo, error)
+	RemoveForwardTarget(roomID core.UserSessionID, targetID string) error
+	ForwardTargets(roomID core.UserSessionID) ([]rtc.ForwardTargetInfo, error)
+}
+
+// StreamForwardRequest is the body of the request adding the forward target, the publisher is the streamer if empty
+type StreamForwardRequest struct {
+	rtc.ForwardTargetParams
+	UserID  core.UserSessionID `json:"user_id"`
+	TrackID string             `json:"track_id"`
+}
+
+// StreamMuteRequest is the body of the force-mute request, the publisher is the streamer if empty
+type StreamMuteRequest struct {
+	UserID  core.UserSessionID `json:"user_id"`
+	TrackID string             `json:"track_id"`
+	Muted   bool               `json:"muted"`
+}
+
 func StreamUpdateHandler(
 	sessionRepository core.SessionsDBStorer,
 	db *sqlx.DB,
@@ -69,3 +112,243 @@ func StreamListHandler(db *sqlx.DB) http.HandlerFunc {
 		}
 	}
 }
+
+// StreamStatsHandler returns the RTP statistics of the participants of the stream.
+// The stats are available to the streamer and to the admins.
+func StreamStatsHandler(statsProvider StatsProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "id"))
+		if !user.IsAdmin && user.ID != streamID {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		stats, err := statsProvider.StreamStats(streamID)
+		if err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("stream stats")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("encode stream stats")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// StreamMuteHandler force-mutes or unmutes the track of the publisher of the stream, it is allowed to the admins only
+func StreamMuteHandler(moderator TrackModerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !user.IsAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		req := &StreamMuteRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("decode mute request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "id"))
+		if req.UserID == "" {
+			req.UserID = streamID
+		}
+
+		if err := moderator.ForceMuteTrack(streamID, req.UserID, req.TrackID, req.Muted); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("force mute track")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// StreamForwardsHandler lists the forward targets of the tracks of the stream on GET and adds the target on POST.
+// It is allowed to the admins only.
+func StreamForwardsHandler(forwarder TrackForwarder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !user.IsAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "id"))
+
+		var response interface{}
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			req := &StreamForwardRequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				log.Error().Err(err).Str("service", "web").Msg("decode forward request")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if req.UserID == "" {
+				req.UserID = streamID
+			}
+
+			// The stream is not live, the track is not published or the target is invalid
+			if response, err = forwarder.AddForwardTarget(streamID, req.UserID, req.TrackID, req.ForwardTargetParams); err != nil {
+				log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("add forward target")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			status = http.StatusCreated
+		} else if response, err = forwarder.ForwardTargets(streamID); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("forward targets")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("encode forward targets")
+		}
+	}
+}
+
+// StreamForwardHandler removes the forward target of the stream, it is allowed to the admins only
+func StreamForwardHandler(forwarder TrackForwarder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !user.IsAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "id"))
+		if err := forwarder.RemoveForwardTarget(streamID, chi.URLParam(r, "targetID")); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("remove forward target")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// StreamRecordingHandler starts the recording of the stream on POST and stops it on DELETE.
+// It is allowed to the streamer and to the admins.
+func StreamRecordingHandler(recorder StreamRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "id"))
+		if !user.IsAdmin && user.ID != streamID {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err = recorder.StopRecording(streamID)
+		} else {
+			err = recorder.StartRecording(streamID)
+		}
+
+		// The stream is not live or is already in the requested state
+		if err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Str("method", r.Method).Msg("stream recording")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// StreamRecordingsHandler lists the recorded files of the stream to the streamer and to the admins
+func StreamRecordingsHandler(recorder StreamRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "id"))
+		if !user.IsAdmin && user.ID != streamID {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		recordings, err := recorder.Recordings(streamID)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("stream recordings")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(recordings); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("encode stream recordings")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// StreamKeyHandler issues the new stream key of the user, the previous key stops working
+func StreamKeyHandler(users core.UserStorer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromRequest(r)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("can't get user ID from request context")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		key, err := users.ResetStreamKey(user.ID)
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Str("UserID", string(user.ID)).Msg("reset stream key")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]string{"stream_key": key}); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("encode stream key")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/rtc"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockStatsProvider struct {
+	stats map[core.UserSessionID][]rtc.ParticipantStats
+}
+
+func (p *mockStatsProvider) StreamStats(userID core.UserSessionID) ([]rtc.ParticipantStats, error) {
+	stats, ok := p.stats[userID]
+	if !ok {
+		return nil, errors.New("room is not initialized")
+	}
+
+	return stats, nil
+}
+
+type mockTrackModerator struct {
+	publisherID core.UserSessionID
+	trackID     string
+	muted       bool
+}
+
+func (m *mockTrackModerator) ForceMuteTrack(roomID core.UserSessionID, publisherID core.UserSessionID, trackID string, muted bool) error {
+	if roomID != "streamer" {
+		return errors.New("room is not initialized")
+	}
+	m.publisherID, m.trackID, m.muted = publisherID, trackID, muted
+
+	return nil
+}
+
+func withUser(user *core.User) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserContextKey, user)))
+		})
+	}
+}
+
+func TestStreamStatsHandler(t *testing.T) {
+	provider := &mockStatsProvider{
+		stats: map[core.UserSessionID][]rtc.ParticipantStats{
+			"streamer": {{UserID: "streamer"}},
+		},
+	}
+
+	request := func(user *core.User, streamID string) *httptest.ResponseRecorder {
+		r := chi.NewRouter()
+		r.Use(withUser(user))
+		r.Get("/streams/{id}/stats", StreamStatsHandler(provider))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/streams/"+streamID+"/stats", nil))
+
+		return w
+	}
+
+	t.Run("streamer reads own stats", func(t *testing.T) {
+		w := request(&core.User{ID: "streamer"}, "streamer")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var stats []rtc.ParticipantStats
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&stats))
+		assert.Equal(t, 1, len(stats))
+		assert.Equal(t, core.UserSessionID("streamer"), stats[0].UserID)
+	})
+
+	t.Run("other user is forbidden", func(t *testing.T) {
+		w := request(&core.User{ID: "viewer"}, "streamer")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("admin reads any stats", func(t *testing.T) {
+		w := request(&core.User{ID: "admin", IsAdmin: true}, "streamer")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("stream is not live", func(t *testing.T) {
+		w := request(&core.User{ID: "admin", IsAdmin: true}, "offline")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestStreamMuteHandler(t *testing.T) {
+	request := func(moderator *mockTrackModerator, user *core.User, streamID string, body string) *httptest.ResponseRecorder {
+		r := chi.NewRouter()
+		r.Use(withUser(user))
+		r.Put("/streams/{id}/mute", StreamMuteHandler(moderator))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("PUT", "/streams/"+streamID+"/mute", strings.NewReader(body)))
+
+		return w
+	}
+
+	t.Run("admin mutes the track of the co-publisher", func(t *testing.T) {
+		moderator := &mockTrackModerator{}
+		w := request(moderator, &core.User{ID: "admin", IsAdmin: true}, "streamer", `{"user_id":"guest","track_id":"audio","muted":true}`)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, core.UserSessionID("guest"), moderator.publisherID)
+		assert.Equal(t, "audio", moderator.trackID)
+		assert.Equal(t, true, moderator.muted)
+	})
+
+	t.Run("publisher is the streamer by default", func(t *testing.T) {
+		moderator := &mockTrackModerator{}
+		w := request(moderator, &core.User{ID: "admin", IsAdmin: true}, "streamer", `{"muted":true}`)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, core.UserSessionID("streamer"), moderator.publisherID)
+	})
+
+	t.Run("streamer is not an admin", func(t *testing.T) {
+		w := request(&mockTrackModerator{}, &core.User{ID: "streamer"}, "streamer", `{"muted":true}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("stream is not live", func(t *testing.T) {
+		w := request(&mockTrackModerator{}, &core.User{ID: "admin", IsAdmin: true}, "offline", `{"muted":true}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+type mockTrackForwarder struct {
+	targets []rtc.ForwardTargetInfo
+}
+
+func (m *mockTrackForwarder) AddForwardTarget(roomID core.UserSessionID, publisherID core.UserSessionID, trackID string, params rtc.ForwardTargetParams) (*rtc.ForwardTargetInfo, error) {
+	if roomID != "streamer" {
+		return nil, errors.New("room is not initialized")
+	}
+
+	target := rtc.ForwardTargetInfo{
+		ID:          "target",
+		TrackID:     rtc.MediaTrackID(trackID),
+		Addr:        params.Host,
+		PayloadType: params.PayloadType,
+		SRTP:        params.SRTP != nil,
+	}
+	m.targets = append(m.targets, target)
+
+	return &target, nil
+}
+
+func (m *mockTrackForwarder) RemoveForwardTarget(roomID core.UserSessionID, targetID string) error {
+	for i, target := range m.targets {
+		if target.ID == targetID {
+			m.targets = append(m.targets[:i], m.targets[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New("forward target is not found")
+}
+
+func (m *mockTrackForwarder) ForwardTargets(roomID core.UserSessionID) ([]rtc.ForwardTargetInfo, error) {
+	if roomID != "streamer" {
+		return nil, errors.New("room is not initialized")
+	}
+
+	return m.targets, nil
+}
+
+func TestStreamForwardsHandler(t *testing.T) {
+	request := func(forwarder *mockTrackForwarder, user *core.User, method string, path string, body string) *httptest.ResponseRecorder {
+		r := chi.NewRouter()
+		r.Use(withUser(user))
+		r.Get("/streams/{id}/forwards", StreamForwardsHandler(forwarder))
+		r.Post("/streams/{id}/forwards", StreamForwardsHandler(forwarder))
+		r.Delete("/streams/{id}/forwards/{targetID}", StreamForwardHandler(forwarder))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		return w
+	}
+	admin := &core.User{ID: "admin", IsAdmin: true}
+
+	t.Run("admin adds, lists and removes the target", func(t *testing.T) {
+		forwarder := &mockTrackForwarder{}
+
+		w := request(forwarder, admin, "POST", "/streams/streamer/forwards",
+			`{"track_id":"video","host":"10.0.0.1","port":5004,"payload_type":100,"srtp":{"key":"AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwd"}}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var target rtc.ForwardTargetInfo
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&target))
+		assert.Equal(t, rtc.MediaTrackID("video"), target.TrackID)
+		assert.Equal(t, "10.0.0.1", target.Addr)
+		assert.Equal(t, webrtc.PayloadType(100), target.PayloadType)
+		assert.Equal(t, true, target.SRTP)
+
+		w = request(forwarder, admin, "GET", "/streams/streamer/forwards", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var targets []rtc.ForwardTargetInfo
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&targets))
+		assert.Equal(t, 1, len(targets))
+
+		w = request(forwarder, admin, "DELETE", "/streams/streamer/forwards/target", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, 0, len(forwarder.targets))
+
+		w = request(forwarder, admin, "DELETE", "/streams/streamer/forwards/target", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("streamer is not an admin", func(t *testing.T) {
+		forwarder := &mockTrackForwarder{}
+
+		w := request(forwarder, &core.User{ID: "streamer"}, "POST", "/streams/streamer/forwards", `{"track_id":"video","host":"10.0.0.1","port":5004}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, 0, len(forwarder.targets))
+	})
+
+	t.Run("stream is not live", func(t *testing.T) {
+		w := request(&mockTrackForwarder{}, admin, "POST", "/streams/offline/forwards", `{"track_id":"video","host":"10.0.0.1","port":5004}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		w = request(&mockTrackForwarder{}, admin, "GET", "/streams/offline/forwards", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+type mockStreamRecorder struct {
+	recording map[core.UserSessionID]bool
+}
+
+func (m *mockStreamRecorder) StartRecording(userID core.UserSessionID) error {
+	if m.recording[userID] {
+		return errors.New("participant is recorded already")
+	}
+	m.recording[userID] = true
+
+	return nil
+}
+
+func (m *mockStreamRecorder) StopRecording(userID core.UserSessionID) error {
+	if !m.recording[userID] {
+		return errors.New("participant is not recorded")
+	}
+	m.recording[userID] = false
+
+	return nil
+}
+
+func (m *mockStreamRecorder) Recordings(userID core.UserSessionID) ([]*core.Recording, error) {
+	return []*core.Recording{{UserID: userID, TrackID: "video", Status: core.RecordingFinished}}, nil
+}
+
+func TestStreamRecordingHandler(t *testing.T) {
+	request := func(recorder *mockStreamRecorder, user *core.User, method string, path string) *httptest.ResponseRecorder {
+		r := chi.NewRouter()
+		r.Use(withUser(user))
+		r.Post("/streams/{id}/recording", StreamRecordingHandler(recorder))
+		r.Delete("/streams/{id}/recording", StreamRecordingHandler(recorder))
+		r.Get("/streams/{id}/recordings", StreamRecordingsHandler(recorder))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+		return w
+	}
+
+	t.Run("streamer starts and stops the recording", func(t *testing.T) {
+		recorder := &mockStreamRecorder{recording: map[core.UserSessionID]bool{}}
+		streamer := &core.User{ID: "streamer"}
+
+		w := request(recorder, streamer, "POST", "/streams/streamer/recording")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, true, recorder.recording["streamer"])
+
+		w = request(recorder, streamer, "POST", "/streams/streamer/recording")
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = request(recorder, streamer, "DELETE", "/streams/streamer/recording")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, false, recorder.recording["streamer"])
+	})
+
+	t.Run("other user is forbidden", func(t *testing.T) {
+		recorder := &mockStreamRecorder{recording: map[core.UserSessionID]bool{}}
+
+		w := request(recorder, &core.User{ID: "viewer"}, "POST", "/streams/streamer/recording")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, false, recorder.recording["streamer"])
+	})
+
+	t.Run("admin lists the recordings", func(t *testing.T) {
+		recorder := &mockStreamRecorder{recording: map[core.UserSessionID]bool{}}
+
+		w := request(recorder, &core.User{ID: "admin", IsAdmin: true}, "GET", "/streams/streamer/recordings")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var recordings []*core.Recording
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&recordings))
+		assert.Equal(t, 1, len(recordings))
+		assert.Equal(t, core.UserSessionID("streamer"), recordings[0].UserID)
+	})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+// WHEPSubscriber runs the playback sessions of the players watching over WHEP
+type WHEPSubscriber interface {
+	// SubscribeWHEP returns the ID of the viewer and the answer with the gathered ICE candidates
+	SubscribeWHEP(streamID core.UserSessionID, offer webrtc.SessionDescription) (core.UserSessionID, *webrtc.SessionDescription, error)
+	AddWHEPCandidates(streamID core.UserSessionID, viewerID core.UserSessionID, candidates []webrtc.ICECandidateInit) error
+	StopWHEP(streamID core.UserSessionID, viewerID core.UserSessionID) error
+}
+
+// WHEPSubscribeHandler answers the SDP offer of the player with the tracks of the live stream.
+// The WHEP resource of the session is the URL of the endpoint followed by the ID of the viewer.
+func WHEPSubscribeHandler(subscriber WHEPSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasContentType(r, sdpContentType) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		offer, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescriptionSize))
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("read WHEP offer")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// The stream is not live or the offer can't be answered
+		streamID := core.UserSessionID(chi.URLParam(r, "streamID"))
+		viewerID, answer, err := subscriber.SubscribeWHEP(streamID, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offer)})
+		if err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("subscribe over WHEP")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", sdpContentType)
+		w.Header().Set("Location", path.Join(r.URL.Path, string(viewerID)))
+		w.WriteHeader(http.StatusCreated)
+		if _, err := io.WriteString(w, answer.SDP); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("write WHEP answer")
+		}
+	}
+}
+
+// WHEPTrickleHandler adds the ICE candidates of the SDP fragment to the WHEP session, the ICE restart is not supported
+func WHEPTrickleHandler(subscriber WHEPSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasContentType(r, trickleICEContentType) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		fragment, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescriptionSize))
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("read WHEP candidates")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		streamID := core.UserSessionID(chi.URLParam(r, "streamID"))
+		viewerID := core.UserSessionID(chi.URLParam(r, "viewerID"))
+		if err := subscriber.AddWHEPCandidates(streamID, viewerID, parseTrickleICEFragment(string(fragment))); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("add WHEP candidates")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// WHEPStopHandler ends the WHEP session
+func WHEPStopHandler(subscriber WHEPSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamID := core.UserSessionID(chi.URLParam(r, "streamID"))
+		viewerID := core.UserSessionID(chi.URLParam(r, "viewerID"))
+		if err := subscriber.StopWHEP(streamID, viewerID); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("streamID", string(streamID)).Msg("stop WHEP")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
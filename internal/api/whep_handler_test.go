@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type mockWHEPSubscriber struct {
+	viewers    map[core.UserSessionID]core.UserSessionID
+	candidates []webrtc.ICECandidateInit
+}
+
+func (m *mockWHEPSubscriber) SubscribeWHEP(streamID core.UserSessionID, offer webrtc.SessionDescription) (core.UserSessionID, *webrtc.SessionDescription, error) {
+	if streamID != "streamer" {
+		return "", nil, errors.New("room is not initialized")
+	}
+	m.viewers["viewer"] = streamID
+
+	return "viewer", &webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "answer to " + offer.SDP}, nil
+}
+
+func (m *mockWHEPSubscriber) AddWHEPCandidates(streamID core.UserSessionID, viewerID core.UserSessionID, candidates []webrtc.ICECandidateInit) error {
+	if m.viewers[viewerID] != streamID {
+		return errors.New("WHEP viewer is not found")
+	}
+	m.candidates = append(m.candidates, candidates...)
+
+	return nil
+}
+
+func (m *mockWHEPSubscriber) StopWHEP(streamID core.UserSessionID, viewerID core.UserSessionID) error {
+	if m.viewers[viewerID] != streamID {
+		return errors.New("WHEP viewer is not found")
+	}
+	delete(m.viewers, viewerID)
+
+	return nil
+}
+
+func TestWHEPHandlers(t *testing.T) {
+	subscriber := &mockWHEPSubscriber{viewers: map[core.UserSessionID]core.UserSessionID{}}
+
+	r := chi.NewRouter()
+	r.Post("/whep/{streamID}", WHEPSubscribeHandler(subscriber))
+	r.Patch("/whep/{streamID}/{viewerID}", WHEPTrickleHandler(subscriber))
+	r.Delete("/whep/{streamID}/{viewerID}", WHEPStopHandler(subscriber))
+
+	request := func(method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("stream is not live", func(t *testing.T) {
+		w := request("POST", "/whep/offline", sdpContentType, "offer")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("offer is not SDP", func(t *testing.T) {
+		w := request("POST", "/whep/streamer", "text/plain", "offer")
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("subscribe, trickle and stop", func(t *testing.T) {
+		w := request("POST", "/whep/streamer", sdpContentType, "offer")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "/whep/streamer/viewer", w.Header().Get("Location"))
+		assert.Equal(t, "answer to offer", w.Body.String())
+
+		fragment := "a=mid:0\r\na=candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host\r\n"
+		w = request("PATCH", "/whep/streamer/viewer", trickleICEContentType, fragment)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, 1, len(subscriber.candidates))
+
+		w = request("DELETE", "/whep/streamer/viewer", "", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request("PATCH", "/whep/streamer/viewer", trickleICEContentType, fragment)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
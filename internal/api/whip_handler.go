@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+const (
+	sdpContentType            = "application/sdp"
+	trickleICEContentType     = "application/trickle-ice-sdpfrag"
+	maxSessionDescriptionSize = 64 * 1024
+)
+
+// WHIPPublisher runs the sessions of the broadcast software publishing over WHIP (RFC 9725)
+type WHIPPublisher interface {
+	// PublishWHIP returns the answer with the gathered ICE candidates
+	PublishWHIP(userID core.UserSessionID, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error)
+	AddWHIPCandidates(userID core.UserSessionID, candidates []webrtc.ICECandidateInit) error
+	StopWHIP(userID core.UserSessionID) error
+}
+
+// WHIPPublishHandler starts the session of the owner of the stream key with the SDP offer of the body.
+// The WHIP resource of the session is the URL of the endpoint.
+func WHIPPublishHandler(users core.UserStorer, publisher WHIPPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasContentType(r, sdpContentType) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		user, status := whipUser(users, r)
+		if user == nil {
+			w.WriteHeader(status)
+			return
+		}
+
+		offer, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescriptionSize))
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("read WHIP offer")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// The offer is malformed or the user is in the session already
+		answer, err := publisher.PublishWHIP(user.ID, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offer)})
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Str("UserID", string(user.ID)).Msg("publish over WHIP")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", sdpContentType)
+		w.Header().Set("Location", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		if _, err := io.WriteString(w, answer.SDP); err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("write WHIP answer")
+		}
+	}
+}
+
+// WHIPTrickleHandler adds the ICE candidates of the SDP fragment to the WHIP session, the ICE restart is not supported
+func WHIPTrickleHandler(users core.UserStorer, publisher WHIPPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasContentType(r, trickleICEContentType) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		user, status := whipUser(users, r)
+		if user == nil {
+			w.WriteHeader(status)
+			return
+		}
+
+		fragment, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescriptionSize))
+		if err != nil {
+			log.Error().Err(err).Str("service", "web").Msg("read WHIP candidates")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := publisher.AddWHIPCandidates(user.ID, parseTrickleICEFragment(string(fragment))); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("UserID", string(user.ID)).Msg("add WHIP candidates")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// WHIPStopHandler ends the WHIP session
+func WHIPStopHandler(users core.UserStorer, publisher WHIPPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, status := whipUser(users, r)
+		if user == nil {
+			w.WriteHeader(status)
+			return
+		}
+
+		if err := publisher.StopWHIP(user.ID); err != nil {
+			log.Debug().Err(err).Str("service", "web").Str("UserID", string(user.ID)).Msg("stop WHIP")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// whipUser finds the owner of the stream key of the URL, the status is the response if there is no owner
+func whipUser(users core.UserStorer, r *http.Request) (*core.User, int) {
+	user, err := users.FindByStreamKey(chi.URLParam(r, "streamKey"))
+	if err != nil {
+		log.Error().Err(err).Str("service", "web").Msg("find user by stream key")
+		return nil, http.StatusInternalServerError
+	}
+	if user == nil {
+		return nil, http.StatusUnauthorized
+	}
+
+	return user, http.StatusOK
+}
+
+func hasContentType(r *http.Request, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	return err == nil && mediaType == contentType
+}
+
+// parseTrickleICEFragment reads the candidates of the SDP fragment (RFC 8840), every candidate follows the mid of its media
+func parseTrickleICEFragment(fragment string) []webrtc.ICECandidateInit {
+	candidates := make([]webrtc.ICECandidateInit, 0)
+
+	var mid *string
+	var mLineIndex uint16
+	mLines := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(fragment))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "m="):
+			mLineIndex = uint16(mLines)
+			mLines++
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			index := mLineIndex
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate:     strings.TrimPrefix(line, "a="),
+				SDPMid:        mid,
+				SDPMLineIndex: &index,
+			})
+		}
+	}
+
+	return candidates
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type mockUserStorer struct {
+	streamKeys map[string]*core.User
+}
+
+func (m *mockUserStorer) FindByUID(uid string) (*core.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockUserStorer) Find(id string) (*core.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockUserStorer) AuthAdminUser(email string, password string) (*core.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockUserStorer) FindByStreamKey(key string) (*core.User, error) {
+	return m.streamKeys[key], nil
+}
+
+func (m *mockUserStorer) ResetStreamKey(id core.UserSessionID) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+type mockWHIPPublisher struct {
+	sessions   map[core.UserSessionID]bool
+	candidates []webrtc.ICECandidateInit
+}
+
+func (m *mockWHIPPublisher) PublishWHIP(userID core.UserSessionID, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if m.sessions[userID] {
+		return nil, errors.New("user is already in session")
+	}
+	m.sessions[userID] = true
+
+	return &webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "answer to " + offer.SDP}, nil
+}
+
+func (m *mockWHIPPublisher) AddWHIPCandidates(userID core.UserSessionID, candidates []webrtc.ICECandidateInit) error {
+	if !m.sessions[userID] {
+		return errors.New("room is not initialized")
+	}
+	m.candidates = append(m.candidates, candidates...)
+
+	return nil
+}
+
+func (m *mockWHIPPublisher) StopWHIP(userID core.UserSessionID) error {
+	if !m.sessions[userID] {
+		return errors.New("room is not initialized")
+	}
+	delete(m.sessions, userID)
+
+	return nil
+}
+
+func TestWHIPHandlers(t *testing.T) {
+	users := &mockUserStorer{
+		streamKeys: map[string]*core.User{"secret": {ID: "streamer"}},
+	}
+	publisher := &mockWHIPPublisher{sessions: map[core.UserSessionID]bool{}}
+
+	r := chi.NewRouter()
+	r.Route("/whip/{streamKey}", func(r chi.Router) {
+		r.Post("/", WHIPPublishHandler(users, publisher))
+		r.Patch("/", WHIPTrickleHandler(users, publisher))
+		r.Delete("/", WHIPStopHandler(users, publisher))
+	})
+
+	request := func(method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("unknown stream key", func(t *testing.T) {
+		w := request("POST", "/whip/wrong", sdpContentType, "offer")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("offer is not SDP", func(t *testing.T) {
+		w := request("POST", "/whip/secret", "application/json", "{}")
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("publish, trickle and stop", func(t *testing.T) {
+		w := request("POST", "/whip/secret", sdpContentType, "offer")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, sdpContentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, "/whip/secret", w.Header().Get("Location"))
+		assert.Equal(t, "answer to offer", w.Body.String())
+
+		w = request("POST", "/whip/secret", sdpContentType, "offer")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		fragment := "a=ice-ufrag:abcd\r\na=ice-pwd:efgh\r\nm=audio 9 UDP/TLS/RTP/SAVPF 0\r\na=mid:0\r\n" +
+			"a=candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host\r\na=end-of-candidates\r\n"
+		w = request("PATCH", "/whip/secret", trickleICEContentType, fragment)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, 1, len(publisher.candidates))
+
+		w = request("DELETE", "/whip/secret", "", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request("DELETE", "/whip/secret", "", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestParseTrickleICEFragment(t *testing.T) {
+	fragment := "a=ice-ufrag:abcd\r\n" +
+		"a=ice-pwd:efgh\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=mid:0\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=mid:1\r\n" +
+		"a=candidate:2 1 udp 2130706431 192.0.2.1 5002 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+
+	candidates := parseTrickleICEFragment(fragment)
+
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, "candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host", candidates[0].Candidate)
+	assert.Equal(t, "0", *candidates[0].SDPMid)
+	assert.Equal(t, uint16(0), *candidates[0].SDPMLineIndex)
+	assert.Equal(t, "1", *candidates[1].SDPMid)
+	assert.Equal(t, uint16(1), *candidates[1].SDPMLineIndex)
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"net"
+	"time"
+
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 )
@@ -12,6 +15,11 @@ const (
 	CongestionControlProbeModeMedia   CongestionControlProbeMode = "media"
 
 	frameMarking = "urn:ietf:params:rtp-hdrext:framemarking"
+	// layers of the AV1 SVC stream
+	DependencyDescriptorURI = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
+	// read buffer of the ICE-TCP connections, bytes
+	iceTCPReadBufferSize = 64 * 1024
 )
 
 var DefaultStunServers = []string{
@@ -20,20 +28,72 @@ var DefaultStunServers = []string{
 }
 
 type Config struct {
-	Peer PeerConfig
-	RTC  RTCConfig
+	Peer      PeerConfig
+	RTC       RTCConfig
+	Recording RecordingConfig
+}
+
+type RecordingConfig struct {
+	// the recordings of the user are written into its subdirectory
+	Dir string
 }
 
 type TranscoderConfig struct {
 	PortStart int
 	PortEnd   int
+	// udp or unix, how the packets of the tracks reach the transcoder: unix sends them to the sockets
+	// of the transcode daemon, which relays them to ffmpeg over the loopback UDP
+	Transport string
+	// host of the transcoder receiving over UDP
+	Host string
+	// directory of the unix sockets of the transcoder inputs, shared with the transcode daemon
+	SocketDir string
+	// directory of the lock files of the ports shared by the SFU instances of the host
+	PortsLockDir string
+}
+
+type TURNConfig struct {
+	Enabled bool
+	// host the clients connect to
+	Host string
+	// IP of the relayed addresses
+	PublicIP string
+	Port     int
+	Realm    string
+	// shared secret of the short-lived credentials
+	Secret         string
+	CredentialTTL  time.Duration
+	RelayPortStart uint16
+	RelayPortEnd   uint16
+}
+
+type DataChannelConfig struct {
+	// maximum size of the message of the participant, bytes
+	MaxMessageSize int
+	// the participant sends MessagesPerSecond on average and up to MessagesBurst at once
+	MessagesPerSecond float64
+	MessagesBurst     int
+	// the same limits of the lossy data channel
+	LossyMessagesPerSecond float64
+	LossyMessagesBurst     int
 }
 
 type RTCConfig struct {
-	ICEPortRangeStart   uint32
-	ICEPortRangeEnd     uint32
-	Transcoder          TranscoderConfig
-	Interfaces          InterfacesConfig
+	ICEPortRangeStart uint32
+	ICEPortRangeEnd   uint32
+	// single port for ICE over UDP shared by every peer connection, the port range is used if zero
+	ICEUDPPort int
+	// port of the passive ICE-TCP candidates, ICE-TCP is disabled if zero
+	ICETCPPort        int
+	Transcoder        TranscoderConfig
+	Interfaces        InterfacesConfig
+	CongestionControl CongestionControlConfig
+	// retransmit lost packets to subscribers over RTX stream instead of the media one
+	SubscriberRTX bool
+	// how long the participant is kept after its connection has failed, waiting for the ICE restart
+	ReconnectTimeout time.Duration
+	TURN             TURNConfig
+	DataChannel      DataChannelConfig
 }
 
 type CodecSpec struct {
@@ -42,10 +102,14 @@ type CodecSpec struct {
 }
 
 type WebRTCConfig struct {
-	Configuration webrtc.Configuration
-	SettingEngine webrtc.SettingEngine
-	Publisher     DirectionConfig
-	Subscriber    DirectionConfig
+	Configuration     webrtc.Configuration
+	SettingEngine     webrtc.SettingEngine
+	Publisher         DirectionConfig
+	Subscriber        DirectionConfig
+	CongestionControl CongestionControlConfig
+	ReconnectTimeout  time.Duration
+	TURN              TURNConfig
+	DataChannel       DataChannelConfig
 }
 
 type RTPHeaderExtensionConfig struct {
@@ -61,16 +125,18 @@ type RTCPFeedbackConfig struct {
 type DirectionConfig struct {
 	RTPHeaderExtension RTPHeaderExtensionConfig
 	RTCPFeedback       RTCPFeedbackConfig
+	// negotiate RTX (RFC 4588) for retransmissions of video
+	RTX bool
 }
 
 type InterfacesConfig struct {
 	Includes []string
 }
 
-type EnabledCodecs map[webrtc.RTPCodecType]CodecSpec
+// EnabledCodecs lists the codecs in the order of the preference, the empty fmtp enables every profile of the codec
+type EnabledCodecs []CodecSpec
 
 type PeerConfig struct {
-	// We support only one codec per type
 	EnabledCodecs EnabledCodecs
 }
 
@@ -86,22 +152,56 @@ func NewConfig() *Config {
 	// TODO: extract to yaml
 	conf := &Config{
 		RTC: RTCConfig{
-			ICEPortRangeStart:   50000,
-			ICEPortRangeEnd:     60000,
+			ICEPortRangeStart: 50000,
+			ICEPortRangeEnd:   60000,
 			Transcoder: TranscoderConfig{
-				PortStart: 4000,
-				PortEnd:   5000,
+				PortStart:    4000,
+				PortEnd:      5000,
+				Transport:    "udp",
+				Host:         "127.0.0.1",
+				SocketDir:    "/tmp/livelook-transcoder",
+				PortsLockDir: "/tmp/livelook-ports",
 			},
 			Interfaces: InterfacesConfig{
 				Includes: []string{"wlp0s20u9", "enp3s0"},
 			},
+			CongestionControl: CongestionControlConfig{
+				Enabled:            true,
+				AllowPause:         false,
+				UseSendSideBWE:     true,
+				ProbeMode:          CongestionControlProbeModePadding,
+				MinChannelCapacity: 100_000,
+			},
+			SubscriberRTX:    true,
+			ReconnectTimeout: 20 * time.Second,
+			TURN: TURNConfig{
+				Enabled:        false,
+				Port:           3478,
+				Realm:          "livelook",
+				CredentialTTL:  24 * time.Hour,
+				RelayPortStart: 49152,
+				RelayPortEnd:   49999,
+			},
+			DataChannel: DataChannelConfig{
+				MaxMessageSize:         16 * 1024,
+				MessagesPerSecond:      5,
+				MessagesBurst:          10,
+				LossyMessagesPerSecond: 60,
+				LossyMessagesBurst:     30,
+			},
 		},
 		Peer: PeerConfig{
-			EnabledCodecs: map[webrtc.RTPCodecType]CodecSpec{
-				// webrtc.RTPCodecTypeAudio: {Mime: webrtc.MimeTypeOpus}, Without audio for now
-				webrtc.RTPCodecTypeVideo: {Mime: webrtc.MimeTypeVP8},
+			EnabledCodecs: EnabledCodecs{
+				{Mime: webrtc.MimeTypeOpus},
+				{Mime: webrtc.MimeTypeVP8},
+				{Mime: webrtc.MimeTypeH264},
+				{Mime: webrtc.MimeTypeVP9, FmtpLine: "profile-id=0"},
+				{Mime: webrtc.MimeTypeAV1},
 			},
 		},
+		Recording: RecordingConfig{
+			Dir: "recordings",
+		},
 	}
 
 	return conf
@@ -130,14 +230,33 @@ func NewWebRTCConfig(config *Config) (*WebRTCConfig, error) {
 	// }
 
 	networkTypes := make([]webrtc.NetworkType, 0, 4)
-	// Use only UDP
 	networkTypes = append(networkTypes,
 		webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6,
 	)
-	// TODO: configure it
-	if err := s.SetEphemeralUDPPortRange(uint16(config.RTC.ICEPortRangeStart), uint16(config.RTC.ICEPortRangeEnd)); err != nil {
+
+	if rtcConf.ICEUDPPort != 0 {
+		// All peer connections share the single UDP port
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: rtcConf.ICEUDPPort})
+		if err != nil {
+			return nil, err
+		}
+		s.SetICEUDPMux(webrtc.NewICEUDPMux(nil, udpConn))
+	} else if err := s.SetEphemeralUDPPortRange(uint16(rtcConf.ICEPortRangeStart), uint16(rtcConf.ICEPortRangeEnd)); err != nil {
 		return nil, err
 	}
+
+	// Passive TCP candidates for the networks where UDP is blocked
+	if rtcConf.ICETCPPort != 0 {
+		tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: rtcConf.ICETCPPort})
+		if err != nil {
+			return nil, err
+		}
+		s.SetICETCPMux(webrtc.NewICETCPMux(nil, tcpListener, iceTCPReadBufferSize))
+
+		networkTypes = append(networkTypes,
+			webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6,
+		)
+	}
 	s.SetNetworkTypes(networkTypes)
 
 	// publisher configuration
@@ -153,6 +272,7 @@ func NewWebRTCConfig(config *Config) (*WebRTCConfig, error) {
 				sdp.SDESRTPStreamIDURI,
 				sdp.TransportCCURI,
 				frameMarking,
+				DependencyDescriptorURI,
 			},
 		},
 		RTCPFeedback: RTCPFeedbackConfig{
@@ -168,6 +288,7 @@ func NewWebRTCConfig(config *Config) (*WebRTCConfig, error) {
 
 	// subscriber configuration
 	subscriberConfig := DirectionConfig{
+		RTX: rtcConf.SubscriberRTX,
 		RTCPFeedback: RTCPFeedbackConfig{
 			Video: []webrtc.RTCPFeedback{
 				{Type: webrtc.TypeRTCPFBCCM, Parameter: "fir"},
@@ -177,17 +298,30 @@ func NewWebRTCConfig(config *Config) (*WebRTCConfig, error) {
 		},
 	}
 
-	// By default set this RTP extensions
-	// See the https://webrtc.googlesource.com/src/+/main/docs/native-code/rtp-hdrext/index.md
-	subscriberConfig.RTPHeaderExtension.Video = append(
-		subscriberConfig.RTPHeaderExtension.Video,
-		sdp.ABSSendTimeURI,
-	)
+	if rtcConf.CongestionControl.UseSendSideBWE {
+		// Send side bandwidth estimation (GCC) on transport wide feedback of the subscriber
+		subscriberConfig.RTPHeaderExtension.Video = append(
+			subscriberConfig.RTPHeaderExtension.Video,
+			sdp.TransportCCURI,
+		)
 
-	subscriberConfig.RTCPFeedback.Video = append(
-		subscriberConfig.RTCPFeedback.Video,
-		webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBGoogREMB},
-	)
+		subscriberConfig.RTCPFeedback.Video = append(
+			subscriberConfig.RTCPFeedback.Video,
+			webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC},
+		)
+	} else {
+		// By default set this RTP extensions
+		// See the https://webrtc.googlesource.com/src/+/main/docs/native-code/rtp-hdrext/index.md
+		subscriberConfig.RTPHeaderExtension.Video = append(
+			subscriberConfig.RTPHeaderExtension.Video,
+			sdp.ABSSendTimeURI,
+		)
+
+		subscriberConfig.RTCPFeedback.Video = append(
+			subscriberConfig.RTCPFeedback.Video,
+			webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBGoogREMB},
+		)
+	}
 
 	// Filter interfaces
 	if len(rtcConf.Interfaces.Includes) != 0 {
@@ -207,9 +341,13 @@ func NewWebRTCConfig(config *Config) (*WebRTCConfig, error) {
 	}
 
 	return &WebRTCConfig{
-		Configuration: c,
-		SettingEngine: s,
-		Publisher:     publisherConfig,
-		Subscriber:    subscriberConfig,
+		Configuration:     c,
+		SettingEngine:     s,
+		Publisher:         publisherConfig,
+		Subscriber:        subscriberConfig,
+		CongestionControl: rtcConf.CongestionControl,
+		ReconnectTimeout:  rtcConf.ReconnectTimeout,
+		TURN:              rtcConf.TURN,
+		DataChannel:       rtcConf.DataChannel,
 	}, nil
 }
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type RecordingStatus string
+
+const (
+	RecordingInProgress RecordingStatus = "recording"
+	RecordingFinished   RecordingStatus = "finished"
+	RecordingFailed     RecordingStatus = "failed"
+)
+
+// Recording is the file of the published track recorded by the SFU
+type Recording struct {
+	ID         int64           `json:"id" db:"id"`
+	UserID     UserSessionID   `json:"user_id" db:"user_id"`
+	TrackID    string          `json:"track_id" db:"track_id"`
+	Kind       string          `json:"kind" db:"kind"`
+	MimeType   string          `json:"mime_type" db:"mime_type"`
+	Filename   string          `json:"filename" db:"filename"`
+	Size       int64           `json:"size" db:"size"`
+	Status     RecordingStatus `json:"status" db:"status"`
+	StartedAt  time.Time       `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+}
+
+type RecordingsDBStorer interface {
+	Create(*Recording) error
+	Finish(filename string, size int64, status RecordingStatus) error
+	FindByUserID(userID UserSessionID) ([]*Recording, error)
+}
+
+type RecordingsRepository struct {
+	db *sqlx.DB
+}
+
+func NewRecordingsRepository(db *sqlx.DB) RecordingsDBStorer {
+	return &RecordingsRepository{
+		db: db,
+	}
+}
+
+func (r *RecordingsRepository) Create(recording *Recording) error {
+	return r.db.Get(recording,
+		`INSERT INTO recordings
+			(user_id, track_id, kind, mime_type, filename, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, size, created_at`,
+		string(recording.UserID),
+		recording.TrackID,
+		recording.Kind,
+		recording.MimeType,
+		recording.Filename,
+		string(RecordingInProgress),
+		recording.StartedAt,
+	)
+}
+
+// Finish sets the final size and the status of the recorded file
+func (r *RecordingsRepository) Finish(filename string, size int64, status RecordingStatus) error {
+	_, err := r.db.Exec(
+		`UPDATE recordings SET
+			size = $1,
+			status = $2,
+			finished_at = NOW()
+		WHERE filename = $3`,
+		size,
+		string(status),
+		filename,
+	)
+	return err
+}
+
+func (r *RecordingsRepository) FindByUserID(userID UserSessionID) ([]*Recording, error) {
+	recordings := []*Recording{}
+
+	err := r.db.Select(&recordings,
+		`SELECT
+			id,
+			user_id,
+			track_id,
+			kind,
+			mime_type,
+			filename,
+			size,
+			status,
+			started_at,
+			finished_at,
+			created_at
+		FROM recordings
+		WHERE user_id = $1
+		ORDER BY started_at DESC`,
+		string(userID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return recordings, nil
+}
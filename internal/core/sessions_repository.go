@@ -19,6 +19,7 @@ type SessionsDBStorer interface {
 	SetOffline(userID UserSessionID) error
 	StartPublish(userID UserSessionID) error
 	StopPublish(userID UserSessionID) error
+	SetBroadcastState(userID UserSessionID, state SessionState) error
 	FindByUserID(userID UserSessionID) (*Session, error)
 }
 
@@ -175,6 +176,21 @@ func (r *SessionsRepository) StopPublish(userID UserSessionID) error {
 	return err
 }
 
+// SetBroadcastState switches the broadcasting session between single and multi publisher states
+func (r *SessionsRepository) SetBroadcastState(userID UserSessionID, state SessionState) error {
+	_, err := r.db.Exec(
+		`UPDATE sessions SET
+			updated_at = NOW(),
+			state = $1
+		WHERE user_id = $2 AND state IN ($3, $4)`,
+		string(state),
+		string(userID),
+		string(SingleBroadcast),
+		string(MultiBroadcast),
+	)
+	return err
+}
+
 func (r *SessionsRepository) FindByUserID(userID UserSessionID) (*Session, error) {
 	session := &Session{}
 
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,6 +24,8 @@ type User struct {
 	IsAdmin   bool          `json:"-" db:"is_admin"`
 	Email     *string       `json:"-" db:"email"`
 	Password  *string       `json:"-" db:"password"`
+	// authenticates the broadcast software publishing over WHIP
+	StreamKey *string `json:"-" db:"stream_key"`
 }
 
 // NewUser creates new user subject
@@ -29,6 +33,16 @@ func NewUser() *User {
 	return &User{ID: UserSessionID(uuid.New().String())}
 }
 
+// NewStreamKey generates the random secret key of the user's stream
+func NewStreamKey() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
 func FindUserByUID(db *sqlx.DB, uid string) (*User, error) {
 	user := &User{}
 
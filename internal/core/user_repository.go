@@ -10,6 +10,10 @@ type UserStorer interface {
 	FindByUID(uid string) (*User, error)
 	Find(id string) (*User, error)
 	AuthAdminUser(email string, password string) (*User, error)
+	// FindByStreamKey returns nil if no user has the key
+	FindByStreamKey(key string) (*User, error)
+	// ResetStreamKey replaces the stream key of the user with the new one
+	ResetStreamKey(id UserSessionID) (string, error)
 }
 
 type UserRepository struct {
@@ -60,3 +64,30 @@ func (r *UserRepository) AuthAdminUser(email string, password string) (*User, er
 
 	return u, nil
 }
+
+func (r *UserRepository) FindByStreamKey(key string) (*User, error) {
+	user := &User{}
+
+	err := r.db.Get(user, `SELECT * FROM users WHERE stream_key = $1 LIMIT 1`, key)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+func (r *UserRepository) ResetStreamKey(id UserSessionID) (string, error) {
+	key, err := NewStreamKey()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := r.db.Exec(`UPDATE users SET stream_key = $1 WHERE id = $2`, key, string(id)); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
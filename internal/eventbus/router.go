@@ -12,12 +12,19 @@ import (
 )
 
 var (
-	errConvertIceCandidate   = errors.New("can't convert to ice candidate")
-	errConvertOffer          = errors.New("can't convert to offer")
-	errConvertJoin           = errors.New("can't convert to join")
-	errConvertSubscribeRPC   = errors.New("can't convert to subscribe rpc")
-	errConvertUnsubscribeRPC = errors.New("can't convert to unsubscribe rpc")
-	errUndefinedMethod       = errors.New("undefined method")
+	errConvertIceCandidate    = errors.New("can't convert to ice candidate")
+	errConvertOffer           = errors.New("can't convert to offer")
+	errConvertAnswer          = errors.New("can't convert to answer")
+	errConvertJoin            = errors.New("can't convert to join")
+	errConvertSubscribeRPC    = errors.New("can't convert to subscribe rpc")
+	errConvertUnsubscribeRPC  = errors.New("can't convert to unsubscribe rpc")
+	errConvertVideoQuality    = errors.New("can't convert to video quality rpc")
+	errConvertInvite          = errors.New("can't convert to invite publisher rpc")
+	errConvertAcceptInvite    = errors.New("can't convert to accept invite rpc")
+	errConvertRemovePublisher = errors.New("can't convert to remove publisher rpc")
+	errConvertLossyDataPolicy = errors.New("can't convert to lossy data policy rpc")
+	errConvertMuteTrack       = errors.New("can't convert to mute track rpc")
+	errUndefinedMethod        = errors.New("undefined method")
 )
 
 // Router - Внутренний маршрутиризатор RPC-вызовов
@@ -31,12 +38,22 @@ type Router struct {
 
 	onAddICECandidate       func(core.UserSessionID, rpc.ICECandidateParams) error
 	onOffer                 func(core.UserSessionID, rpc.SDPParams) error
+	onAnswer                func(core.UserSessionID, rpc.SDPParams) error
 	onJoin                  func(core.UserSessionID) error
 	onCloseSession          func(core.UserSessionID) error
 	onPublishStream         func(core.UserSessionID) error
 	onStopStream            func(core.UserSessionID) error
 	onSubscribeStream       func(userID core.UserSessionID, streamUserID core.UserSessionID) error
 	onSubscribeStreamCancel func(userID core.UserSessionID, streamUserID core.UserSessionID) error
+	onVideoQuality          func(userID core.UserSessionID, params rpc.VideoQualityParams) error
+	onInvitePublisher       func(userID core.UserSessionID, params rpc.InvitePublisherParams) error
+	onAcceptInvite          func(userID core.UserSessionID, params rpc.RoomParams) error
+	onRemovePublisher       func(userID core.UserSessionID, params rpc.RemovePublisherParams) error
+	onLossyDataPolicy       func(userID core.UserSessionID, params rpc.LossyDataPolicyParams) error
+	onMuteTrack             func(userID core.UserSessionID, params rpc.MuteTrackParams) error
+	onUnmuteTrack           func(userID core.UserSessionID, params rpc.MuteTrackParams) error
+	onStartRecording        func(core.UserSessionID) error
+	onStopRecording         func(core.UserSessionID) error
 }
 
 func NewRouter(sub Subscriber) (*Router, error) {
@@ -107,6 +124,16 @@ func (router *Router) Start() chan struct{} {
 					if err := router.onOffer(userID, msg.Params); err != nil {
 						log.Error().Err(err).Str("service", "router").Msg("error occured in onOffer")
 					}
+				case rpc.SDPAnswerMethod:
+					msg, ok := r.(*rpc.SDPRpc)
+					if !ok {
+						log.Error().Err(errConvertAnswer).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onAnswer(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("error occured in onAnswer")
+					}
 				case rpc.CloseSessionMethod:
 					if err := router.onCloseSession(userID); err != nil {
 						log.Error().Err(err).Str("service", "router").Msg("close session error")
@@ -139,6 +166,84 @@ func (router *Router) Start() chan struct{} {
 					if err := router.onSubscribeStreamCancel(userID, msg.Params.UserID); err != nil {
 						log.Error().Err(err).Str("service", "router").Msg("cancel subscribe to stream error")
 					}
+				case rpc.VideoQualityMethod:
+					msg, ok := r.(*rpc.VideoQualityRpc)
+					if !ok {
+						log.Error().Err(errConvertVideoQuality).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onVideoQuality(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("video quality error")
+					}
+				case rpc.InvitePublisherMethod:
+					msg, ok := r.(*rpc.InvitePublisherRpc)
+					if !ok {
+						log.Error().Err(errConvertInvite).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onInvitePublisher(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("invite publisher error")
+					}
+				case rpc.AcceptInviteMethod:
+					msg, ok := r.(*rpc.AcceptInviteRpc)
+					if !ok {
+						log.Error().Err(errConvertAcceptInvite).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onAcceptInvite(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("accept invite error")
+					}
+				case rpc.RemovePublisherMethod:
+					msg, ok := r.(*rpc.RemovePublisherRpc)
+					if !ok {
+						log.Error().Err(errConvertRemovePublisher).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onRemovePublisher(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("remove publisher error")
+					}
+				case rpc.LossyDataPolicyMethod:
+					msg, ok := r.(*rpc.LossyDataPolicyRpc)
+					if !ok {
+						log.Error().Err(errConvertLossyDataPolicy).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onLossyDataPolicy(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("lossy data policy error")
+					}
+				case rpc.MuteTrackMethod:
+					msg, ok := r.(*rpc.MuteTrackRpc)
+					if !ok {
+						log.Error().Err(errConvertMuteTrack).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onMuteTrack(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("mute track error")
+					}
+				case rpc.UnmuteTrackMethod:
+					msg, ok := r.(*rpc.MuteTrackRpc)
+					if !ok {
+						log.Error().Err(errConvertMuteTrack).Str("service", "router").Msg("")
+						continue
+					}
+
+					if err := router.onUnmuteTrack(userID, msg.Params); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("unmute track error")
+					}
+				case rpc.StartRecordingMethod:
+					if err := router.onStartRecording(userID); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("start recording error")
+					}
+				case rpc.StopRecordingMethod:
+					if err := router.onStopRecording(userID); err != nil {
+						log.Error().Err(err).Str("service", "router").Msg("stop recording error")
+					}
 				default:
 					log.Error().Err(errUndefinedMethod).Str("rpcMethod", string(r.GetMethod())).Str("service", "router").Msg("")
 				}
@@ -195,6 +300,10 @@ func (router *Router) OnOffer(callback func(core.UserSessionID, rpc.SDPParams) e
 	router.onOffer = callback
 }
 
+func (router *Router) OnAnswer(callback func(core.UserSessionID, rpc.SDPParams) error) {
+	router.onAnswer = callback
+}
+
 func (router *Router) OnCloseSession(callback func(core.UserSessionID) error) {
 	router.onCloseSession = callback
 }
@@ -214,3 +323,39 @@ func (router *Router) OnSubscribeStream(callback func(userID core.UserSessionID,
 func (router *Router) OnSubscribeStreamCancel(callback func(userID core.UserSessionID, streamUserID core.UserSessionID) error) {
 	router.onSubscribeStreamCancel = callback
 }
+
+func (router *Router) OnVideoQuality(callback func(userID core.UserSessionID, params rpc.VideoQualityParams) error) {
+	router.onVideoQuality = callback
+}
+
+func (router *Router) OnInvitePublisher(callback func(userID core.UserSessionID, params rpc.InvitePublisherParams) error) {
+	router.onInvitePublisher = callback
+}
+
+func (router *Router) OnAcceptInvite(callback func(userID core.UserSessionID, params rpc.RoomParams) error) {
+	router.onAcceptInvite = callback
+}
+
+func (router *Router) OnRemovePublisher(callback func(userID core.UserSessionID, params rpc.RemovePublisherParams) error) {
+	router.onRemovePublisher = callback
+}
+
+func (router *Router) OnLossyDataPolicy(callback func(userID core.UserSessionID, params rpc.LossyDataPolicyParams) error) {
+	router.onLossyDataPolicy = callback
+}
+
+func (router *Router) OnMuteTrack(callback func(userID core.UserSessionID, params rpc.MuteTrackParams) error) {
+	router.onMuteTrack = callback
+}
+
+func (router *Router) OnUnmuteTrack(callback func(userID core.UserSessionID, params rpc.MuteTrackParams) error) {
+	router.onUnmuteTrack = callback
+}
+
+func (router *Router) OnStartRecording(callback func(core.UserSessionID) error) {
+	router.onStartRecording = callback
+}
+
+func (router *Router) OnStopRecording(callback func(core.UserSessionID) error) {
+	router.onStopRecording = callback
+}
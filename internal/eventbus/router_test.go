@@ -19,10 +19,20 @@ type MockCallbacks struct {
 	JoinCallbackFired            bool
 	AddICECandidateCallbackFired bool
 	OnOfferFired                 bool
+	OnAnswerFired                bool
 	OnPublishStreamFired         bool
 	OnStopStreamFired            bool
 	OnSubscribeStreamFired       bool
 	OnSubscribeStreamCancelFired bool
+	OnVideoQualityFired          bool
+	OnInvitePublisherFired       bool
+	OnAcceptInviteFired          bool
+	OnRemovePublisherFired       bool
+	OnLossyDataPolicyFired       bool
+	OnMuteTrackFired             bool
+	OnUnmuteTrackFired           bool
+	OnStartRecordingFired        bool
+	OnStopRecordingFired         bool
 }
 
 func (m *MockCallbacks) JoinMockCallback(userID core.UserSessionID) error {
@@ -42,6 +52,12 @@ func (m *MockCallbacks) OnOffer(userID core.UserSessionID, sdp rpc.SDPParams) er
 	return nil
 }
 
+func (m *MockCallbacks) OnAnswer(userID core.UserSessionID, sdp rpc.SDPParams) error {
+	m.OnAnswerFired = true
+
+	return nil
+}
+
 func (m *MockCallbacks) OnPublishStream(userID core.UserSessionID) error {
 	m.OnPublishStreamFired = true
 
@@ -66,6 +82,60 @@ func (m *MockCallbacks) OnSubscribeStreamCancel(userID core.UserSessionID, strea
 	return nil
 }
 
+func (m *MockCallbacks) OnVideoQuality(userID core.UserSessionID, params rpc.VideoQualityParams) error {
+	m.OnVideoQualityFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnInvitePublisher(userID core.UserSessionID, params rpc.InvitePublisherParams) error {
+	m.OnInvitePublisherFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnAcceptInvite(userID core.UserSessionID, params rpc.RoomParams) error {
+	m.OnAcceptInviteFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnRemovePublisher(userID core.UserSessionID, params rpc.RemovePublisherParams) error {
+	m.OnRemovePublisherFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnLossyDataPolicy(userID core.UserSessionID, params rpc.LossyDataPolicyParams) error {
+	m.OnLossyDataPolicyFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnMuteTrack(userID core.UserSessionID, params rpc.MuteTrackParams) error {
+	m.OnMuteTrackFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnUnmuteTrack(userID core.UserSessionID, params rpc.MuteTrackParams) error {
+	m.OnUnmuteTrackFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnStartRecording(userID core.UserSessionID) error {
+	m.OnStartRecordingFired = true
+
+	return nil
+}
+
+func (m *MockCallbacks) OnStopRecording(userID core.UserSessionID) error {
+	m.OnStopRecordingFired = true
+
+	return nil
+}
+
 func TestNewRouter(t *testing.T) {
 	mockBus := NewMockBus()
 	defer mockBus.Close()
@@ -156,6 +226,28 @@ func TestOnOffer(t *testing.T) {
 	assert.Equal(t, true, callbacks.OnOfferFired)
 }
 
+func TestOnAnswer(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.SDPAnswerMethod, `{"type":"answer","sdp":"","target":"receiver"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnAnswer(callbacks.OnAnswer)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnAnswerFired)
+}
+
 func TestOnPublishStream(t *testing.T) {
 	payload, err := mockServerMessagePayload(rpc.PublishStreamMethod, "{}")
 	assert.Nil(t, err)
@@ -244,6 +336,204 @@ func TestOnSubscribeStreamCancel(t *testing.T) {
 	assert.Equal(t, true, callbacks.OnSubscribeStreamCancelFired)
 }
 
+func TestOnVideoQuality(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.VideoQualityMethod, `{"user_id":"foo","quality":"low"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnVideoQuality(callbacks.OnVideoQuality)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnVideoQualityFired)
+}
+
+func TestOnInvitePublisher(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.InvitePublisherMethod, `{"user_id":"foo"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnInvitePublisher(callbacks.OnInvitePublisher)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnInvitePublisherFired)
+}
+
+func TestOnAcceptInvite(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.AcceptInviteMethod, `{"room_id":"foo"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnAcceptInvite(callbacks.OnAcceptInvite)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnAcceptInviteFired)
+}
+
+func TestOnRemovePublisher(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.RemovePublisherMethod, `{"room_id":"foo","user_id":"bar"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnRemovePublisher(callbacks.OnRemovePublisher)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnRemovePublisherFired)
+}
+
+func TestOnLossyDataPolicy(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.LossyDataPolicyMethod, `{"policy":"everyone"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnLossyDataPolicy(callbacks.OnLossyDataPolicy)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnLossyDataPolicyFired)
+}
+
+func TestOnMuteTrack(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.MuteTrackMethod, `{"track_id":"video"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnMuteTrack(callbacks.OnMuteTrack)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnMuteTrackFired)
+}
+
+func TestOnUnmuteTrack(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.UnmuteTrackMethod, `{"room_id":"owner","user_id":"guest"}`)
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnUnmuteTrack(callbacks.OnUnmuteTrack)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnUnmuteTrackFired)
+}
+
+func TestOnStartRecording(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.StartRecordingMethod, "{}")
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnStartRecording(callbacks.OnStartRecording)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnStartRecordingFired)
+}
+
+func TestOnStopRecording(t *testing.T) {
+	payload, err := mockServerMessagePayload(rpc.StopRecordingMethod, "{}")
+	assert.Nil(t, err)
+
+	callbacks := &MockCallbacks{}
+
+	mockBus := NewMockBus()
+
+	s := NewMockSubscriber(mockBus)
+	router, err := NewRouter(s)
+	assert.Nil(t, err)
+
+	router.OnStopRecording(callbacks.OnStopRecording)
+
+	<-router.Start()
+	msg := &redis.Message{Payload: string(payload[:])}
+	mockBus.Messages <- msg
+	<-router.Stop()
+
+	assert.Equal(t, true, callbacks.OnStopRecordingFired)
+}
+
 func mockServerMessagePayload(method rpc.Method, params string) ([]byte, error) {
 	rpcBytes := []byte(fmt.Sprintf(
 		`{"jsonrpc":"2.0","method":"%s","params":%s}`,
@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+// AcceptInviteRpc is sent by the invited user to join the room as a co-publisher
+type AcceptInviteRpc struct {
+	jsonRpcHead
+	Params RoomParams `json:"params"`
+}
+
+func NewAcceptInviteRpc(roomID core.UserSessionID) *AcceptInviteRpc {
+	return &AcceptInviteRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  AcceptInviteMethod,
+		},
+		Params: RoomParams{roomID},
+	}
+}
+
+func (r AcceptInviteRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r AcceptInviteRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type ActiveSpeaker struct {
+	UserID core.UserSessionID `json:"user_id"`
+	// smoothed loudness, from 0 (silence) to 1 (the loudest)
+	Level float64 `json:"level"`
+}
+
+type ActiveSpeakersParams struct {
+	// room is identified by the user ID of its owner
+	RoomID core.UserSessionID `json:"room_id"`
+	// speakers are sorted by the level, the first one is dominant
+	Speakers []ActiveSpeaker `json:"speakers"`
+}
+
+// ActiveSpeakersRpc is sent to the room members when the dominant speaker changes
+type ActiveSpeakersRpc struct {
+	jsonRpcHead
+	Params ActiveSpeakersParams `json:"params"`
+}
+
+func NewActiveSpeakersRpc(roomID core.UserSessionID, speakers []ActiveSpeaker) *ActiveSpeakersRpc {
+	return &ActiveSpeakersRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  ActiveSpeakersMethod,
+		},
+		Params: ActiveSpeakersParams{roomID, speakers},
+	}
+}
+
+func (r ActiveSpeakersRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r ActiveSpeakersRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,32 @@
+package rpc
+
+import "encoding/json"
+
+type ICERestartParams struct {
+	Target SignalingTarget `json:"target"`
+}
+
+// ICERestartRpc asks the client to send a new offer with the fresh ICE credentials
+// when the connection of the target has failed
+type ICERestartRpc struct {
+	jsonRpcHead
+	Params ICERestartParams `json:"params"`
+}
+
+func NewICERestartRpc(target SignalingTarget) *ICERestartRpc {
+	return &ICERestartRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  ICERestartMethod,
+		},
+		Params: ICERestartParams{target},
+	}
+}
+
+func (r ICERestartRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r ICERestartRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,35 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type InvitePublisherParams struct {
+	UserID core.UserSessionID `json:"user_id"`
+}
+
+// InvitePublisherRpc is sent by the streamer to invite the user into the room as a co-publisher
+type InvitePublisherRpc struct {
+	jsonRpcHead
+	Params InvitePublisherParams `json:"params"`
+}
+
+func NewInvitePublisherRpc(userID core.UserSessionID) *InvitePublisherRpc {
+	return &InvitePublisherRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  InvitePublisherMethod,
+		},
+		Params: InvitePublisherParams{userID},
+	}
+}
+
+func (r InvitePublisherRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r InvitePublisherRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -1,13 +1,22 @@
 package rpc
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/pion/webrtc/v3"
+)
+
+type JoinParams struct {
+	// STUN and TURN servers with the short-lived credentials of the user
+	ICEServers []webrtc.ICEServer `json:"ice_servers,omitempty"`
+}
 
 type JoinRpc struct {
 	jsonRpcHead
-	Params map[string]string `json:"params"`
+	Params *JoinParams `json:"params"`
 }
 
-func NewJoinRpc() *JoinRpc {
+func NewJoinRpc(iceServers []webrtc.ICEServer) *JoinRpc {
 	rpc := &JoinRpc{
 		jsonRpcHead: jsonRpcHead{
 			Version: jsonRpcVersion,
@@ -16,6 +25,10 @@ func NewJoinRpc() *JoinRpc {
 		Params: nil,
 	}
 
+	if len(iceServers) > 0 {
+		rpc.Params = &JoinParams{ICEServers: iceServers}
+	}
+
 	return rpc
 }
 
@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"encoding/json"
+)
+
+// LossyDataPolicy decides who may send into the lossy data channel of the room
+type LossyDataPolicy string
+
+const (
+	// the owner and the co-publishers, the default
+	LossyDataPublishers LossyDataPolicy = "publishers"
+	// the publishers and the subscribers
+	LossyDataEveryone LossyDataPolicy = "everyone"
+	LossyDataOwner    LossyDataPolicy = "owner"
+	LossyDataNobody   LossyDataPolicy = "nobody"
+)
+
+type LossyDataPolicyParams struct {
+	Policy LossyDataPolicy `json:"policy"`
+}
+
+// LossyDataPolicyRpc is sent by the streamer to change the policy of the lossy data channel of the room
+type LossyDataPolicyRpc struct {
+	jsonRpcHead
+	Params LossyDataPolicyParams `json:"params"`
+}
+
+func NewLossyDataPolicyRpc(policy LossyDataPolicy) *LossyDataPolicyRpc {
+	return &LossyDataPolicyRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  LossyDataPolicyMethod,
+		},
+		Params: LossyDataPolicyParams{policy},
+	}
+}
+
+func (r LossyDataPolicyRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r LossyDataPolicyRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type MuteTrackParams struct {
+	// the room of the moderated publisher, the own room of the sender if empty
+	RoomID core.UserSessionID `json:"room_id,omitempty"`
+	// the publisher of the track, the sender if empty
+	UserID core.UserSessionID `json:"user_id,omitempty"`
+	// every track of the publisher if empty
+	TrackID string `json:"track_id,omitempty"`
+}
+
+// MuteTrackRpc is sent by the publisher to mute or unmute its track
+// or by the owner of the room to force-mute the track of the co-publisher
+type MuteTrackRpc struct {
+	jsonRpcHead
+	Params MuteTrackParams `json:"params"`
+}
+
+func NewMuteTrackRpc(params MuteTrackParams) *MuteTrackRpc {
+	return &MuteTrackRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  MuteTrackMethod,
+		},
+		Params: params,
+	}
+}
+
+func NewUnmuteTrackRpc(params MuteTrackParams) *MuteTrackRpc {
+	return &MuteTrackRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  UnmuteTrackMethod,
+		},
+		Params: params,
+	}
+}
+
+func (r MuteTrackRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r MuteTrackRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
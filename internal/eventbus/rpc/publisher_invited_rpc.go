@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type RoomParams struct {
+	// room is identified by the user ID of its owner
+	RoomID core.UserSessionID `json:"room_id"`
+}
+
+// PublisherInvitedRpc notifies the user about the invitation to publish into the room
+type PublisherInvitedRpc struct {
+	jsonRpcHead
+	Params RoomParams `json:"params"`
+}
+
+func NewPublisherInvitedRpc(roomID core.UserSessionID) *PublisherInvitedRpc {
+	return &PublisherInvitedRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  PublisherInvitedMethod,
+		},
+		Params: RoomParams{roomID},
+	}
+}
+
+func (r PublisherInvitedRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r PublisherInvitedRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import "encoding/json"
+
+// RecordingRpc is sent by the streamer to start or stop recording its published tracks into the files
+type RecordingRpc struct {
+	jsonRpcHead
+	Params interface{} `json:"params"`
+}
+
+func NewStartRecordingRpc() *RecordingRpc {
+	return &RecordingRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  StartRecordingMethod,
+		},
+		Params: nil,
+	}
+}
+
+func NewStopRecordingRpc() *RecordingRpc {
+	return &RecordingRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  StopRecordingMethod,
+		},
+		Params: nil,
+	}
+}
+
+func (r RecordingRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r RecordingRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type RemovePublisherParams struct {
+	RoomID core.UserSessionID `json:"room_id"`
+	UserID core.UserSessionID `json:"user_id"`
+}
+
+// RemovePublisherRpc is sent by the streamer to remove the co-publisher from the room
+// or by the co-publisher to leave the room
+type RemovePublisherRpc struct {
+	jsonRpcHead
+	Params RemovePublisherParams `json:"params"`
+}
+
+func NewRemovePublisherRpc(roomID core.UserSessionID, userID core.UserSessionID) *RemovePublisherRpc {
+	return &RemovePublisherRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  RemovePublisherMethod,
+		},
+		Params: RemovePublisherParams{roomID, userID},
+	}
+}
+
+func (r RemovePublisherRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r RemovePublisherRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type TrackInfo struct {
+	ID       string `json:"id"`
+	StreamID string `json:"stream_id"`
+	Kind     string `json:"kind"`
+	Muted    bool   `json:"muted"`
+}
+
+type PublisherTracks struct {
+	UserID core.UserSessionID `json:"user_id"`
+	Tracks []TrackInfo        `json:"tracks"`
+}
+
+type RoomTracksParams struct {
+	RoomID     core.UserSessionID `json:"room_id"`
+	Publishers []PublisherTracks  `json:"publishers"`
+}
+
+// RoomTracksRpc announces the publishers of the room and their tracks to the room members
+type RoomTracksRpc struct {
+	jsonRpcHead
+	Params RoomTracksParams `json:"params"`
+}
+
+func NewRoomTracksRpc(roomID core.UserSessionID, publishers []PublisherTracks) *RoomTracksRpc {
+	return &RoomTracksRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  RoomTracksMethod,
+		},
+		Params: RoomTracksParams{roomID, publishers},
+	}
+}
+
+func (r RoomTracksRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r RoomTracksRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -24,6 +24,21 @@ const (
 	PublishStreamStopMethod     Method = "publishStop"
 	SubscribeStreamMethod       Method = "subscribe"
 	SubscribeStreamCancelMethod Method = "subscribeCancel"
+	VideoQualityMethod          Method = "videoQuality"
+	ActiveSpeakersMethod        Method = "activeSpeakers"
+	InvitePublisherMethod       Method = "invitePublisher"
+	PublisherInvitedMethod      Method = "publisherInvited"
+	AcceptInviteMethod          Method = "acceptInvite"
+	RemovePublisherMethod       Method = "removePublisher"
+	RoomTracksMethod            Method = "roomTracks"
+	ICERestartMethod            Method = "iceRestart"
+	LossyDataPolicyMethod       Method = "lossyDataPolicy"
+	MuteTrackMethod             Method = "muteTrack"
+	UnmuteTrackMethod           Method = "unmuteTrack"
+	TrackMutedMethod            Method = "trackMuted"
+	StreamEndedMethod           Method = "streamEnded"
+	StartRecordingMethod        Method = "startRecording"
+	StopRecordingMethod         Method = "stopRecording"
 )
 
 var (
@@ -119,7 +134,7 @@ func RpcFromReader(reader io.Reader) (Rpc, error) {
 	case PublishStreamStopMethod:
 		return NewStopStreamRpc(), nil
 	case JoinMethod:
-		return NewJoinRpc(), nil
+		return NewJoinRpc(nil), nil
 	case SubscribeStreamMethod:
 		subParams := &SubscribeParams{}
 		if err := json.Unmarshal(params, subParams); err != nil {
@@ -134,6 +149,59 @@ func RpcFromReader(reader io.Reader) (Rpc, error) {
 		}
 
 		return NewSubscribeStreamCancelRpc(subParams.UserID), nil
+	case VideoQualityMethod:
+		qualityParams := &VideoQualityParams{}
+		if err := json.Unmarshal(params, qualityParams); err != nil {
+			return nil, err
+		}
+
+		return NewVideoQualityRpc(qualityParams.UserID, qualityParams.Quality, qualityParams.Temporal), nil
+	case InvitePublisherMethod:
+		inviteParams := &InvitePublisherParams{}
+		if err := json.Unmarshal(params, inviteParams); err != nil {
+			return nil, err
+		}
+
+		return NewInvitePublisherRpc(inviteParams.UserID), nil
+	case AcceptInviteMethod:
+		roomParams := &RoomParams{}
+		if err := json.Unmarshal(params, roomParams); err != nil {
+			return nil, err
+		}
+
+		return NewAcceptInviteRpc(roomParams.RoomID), nil
+	case RemovePublisherMethod:
+		removeParams := &RemovePublisherParams{}
+		if err := json.Unmarshal(params, removeParams); err != nil {
+			return nil, err
+		}
+
+		return NewRemovePublisherRpc(removeParams.RoomID, removeParams.UserID), nil
+	case LossyDataPolicyMethod:
+		policyParams := &LossyDataPolicyParams{}
+		if err := json.Unmarshal(params, policyParams); err != nil {
+			return nil, err
+		}
+
+		return NewLossyDataPolicyRpc(policyParams.Policy), nil
+	case MuteTrackMethod:
+		muteParams := &MuteTrackParams{}
+		if err := json.Unmarshal(params, muteParams); err != nil {
+			return nil, err
+		}
+
+		return NewMuteTrackRpc(*muteParams), nil
+	case UnmuteTrackMethod:
+		muteParams := &MuteTrackParams{}
+		if err := json.Unmarshal(params, muteParams); err != nil {
+			return nil, err
+		}
+
+		return NewUnmuteTrackRpc(*muteParams), nil
+	case StartRecordingMethod:
+		return NewStartRecordingRpc(), nil
+	case StopRecordingMethod:
+		return NewStopRecordingRpc(), nil
 	default:
 		return nil, ErrUnknownRpcType
 	}
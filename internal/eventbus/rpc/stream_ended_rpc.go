@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+// StreamEndReason tells the viewers why the stream has ended
+type StreamEndReason string
+
+const (
+	// the host has stopped publishing
+	StreamEndStopped StreamEndReason = "stopped"
+	// the host has left or has been disconnected
+	StreamEndHostLeft StreamEndReason = "host_left"
+)
+
+type StreamEndedParams struct {
+	RoomID core.UserSessionID `json:"room_id"`
+	Reason StreamEndReason    `json:"reason"`
+}
+
+// StreamEndedRpc is sent to the viewers of the room when its stream ends.
+// The tracks of the room are removed from the receiver peer connection,
+// the connection is closed if nothing else is received over it.
+type StreamEndedRpc struct {
+	jsonRpcHead
+	Params StreamEndedParams `json:"params"`
+}
+
+func NewStreamEndedRpc(roomID core.UserSessionID, reason StreamEndReason) *StreamEndedRpc {
+	return &StreamEndedRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  StreamEndedMethod,
+		},
+		Params: StreamEndedParams{roomID, reason},
+	}
+}
+
+func (r StreamEndedRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r StreamEndedRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
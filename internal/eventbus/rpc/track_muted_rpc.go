@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type TrackMutedParams struct {
+	RoomID  core.UserSessionID `json:"room_id"`
+	UserID  core.UserSessionID `json:"user_id"`
+	TrackID string             `json:"track_id"`
+	Muted   bool               `json:"muted"`
+	// the track is muted by the moderator, the publisher can't unmute it
+	Forced bool `json:"forced,omitempty"`
+}
+
+// TrackMutedRpc tells the room members that the track of the publisher is muted or unmuted
+type TrackMutedRpc struct {
+	jsonRpcHead
+	Params TrackMutedParams `json:"params"`
+}
+
+func NewTrackMutedRpc(params TrackMutedParams) *TrackMutedRpc {
+	return &TrackMutedRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  TrackMutedMethod,
+		},
+		Params: params,
+	}
+}
+
+func (r TrackMutedRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r TrackMutedRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type VideoQuality string
+
+const (
+	VideoQualityLow    VideoQuality = "low"
+	VideoQualityMedium VideoQuality = "medium"
+	VideoQualityHigh   VideoQuality = "high"
+)
+
+type VideoQualityParams struct {
+	UserID  core.UserSessionID `json:"user_id"`
+	Quality VideoQuality       `json:"quality"`
+	// frame rate of the SVC stream, the highest if empty
+	Temporal VideoQuality `json:"temporal,omitempty"`
+}
+
+// VideoQualityRpc is sent by the viewer to choose the simulcast or SVC layer of the stream
+type VideoQualityRpc struct {
+	jsonRpcHead
+	Params VideoQualityParams `json:"params"`
+}
+
+func NewVideoQualityRpc(userID core.UserSessionID, quality VideoQuality, temporal VideoQuality) *VideoQualityRpc {
+	return &VideoQualityRpc{
+		jsonRpcHead: jsonRpcHead{
+			Version: jsonRpcVersion,
+			Method:  VideoQualityMethod,
+		},
+		Params: VideoQualityParams{userID, quality, temporal},
+	}
+}
+
+func (r VideoQualityRpc) GetMethod() Method {
+	return r.Method
+}
+
+func (r VideoQualityRpc) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
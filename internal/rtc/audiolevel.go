@@ -0,0 +1,159 @@
+package rtc
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+const (
+	// the quietest level of the audio level extension, -127 dBov
+	silentAudioLevel = 127
+	// audio quieter than this level is not a speech, dBov
+	speechAudioLevel = 50
+	// how often the dominant speaker is reevaluated
+	audioLevelInterval = 500 * time.Millisecond
+	// weight of the last interval in the smoothed loudness
+	audioLevelSmoothing = 0.4
+	// smoothed loudness of the active speaker, 0..1
+	minActiveLoudness = 0.05
+)
+
+type SpeakerLevel struct {
+	UserID core.UserSessionID
+	// smoothed loudness, from 0 (silence) to 1 (the loudest)
+	Level float64
+}
+
+type participantLevel struct {
+	// the sum of the loudness of the interval and the number of samples
+	sum      float64
+	samples  int
+	smoothed float64
+}
+
+// AudioLevelObserver keeps the smoothed loudness of the participants of the room
+// and reports the speakers when the dominant one changes.
+type AudioLevelObserver struct {
+	lock     sync.Mutex
+	levels   map[core.UserSessionID]*participantLevel
+	dominant core.UserSessionID
+
+	onSpeakersChanged func(speakers []SpeakerLevel)
+
+	stop    chan struct{}
+	stopped bool
+}
+
+func NewAudioLevelObserver() *AudioLevelObserver {
+	return &AudioLevelObserver{
+		levels: make(map[core.UserSessionID]*participantLevel),
+		stop:   make(chan struct{}),
+	}
+}
+
+// OnSpeakersChanged sets the handler called with the active speakers, loudest first
+func (o *AudioLevelObserver) OnSpeakersChanged(f func(speakers []SpeakerLevel)) {
+	o.lock.Lock()
+	o.onSpeakersChanged = f
+	o.lock.Unlock()
+}
+
+func (o *AudioLevelObserver) Start() {
+	go o.run()
+}
+
+func (o *AudioLevelObserver) Stop() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.stopped {
+		return
+	}
+	o.stopped = true
+	close(o.stop)
+}
+
+// Observe takes the level of the audio level extension (RFC 6464), in -dBov
+func (o *AudioLevelObserver) Observe(userID core.UserSessionID, level uint8) {
+	if level > silentAudioLevel {
+		level = silentAudioLevel
+	}
+
+	loudness := 0.0
+	if level < speechAudioLevel {
+		loudness = 1 - float64(level)/speechAudioLevel
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	pl, ok := o.levels[userID]
+	if !ok {
+		pl = &participantLevel{}
+		o.levels[userID] = pl
+	}
+	pl.sum += loudness
+	pl.samples++
+}
+
+// Remove forgets the participant, i.e. when it leaves the room
+func (o *AudioLevelObserver) Remove(userID core.UserSessionID) {
+	o.lock.Lock()
+	delete(o.levels, userID)
+	o.lock.Unlock()
+}
+
+func (o *AudioLevelObserver) run() {
+	ticker := time.NewTicker(audioLevelInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			o.update()
+		}
+	}
+}
+
+func (o *AudioLevelObserver) update() {
+	o.lock.Lock()
+
+	speakers := make([]SpeakerLevel, 0, len(o.levels))
+	for userID, pl := range o.levels {
+		// No packets in the interval is a silence, i.e. the track is muted
+		average := 0.0
+		if pl.samples > 0 {
+			average = pl.sum / float64(pl.samples)
+		}
+		pl.smoothed = pl.smoothed*(1-audioLevelSmoothing) + average*audioLevelSmoothing
+		pl.sum = 0
+		pl.samples = 0
+
+		if pl.smoothed >= minActiveLoudness {
+			speakers = append(speakers, SpeakerLevel{UserID: userID, Level: pl.smoothed})
+		}
+	}
+
+	sort.Slice(speakers, func(i, j int) bool {
+		return speakers[i].Level > speakers[j].Level
+	})
+
+	dominant := core.UserSessionID("")
+	if len(speakers) > 0 {
+		dominant = speakers[0].UserID
+	}
+
+	changed := dominant != o.dominant
+	o.dominant = dominant
+	onSpeakersChanged := o.onSpeakersChanged
+	o.lock.Unlock()
+
+	if changed && onSpeakersChanged != nil {
+		onSpeakersChanged(speakers)
+	}
+}
@@ -0,0 +1,49 @@
+package rtc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	bitrateWindow = time.Second
+)
+
+// bitrateMeter measures bitrate of the stream over fixed windows
+type bitrateMeter struct {
+	lock        sync.Mutex
+	bytes       int
+	windowStart time.Time
+	updatedAt   time.Time
+	bitrate     int
+}
+
+func (m *bitrateMeter) Add(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if m.windowStart.IsZero() {
+		m.windowStart = now
+	}
+	m.bytes += n
+
+	if elapsed := now.Sub(m.windowStart); elapsed >= bitrateWindow {
+		m.bitrate = int(float64(m.bytes*8) / elapsed.Seconds())
+		m.bytes = 0
+		m.windowStart = now
+		m.updatedAt = now
+	}
+}
+
+// Bitrate returns bps of the last complete window, zero if the stream has stopped
+func (m *bitrateMeter) Bitrate() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if time.Since(m.updatedAt) > 2*bitrateWindow {
+		return 0
+	}
+
+	return m.bitrate
+}
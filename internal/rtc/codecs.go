@@ -0,0 +1,160 @@
+package rtc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+)
+
+// codecsFromMedia lists the codecs of the media section with the payload types of the remote side
+func codecsFromMedia(desc *sdp.SessionDescription, media *sdp.MediaDescription) []webrtc.RTPCodecParameters {
+	codecs := make([]webrtc.RTPCodecParameters, 0, len(media.MediaName.Formats))
+	for _, format := range media.MediaName.Formats {
+		payloadType, err := strconv.ParseUint(format, 10, 8)
+		if err != nil {
+			continue
+		}
+
+		codec, err := desc.GetCodecForPayloadType(uint8(payloadType))
+		if err != nil {
+			continue
+		}
+
+		feedback := make([]webrtc.RTCPFeedback, 0, len(codec.RTCPFeedback))
+		for _, fb := range codec.RTCPFeedback {
+			fields := strings.SplitN(fb, " ", 2)
+			rtcpFeedback := webrtc.RTCPFeedback{Type: fields[0]}
+			if len(fields) == 2 {
+				rtcpFeedback.Parameter = fields[1]
+			}
+			feedback = append(feedback, rtcpFeedback)
+		}
+
+		channels, _ := strconv.ParseUint(codec.EncodingParameters, 10, 16)
+
+		codecs = append(codecs, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     media.MediaName.Media + "/" + codec.Name,
+				ClockRate:    codec.ClockRate,
+				Channels:     uint16(channels),
+				SDPFmtpLine:  codec.Fmtp,
+				RTCPFeedback: feedback,
+			},
+			PayloadType: webrtc.PayloadType(payloadType),
+		})
+	}
+
+	return codecs
+}
+
+// negotiatedTranscoderCodecs returns the transcoder codec of every kind the answer receives from the publisher.
+// The publisher sends the first codec of the answer, it is the only one the transcoder listens to.
+func negotiatedTranscoderCodecs(answer *webrtc.SessionDescription) (map[webrtc.RTPCodecType]webrtc.RTPCodecParameters, error) {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(answer.SDP)); err != nil {
+		return nil, err
+	}
+
+	codecs := make(map[webrtc.RTPCodecType]webrtc.RTPCodecParameters)
+	for _, media := range parsed.MediaDescriptions {
+		kind := webrtc.NewRTPCodecType(media.MediaName.Media)
+		if _, ok := codecs[kind]; ok || kind == 0 || media.MediaName.Port.Value == 0 {
+			continue
+		}
+		if _, ok := media.Attribute(webrtc.RTPTransceiverDirectionRecvonly.String()); !ok {
+			if _, ok := media.Attribute(webrtc.RTPTransceiverDirectionSendrecv.String()); !ok {
+				continue
+			}
+		}
+
+		for _, codec := range codecsFromMedia(parsed, media) {
+			if params, ok := transcoderCodec(codec); ok {
+				codecs[kind] = params
+				break
+			}
+		}
+	}
+
+	return codecs, nil
+}
+
+// preferredCodecs keeps the enabled codecs of the offer ordered by the preference of the SFU.
+// Codecs of the same preference keep the order of the offer.
+func preferredCodecs(enabledCodecs config.EnabledCodecs, offered []webrtc.RTPCodecParameters) []webrtc.RTPCodecParameters {
+	type rankedCodec struct {
+		codec webrtc.RTPCodecParameters
+		rank  int
+	}
+
+	ranked := make([]rankedCodec, 0, len(offered))
+	for _, codec := range offered {
+		for rank, spec := range enabledCodecs {
+			if isCodecSpecMatched(spec, codec.RTPCodecCapability) {
+				ranked = append(ranked, rankedCodec{codec, rank})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].rank < ranked[j].rank
+	})
+
+	codecs := make([]webrtc.RTPCodecParameters, 0, len(ranked))
+	for _, c := range ranked {
+		codecs = append(codecs, c.codec)
+	}
+
+	return codecs
+}
+
+// isCodecCompatible tells if the stream of the codec can be forwarded as the other one.
+// The H.264 streams must have the same packetization mode and profile, the level may differ.
+func isCodecCompatible(a, b webrtc.RTPCodecCapability) bool {
+	if !strings.EqualFold(a.MimeType, b.MimeType) {
+		return false
+	}
+
+	if !strings.EqualFold(a.MimeType, webrtc.MimeTypeH264) {
+		return true
+	}
+
+	fmtpA, fmtpB := parseFmtp(a.SDPFmtpLine), parseFmtp(b.SDPFmtpLine)
+
+	modeA, modeB := fmtpA["packetization-mode"], fmtpB["packetization-mode"]
+	if modeA == "" {
+		modeA = "0"
+	}
+	if modeB == "" {
+		modeB = "0"
+	}
+	if modeA != modeB {
+		return false
+	}
+
+	// profile_idc and profile-iop, the last byte is the level
+	profileA, profileB := fmtpA["profile-level-id"], fmtpB["profile-level-id"]
+	if len(profileA) < 4 || len(profileB) < 4 {
+		return profileA == profileB
+	}
+
+	return strings.EqualFold(profileA[:4], profileB[:4])
+}
+
+func parseFmtp(line string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Split(line, ";") {
+		fields := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		params[strings.ToLower(fields[0])] = fields[1]
+	}
+
+	return params
+}
@@ -0,0 +1,121 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
+)
+
+// answerPublisher negotiates the publisher transport with the client sending the tracks of the kinds
+func answerPublisher(t *testing.T, clientCodecs []webrtc.RTPCodecParameters, kinds ...webrtc.RTPCodecType) *webrtc.SessionDescription {
+	conf := config.NewConfig()
+	rtcConf, err := config.NewWebRTCConfig(conf)
+	require.NoError(t, err)
+
+	publisher, err := NewPCTransport(TransportParams{EnabledCodecs: conf.Peer.EnabledCodecs, Config: rtcConf, Target: rpc.Publisher})
+	require.NoError(t, err)
+	t.Cleanup(publisher.Close)
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, codec := range clientCodecs {
+		kind := webrtc.RTPCodecTypeVideo
+		if codec.MimeType == webrtc.MimeTypeOpus {
+			kind = webrtc.RTPCodecTypeAudio
+		}
+		require.NoError(t, mediaEngine.RegisterCodec(codec, kind))
+	}
+	client, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	for _, kind := range kinds {
+		_, err := client.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+		require.NoError(t, err)
+	}
+	_, err = client.CreateDataChannel("data", nil)
+	require.NoError(t, err)
+
+	offer, err := client.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, client.SetLocalDescription(offer))
+
+	require.NoError(t, publisher.SetRemoteDescription(offer))
+	require.NoError(t, publisher.PreferCodecs())
+	answer, err := publisher.pc.CreateAnswer(nil)
+	require.NoError(t, err)
+	require.NoError(t, publisher.pc.SetLocalDescription(answer))
+
+	return publisher.pc.LocalDescription()
+}
+
+func TestNegotiatedTranscoderCodecs(t *testing.T) {
+	opus := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        109,
+	}
+	vp8 := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        120,
+	}
+	h264 := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		},
+		PayloadType: 126,
+	}
+
+	tests := []struct {
+		name   string
+		codecs []webrtc.RTPCodecParameters
+		kinds  []webrtc.RTPCodecType
+		want   map[webrtc.RTPCodecType]string
+	}{
+		{
+			name:   "preferred codec of every kind",
+			codecs: []webrtc.RTPCodecParameters{opus, h264, vp8},
+			kinds:  []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo},
+			want:   map[webrtc.RTPCodecType]string{webrtc.RTPCodecTypeAudio: webrtc.MimeTypeOpus, webrtc.RTPCodecTypeVideo: webrtc.MimeTypeVP8},
+		},
+		{
+			name:   "single codec of the client",
+			codecs: []webrtc.RTPCodecParameters{opus, h264},
+			kinds:  []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo},
+			want:   map[webrtc.RTPCodecType]string{webrtc.RTPCodecTypeAudio: webrtc.MimeTypeOpus, webrtc.RTPCodecTypeVideo: webrtc.MimeTypeH264},
+		},
+		{
+			name:   "audio only",
+			codecs: []webrtc.RTPCodecParameters{opus, vp8},
+			kinds:  []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio},
+			want:   map[webrtc.RTPCodecType]string{webrtc.RTPCodecTypeAudio: webrtc.MimeTypeOpus},
+		},
+		{
+			name:   "data channel only",
+			codecs: []webrtc.RTPCodecParameters{opus, vp8},
+			want:   map[webrtc.RTPCodecType]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codecs, err := negotiatedTranscoderCodecs(answerPublisher(t, tt.codecs, tt.kinds...))
+			require.NoError(t, err)
+
+			mimeTypes := make(map[webrtc.RTPCodecType]string)
+			for kind, codec := range codecs {
+				mimeTypes[kind] = codec.MimeType
+				// The codec keeps the payload type of the transcoder SDP
+				payloadType, ok := transcoderCodec(codec)
+				require.True(t, ok)
+				assert.Equal(t, codec.PayloadType, payloadType.PayloadType)
+			}
+			assert.Equal(t, tt.want, mimeTypes)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package rtc
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+type DataPacketKind string
+
+const (
+	DataPacketChat     DataPacketKind = "chat"
+	DataPacketReaction DataPacketKind = "reaction"
+	// custom payload of the application, the SFU does not look into it
+	DataPacketApp DataPacketKind = "app"
+
+	// maximum length of the reaction, i.e. an emoji, in runes
+	maxReactionLength = 16
+)
+
+var (
+	errDataPacketTooLarge    = errors.New("data packet is too large")
+	errDataPacketKind        = errors.New("unknown kind of the data packet")
+	errDataPacketEmpty       = errors.New("data packet has no content")
+	errDataPacketRateLimited = errors.New("data packets are sent too often")
+	errDataChannelNotReady   = errors.New("data channel is not opened")
+	errNotRoomMember         = errors.New("participant is not a member of the room")
+	errLossyDataForbidden    = errors.New("policy of the room forbids the participant to send lossy data")
+	errLossyDataPolicy       = errors.New("unknown policy of the lossy data")
+	errLossyDataChannel      = errors.New("lossy data channel must be unordered without retransmits")
+)
+
+// DataPacket is the message of the participant to the members of the room over the data channel
+type DataPacket struct {
+	Kind DataPacketKind `json:"kind"`
+	// the room the packet is sent to, the own room of the participant if empty
+	RoomID core.UserSessionID `json:"room_id,omitempty"`
+	// the sender is set by the SFU, the participant can't forge it
+	From core.UserSessionID `json:"from,omitempty"`
+	// the text of the chat message or the reaction
+	Text string `json:"text,omitempty"`
+	// the topic and the payload of the app message
+	Topic   string          `json:"topic,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// unix time of the SFU in milliseconds
+	Timestamp int64 `json:"timestamp,omitempty"`
+
+	// the packet came over the lossy data channel and is relayed over it
+	lossy bool
+}
+
+// parseDataPacket decodes and validates the message of the participant
+func parseDataPacket(data []byte, maxSize int) (*DataPacket, error) {
+	if maxSize > 0 && len(data) > maxSize {
+		return nil, errDataPacketTooLarge
+	}
+
+	packet := &DataPacket{}
+	if err := json.Unmarshal(data, packet); err != nil {
+		return nil, err
+	}
+
+	switch packet.Kind {
+	case DataPacketChat:
+		if packet.Text == "" {
+			return nil, errDataPacketEmpty
+		}
+	case DataPacketReaction:
+		if packet.Text == "" {
+			return nil, errDataPacketEmpty
+		}
+		if utf8.RuneCountInString(packet.Text) > maxReactionLength {
+			return nil, errDataPacketTooLarge
+		}
+	case DataPacketApp:
+		if len(packet.Payload) == 0 {
+			return nil, errDataPacketEmpty
+		}
+	default:
+		return nil, errDataPacketKind
+	}
+
+	return packet, nil
+}
+
+// dataRateLimiter is the token bucket of the data packets of the participant
+type dataRateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newDataRateLimiter(rate float64, burst int) *dataRateLimiter {
+	return &dataRateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token if there is one, the limiter allows everything if the rate is not set
+func (l *dataRateLimiter) Allow() bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
@@ -0,0 +1,594 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/telemetry"
+)
+
+const (
+	// size of the padding-only probe packet
+	paddingSize = 255
+	// size of the original sequence number prepended to the RTX payload
+	rtxHeaderSize = 2
+)
+
+// sentPacket maps the sent sequence number to the cached packet of the published track
+type sentPacket struct {
+	sn      uint16
+	srcSN   uint16
+	ts      uint32
+	layer   int
+	padding bool
+	valid   bool
+}
+
+// DownTrack forwards packets of the published MediaTrack to a single subscriber.
+// It implements webrtc.TrackLocal to write packets with rewritten sequence numbers and
+// to retransmit them on NACK.
+type DownTrack struct {
+	SubscriberID core.UserSessionID
+
+	mediaTrack *MediaTrack
+	sender     *webrtc.RTPSender
+	// SSRC of the media stream the RTX stream is registered for on the transport
+	primarySSRC webrtc.SSRC
+
+	lock         sync.Mutex
+	targetLayer  int
+	currentLayer int
+	// the highest layer the subscriber asked for
+	maxLayer int
+	// the layer is chosen by the stream allocator of the subscriber transport
+	managed bool
+	paused  bool
+
+	// the forwarded layers of the SVC stream, the target spatial layer is targetLayer
+	svcSpatial     int
+	svcTemporal    int
+	targetTemporal int
+
+	// sequence numbers and timestamps are rewritten to stay continuous across layer switches
+	started   bool
+	lastSN    uint16
+	lastTS    uint32
+	lastWrite time.Time
+	snOffset  uint16
+	tsOffset  uint32
+
+	// negotiated by the peer connection in Bind
+	bound          bool
+	ssrc           webrtc.SSRC
+	payloadType    webrtc.PayloadType
+	writeStream    webrtc.TrackLocalWriter
+	rtxSSRC        webrtc.SSRC
+	rtxPayloadType webrtc.PayloadType
+	rtxSN          uint16
+
+	sentPackets [packetCacheSize]sentPacket
+
+	stats   sendStats
+	bitrate bitrateMeter
+}
+
+func NewDownTrack(subscriberID core.UserSessionID, mediaTrack *MediaTrack) (*DownTrack, error) {
+	return &DownTrack{
+		SubscriberID:   subscriberID,
+		mediaTrack:     mediaTrack,
+		targetLayer:    HighLayer,
+		currentLayer:   InvalidLayer,
+		maxLayer:       HighLayer,
+		targetTemporal: maxTemporalLayers - 1,
+	}, nil
+}
+
+// Attach adds the track to the subscriber peer connection
+func (d *DownTrack) Attach(transport *PCTransport) error {
+	sender, err := transport.pc.AddTrack(d)
+	if err != nil {
+		return err
+	}
+	d.sender = sender
+
+	if transport.RTXEnabled() && d.Kind() == webrtc.RTPCodecTypeVideo {
+		if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+			d.lock.Lock()
+			d.primarySSRC = encodings[0].SSRC
+			d.rtxSSRC = webrtc.SSRC(rand.Uint32())
+			d.lock.Unlock()
+
+			transport.AddRTXStream(d.primarySSRC, d.rtxSSRC)
+		}
+	}
+
+	go d.readRTCP()
+
+	return nil
+}
+
+// Bind is called by the peer connection when the negotiation is complete
+func (d *DownTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, ok := matchCodec(d.mediaTrack.Codec, ctx.CodecParameters())
+	if !ok {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	d.lock.Lock()
+	d.bound = true
+	d.ssrc = ctx.SSRC()
+	d.payloadType = codec.PayloadType
+	d.writeStream = ctx.WriteStream()
+
+	// Without the negotiated RTX payload type the packets are resent on the media stream
+	if rtxPT, ok := rtxPayloadType(ctx.CodecParameters(), codec.PayloadType); ok && d.rtxSSRC != 0 {
+		d.rtxPayloadType = rtxPT
+	} else {
+		d.rtxPayloadType = 0
+	}
+	target := d.targetLayer
+	d.lock.Unlock()
+
+	// The new subscriber starts from a keyframe
+	d.mediaTrack.RequestKeyframe(d.mediaTrack.AvailableLayer(target), KeyframeReasonSubscribe)
+
+	return codec, nil
+}
+
+// Unbind is called by the peer connection when the track is stopped
+func (d *DownTrack) Unbind(_ webrtc.TrackLocalContext) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.bound = false
+	d.writeStream = nil
+
+	return nil
+}
+
+func (d *DownTrack) ID() string { return string(d.mediaTrack.ID) }
+
+func (d *DownTrack) RID() string { return "" }
+
+func (d *DownTrack) StreamID() string { return d.mediaTrack.StreamID }
+
+func (d *DownTrack) Kind() webrtc.RTPCodecType { return d.mediaTrack.Kind }
+
+// matchCodec finds the negotiated codec of the published one, preferring the same fmtp.
+// The negotiated codec must be compatible, i.e. the H.264 profile and packetization mode can't change.
+func matchCodec(codec webrtc.RTPCodecParameters, negotiated []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	var found *webrtc.RTPCodecParameters
+	for i := range negotiated {
+		if !isCodecCompatible(codec.RTPCodecCapability, negotiated[i].RTPCodecCapability) {
+			continue
+		}
+		if negotiated[i].SDPFmtpLine == codec.SDPFmtpLine {
+			return negotiated[i], true
+		}
+		if found == nil {
+			found = &negotiated[i]
+		}
+	}
+
+	if found == nil {
+		return webrtc.RTPCodecParameters{}, false
+	}
+
+	return *found, true
+}
+
+// SetTargetLayer sets the spatial layer the subscriber wants to receive.
+// The switch happens on the next keyframe of the layer.
+func (d *DownTrack) SetTargetLayer(layer int) {
+	d.lock.Lock()
+	changed := d.targetLayer != layer
+	d.targetLayer = layer
+	d.lock.Unlock()
+
+	if changed {
+		d.mediaTrack.RequestKeyframe(d.mediaTrack.AvailableLayer(layer), KeyframeReasonLayerSwitch)
+	}
+}
+
+// SetMaxLayer limits the layer by the preference of the subscriber.
+// Unless the stream allocator manages the track, the subscriber is switched to this layer.
+func (d *DownTrack) SetMaxLayer(layer int) {
+	d.lock.Lock()
+	d.maxLayer = layer
+	managed := d.managed
+	d.lock.Unlock()
+
+	if !managed {
+		d.SetTargetLayer(layer)
+	}
+}
+
+// SetTargetTemporalLayer limits the temporal layer, i.e. the frame rate, of the SVC stream
+func (d *DownTrack) SetTargetTemporalLayer(layer int) {
+	d.lock.Lock()
+	d.targetTemporal = layer
+	d.lock.Unlock()
+}
+
+func (d *DownTrack) MaxLayer() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.maxLayer
+}
+
+func (d *DownTrack) setManaged(managed bool) {
+	d.lock.Lock()
+	d.managed = managed
+	d.lock.Unlock()
+}
+
+// Pause stops forwarding until Resume is called
+func (d *DownTrack) Pause() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.paused {
+		return
+	}
+
+	log.Debug().Str("service", "downTrack").Str("ID", string(d.mediaTrack.ID)).Str("subscriber", string(d.SubscriberID)).Msg("pause")
+
+	d.paused = true
+	d.currentLayer = InvalidLayer
+}
+
+// Resume continues forwarding from the next keyframe
+func (d *DownTrack) Resume() {
+	d.lock.Lock()
+	if !d.paused {
+		d.lock.Unlock()
+		return
+	}
+
+	log.Debug().Str("service", "downTrack").Str("ID", string(d.mediaTrack.ID)).Str("subscriber", string(d.SubscriberID)).Msg("resume")
+
+	d.paused = false
+	target := d.targetLayer
+	d.lock.Unlock()
+
+	d.mediaTrack.RequestKeyframe(d.mediaTrack.AvailableLayer(target), KeyframeReasonResume)
+}
+
+// restart stops forwarding the current layer until its next keyframe, e.g. after the gap of the muted track.
+// The sequence numbers continue as on the layer switch.
+func (d *DownTrack) restart() {
+	d.lock.Lock()
+	d.currentLayer = InvalidLayer
+	d.lock.Unlock()
+}
+
+func (d *DownTrack) IsPaused() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.paused
+}
+
+func (d *DownTrack) TargetLayer() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.targetLayer
+}
+
+func (d *DownTrack) CurrentLayer() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.currentLayer
+}
+
+// WriteRTP writes the packet of the layer if the subscriber receives this layer.
+// The packets of the SVC stream above the target spatial and temporal layers are dropped.
+func (d *DownTrack) WriteRTP(layer int, packet *rtp.Packet, svc *svcLayer) error {
+	target := d.mediaTrack.AvailableLayer(d.TargetLayer())
+
+	d.lock.Lock()
+	if d.paused {
+		d.lock.Unlock()
+		return nil
+	}
+
+	if layer != d.currentLayer {
+		// Switch only on the keyframe of the target layer, other layers are dropped
+		if layer != target || !isKeyframe(d.mediaTrack.Codec, packet) {
+			d.lock.Unlock()
+			return nil
+		}
+		d.switchLayer(layer, packet)
+	}
+
+	hdr := packet.Header
+	if svc != nil {
+		forward, marker := d.selectSVCLayer(svc, packet.Marker)
+		if !forward {
+			d.skipPacket(packet.SequenceNumber)
+			d.lock.Unlock()
+			return nil
+		}
+		hdr.Marker = marker
+	}
+
+	hdr.SequenceNumber = packet.SequenceNumber + d.snOffset
+	hdr.Timestamp = packet.Timestamp + d.tsOffset
+	// the padding is stripped from the payload by unmarshal
+	hdr.Padding = false
+
+	if !d.started || int16(hdr.SequenceNumber-d.lastSN) > 0 {
+		d.lastSN = hdr.SequenceNumber
+		d.lastTS = hdr.Timestamp
+		d.lastWrite = time.Now()
+	}
+	d.started = true
+
+	d.sentPackets[hdr.SequenceNumber%packetCacheSize] = sentPacket{
+		sn:    hdr.SequenceNumber,
+		srcSN: packet.SequenceNumber,
+		ts:    hdr.Timestamp,
+		layer: layer,
+		valid: true,
+	}
+	d.lock.Unlock()
+
+	return d.write(hdr, packet.Payload, false)
+}
+
+// write sends the packet on the media stream or on the RTX one
+func (d *DownTrack) write(hdr rtp.Header, payload []byte, rtx bool) error {
+	d.lock.Lock()
+	if !d.bound {
+		d.lock.Unlock()
+		return nil
+	}
+
+	writeStream := d.writeStream
+	hdr.SSRC = uint32(d.ssrc)
+	hdr.PayloadType = uint8(d.payloadType)
+	if rtx {
+		hdr.SSRC = uint32(d.rtxSSRC)
+		hdr.PayloadType = uint8(d.rtxPayloadType)
+		hdr.SequenceNumber = d.rtxSN
+		d.rtxSN++
+	}
+	d.lock.Unlock()
+
+	n, err := writeStream.WriteRTP(&hdr, payload)
+	if err == nil {
+		d.stats.Sent(n)
+		d.bitrate.Add(n)
+	}
+
+	return err
+}
+
+// WritePadding sends padding-only packets to probe the bandwidth of the subscriber.
+// The packets take the next sequence numbers, so the media continues after them.
+func (d *DownTrack) WritePadding(count int) error {
+	d.lock.Lock()
+	if !d.started || d.paused || d.currentLayer == InvalidLayer {
+		d.lock.Unlock()
+		return nil
+	}
+
+	packets := make([]*rtp.Packet, 0, count)
+	for i := 0; i < count; i++ {
+		d.lastSN++
+		d.snOffset++
+		d.sentPackets[d.lastSN%packetCacheSize] = sentPacket{sn: d.lastSN, padding: true, valid: true}
+
+		payload := make([]byte, paddingSize)
+		payload[paddingSize-1] = paddingSize
+
+		packets = append(packets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Padding:        true,
+				SequenceNumber: d.lastSN,
+				Timestamp:      d.lastTS,
+			},
+			Payload: payload,
+		})
+	}
+	d.lock.Unlock()
+
+	for _, packet := range packets {
+		if err := d.write(packet.Header, packet.Payload, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectSVCLayer tells if the packet of the SVC stream is forwarded and sets the marker of the
+// last forwarded packet of the picture. The lock must be held.
+func (d *DownTrack) selectSVCLayer(svc *svcLayer, marker bool) (bool, bool) {
+	// The spatial layer is switched between the pictures, the base layer starts every picture
+	if svc.spatial == LowLayer {
+		if d.targetLayer < d.svcSpatial || svc.keyframe {
+			d.svcSpatial = d.targetLayer
+		}
+	}
+
+	if d.targetTemporal < d.svcTemporal || (d.targetTemporal > d.svcTemporal && (svc.switchUp || svc.temporal == 0)) {
+		d.svcTemporal = d.targetTemporal
+	}
+
+	if svc.spatial > d.svcSpatial || svc.temporal > d.svcTemporal {
+		return false, false
+	}
+
+	return true, marker || (svc.endOfFrame && svc.spatial == d.svcSpatial)
+}
+
+// skipPacket shifts the sequence numbers so that the dropped packet does not look lost. The lock must be held.
+func (d *DownTrack) skipPacket(sn uint16) {
+	if !d.started {
+		return
+	}
+
+	if int16(sn-(d.lastSN-d.snOffset)) > 0 {
+		d.snOffset--
+	}
+}
+
+// switchLayer computes offsets so that the new layer continues the sequence of the previous one
+func (d *DownTrack) switchLayer(layer int, packet *rtp.Packet) {
+	log.Debug().Str("service", "downTrack").Str("ID", string(d.mediaTrack.ID)).Str("subscriber", string(d.SubscriberID)).Int("from", d.currentLayer).Int("to", layer).Msg("switch layer")
+
+	d.currentLayer = layer
+	if !d.started {
+		return
+	}
+
+	tsDelta := uint32(time.Since(d.lastWrite).Seconds() * float64(d.mediaTrack.Codec.ClockRate))
+	if tsDelta == 0 {
+		tsDelta = 1
+	}
+
+	d.snOffset = d.lastSN + 1 - packet.SequenceNumber
+	d.tsOffset = d.lastTS + tsDelta - packet.Timestamp
+}
+
+// Detach removes the track from the subscriber peer connection
+func (d *DownTrack) Detach(transport *PCTransport) error {
+	if d.sender == nil {
+		return nil
+	}
+
+	if d.primarySSRC != 0 {
+		transport.RemoveRTXStream(d.primarySSRC)
+	}
+
+	return transport.pc.RemoveTrack(d.sender)
+}
+
+// Read incoming RTCP packets
+// Before these packets are returned they are processed by interceptors
+func (d *DownTrack) readRTCP() {
+	buf := make([]byte, 1500)
+
+	for {
+		packets, _, err := d.sender.ReadRTCP()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Debug().Err(err).Str("service", "downTrack").Str("ID", string(d.mediaTrack.ID)).Str("subscriber", string(d.SubscriberID)).Msg("read RTCP")
+			}
+			return
+		}
+
+		for _, packet := range packets {
+			d.stats.Feedback(packet)
+
+			switch p := packet.(type) {
+			case *rtcp.ReceiverReport:
+				d.handleReceiverReport(p)
+			case *rtcp.TransportLayerNack:
+				d.handleNACK(p, buf)
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				d.mediaTrack.RequestKeyframe(d.mediaTrack.AvailableLayer(d.TargetLayer()), KeyframeReasonDownstream)
+			}
+		}
+	}
+}
+
+// handleReceiverReport applies the report of the subscriber about the media stream of the track
+func (d *DownTrack) handleReceiverReport(rr *rtcp.ReceiverReport) {
+	d.lock.Lock()
+	ssrc := uint32(d.ssrc)
+	d.lock.Unlock()
+
+	for _, report := range rr.Reports {
+		if report.SSRC == ssrc {
+			d.stats.ReceiverReport(report, d.mediaTrack.Codec.ClockRate, time.Now())
+		}
+	}
+}
+
+// Stats returns the statistics of the forwarding to the subscriber
+func (d *DownTrack) Stats() TrackStats {
+	stats := TrackStats{
+		TrackID:      d.mediaTrack.ID,
+		Kind:         d.mediaTrack.Kind.String(),
+		Direction:    directionOutbound,
+		Codec:        d.mediaTrack.Codec.MimeType,
+		SubscriberID: d.SubscriberID,
+		SpatialLayer: d.CurrentLayer(),
+		Bitrate:      d.bitrate.Bitrate(),
+	}
+	d.stats.Snapshot(&stats)
+
+	return stats
+}
+
+// handleNACK resends the lost packets from the cache of the published track
+func (d *DownTrack) handleNACK(nack *rtcp.TransportLayerNack, buf []byte) {
+	for _, pair := range nack.Nacks {
+		for _, sn := range pair.PacketList() {
+			if err := d.retransmit(sn, buf); err != nil {
+				log.Debug().Err(err).Str("service", "downTrack").Str("ID", string(d.mediaTrack.ID)).Str("subscriber", string(d.SubscriberID)).Msg("retransmit")
+				return
+			}
+		}
+	}
+}
+
+func (d *DownTrack) retransmit(sn uint16, buf []byte) error {
+	d.lock.Lock()
+	sent := d.sentPackets[sn%packetCacheSize]
+	rtx := d.rtxPayloadType != 0
+	d.lock.Unlock()
+
+	// Padding carries no media, the next probe replaces it
+	if !sent.valid || sent.sn != sn || sent.padding {
+		telemetry.RetransmissionMissed()
+		return nil
+	}
+
+	n, ok := d.mediaTrack.GetPacket(sent.layer, sent.srcSN, buf)
+	if !ok {
+		telemetry.RetransmissionMissed()
+		return nil
+	}
+
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(buf[:n]); err != nil {
+		return err
+	}
+
+	hdr := packet.Header
+	hdr.SequenceNumber = sent.sn
+	hdr.Timestamp = sent.ts
+	hdr.Padding = false
+
+	d.stats.Retransmitted()
+
+	if !rtx {
+		telemetry.PacketRetransmitted("rtp")
+		return d.write(hdr, packet.Payload, false)
+	}
+
+	// RFC 4588: the RTX payload starts with the original sequence number
+	payload := make([]byte, rtxHeaderSize+len(packet.Payload))
+	binary.BigEndian.PutUint16(payload, sent.sn)
+	copy(payload[rtxHeaderSize:], packet.Payload)
+
+	telemetry.PacketRetransmitted("rtx")
+	return d.write(hdr, payload, true)
+}
@@ -0,0 +1,191 @@
+package rtc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// the targets of the single track, every packet is sent to each of them
+	maxForwardTargets = 8
+
+	SRTPProfileAESCM128HMACSHA1_80 = "AES_CM_128_HMAC_SHA1_80"
+	SRTPProfileAEADAES128GCM       = "AEAD_AES_128_GCM"
+	// both supported profiles use AES-128
+	srtpMasterKeyLen = 16
+)
+
+var (
+	errForwardTargetNotFound  = errors.New("forward target is not found")
+	errTooManyForwardTargets  = errors.New("track is forwarded to too many targets")
+	errForwardTargetAddr      = errors.New("host and port of the forward target are required")
+	errForwardTargetPT        = errors.New("payload type of the forward target must be below 128")
+	errUnsupportedSRTPProfile = errors.New("unsupported SRTP protection profile")
+)
+
+// SRTPParams encrypts the packets of the forward target
+type SRTPParams struct {
+	// AES_CM_128_HMAC_SHA1_80 if empty or AEAD_AES_128_GCM
+	Profile string `json:"profile"`
+	// the master key followed by the master salt, base64 in JSON
+	Key []byte `json:"key"`
+}
+
+// ForwardTargetParams is the receiver of the RTP packets of the published track besides the subscribers
+// and the transcoder, e.g. the external analyzer or the monitoring probe
+type ForwardTargetParams struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// the packets keep the payload type of the publisher if zero
+	PayloadType webrtc.PayloadType `json:"payload_type"`
+	// the packets are sent unencrypted if nil
+	SRTP *SRTPParams `json:"srtp,omitempty"`
+}
+
+// ForwardTargetInfo describes the forward target and its delivery counters
+type ForwardTargetInfo struct {
+	ID          string             `json:"id"`
+	TrackID     MediaTrackID       `json:"track_id"`
+	Addr        string             `json:"addr"`
+	PayloadType webrtc.PayloadType `json:"payload_type"`
+	SRTP        bool               `json:"srtp"`
+	Packets     uint64             `json:"packets"`
+	Bytes       uint64             `json:"bytes"`
+	// the packets failed to be sent, e.g. nobody listens on the port
+	Dropped uint64 `json:"dropped"`
+}
+
+// ForwardTarget sends the packets of the layer forwarded to the transcoder to the UDP address.
+// The failed packets are counted as dropped, the forwarding of the track goes on.
+type ForwardTarget struct {
+	ID          string
+	TrackID     MediaTrackID
+	PayloadType webrtc.PayloadType
+
+	conn *net.UDPConn
+
+	lock sync.Mutex
+	srtp *srtp.Context
+	buf  []byte
+	// the encrypted packet, it is longer by the authentication tag
+	encrypted []byte
+
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newForwardTarget(trackID MediaTrackID, params ForwardTargetParams) (*ForwardTarget, error) {
+	if params.Host == "" || params.Port <= 0 || params.Port > 65535 {
+		return nil, errForwardTargetAddr
+	}
+	if params.PayloadType > 127 {
+		return nil, errForwardTargetPT
+	}
+
+	f := &ForwardTarget{
+		ID:          uuid.New().String(),
+		TrackID:     trackID,
+		PayloadType: params.PayloadType,
+		buf:         make([]byte, 1500),
+	}
+
+	if params.SRTP != nil {
+		var err error
+		if f.srtp, err = newSRTPContext(params.SRTP); err != nil {
+			return nil, err
+		}
+		f.encrypted = make([]byte, 1600)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(params.Host, strconv.Itoa(params.Port)))
+	if err != nil {
+		return nil, err
+	}
+	if f.conn, err = net.DialUDP("udp", nil, raddr); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func newSRTPContext(params *SRTPParams) (*srtp.Context, error) {
+	var profile srtp.ProtectionProfile
+	switch params.Profile {
+	case "", SRTPProfileAESCM128HMACSHA1_80:
+		profile = srtp.ProtectionProfileAes128CmHmacSha1_80
+	case SRTPProfileAEADAES128GCM:
+		profile = srtp.ProtectionProfileAeadAes128Gcm
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedSRTPProfile, params.Profile)
+	}
+
+	if len(params.Key) < srtpMasterKeyLen {
+		return nil, fmt.Errorf("SRTP master key must be %d bytes followed by the salt", srtpMasterKeyLen)
+	}
+
+	// The context validates the lengths of the key and of the salt of the profile
+	return srtp.CreateContext(params.Key[:srtpMasterKeyLen], params.Key[srtpMasterKeyLen:], profile)
+}
+
+// WriteRTP sends the packet with the payload type of the target, the packet is not modified
+func (f *ForwardTarget) WriteRTP(packet *rtp.Packet) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	payloadType := packet.PayloadType
+	if f.PayloadType != 0 {
+		packet.PayloadType = uint8(f.PayloadType)
+	}
+	n, err := packet.MarshalTo(f.buf)
+	header := packet.Header
+	packet.PayloadType = payloadType
+	if err != nil {
+		f.dropped.Add(1)
+		return
+	}
+
+	b := f.buf[:n]
+	if f.srtp != nil {
+		if b, err = f.srtp.EncryptRTP(f.encrypted[:0], b, &header); err != nil {
+			f.dropped.Add(1)
+			return
+		}
+	}
+
+	if _, err := f.conn.Write(b); err != nil {
+		if f.dropped.Add(1) == 1 {
+			log.Debug().Err(err).Str("service", "ForwardTarget").Str("ID", f.ID).Str("addr", f.conn.RemoteAddr().String()).Msg("write packet")
+		}
+		return
+	}
+	f.packets.Add(1)
+	f.bytes.Add(uint64(len(b)))
+}
+
+func (f *ForwardTarget) Info() ForwardTargetInfo {
+	return ForwardTargetInfo{
+		ID:          f.ID,
+		TrackID:     f.TrackID,
+		Addr:        f.conn.RemoteAddr().String(),
+		PayloadType: f.PayloadType,
+		SRTP:        f.srtp != nil,
+		Packets:     f.packets.Load(),
+		Bytes:       f.bytes.Load(),
+		Dropped:     f.dropped.Load(),
+	}
+}
+
+func (f *ForwardTarget) Close() error {
+	return f.conn.Close()
+}
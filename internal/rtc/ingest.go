@@ -0,0 +1,207 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// payload types of the tracks of the encoder in the transcoder SDP
+	ingestVideoPayloadType webrtc.PayloadType = 96
+	ingestAudioPayloadType webrtc.PayloadType = 97
+
+	// the packets of the ingest fit into the read buffer of the forwarding
+	ingestMTU = 1200
+
+	mimeTypeAAC = "audio/MPEG4-GENERIC"
+
+	// AU-headers-length and the single AU-header of AAC-hbr
+	aacHeadersSize = 4
+	// 13 bits of the AU-size of AAC-hbr
+	maxAACFrameSize = 0x1fff
+)
+
+var (
+	errIngestTrackClosed = errors.New("ingest track is closed")
+	errIngestCodec       = errors.New("codec is not published by the ingest")
+)
+
+// IngestCodecs are the codecs of the tracks of the encoder publishing outside of WebRTC, e.g. over RTMP.
+// The track is not published if its codec is nil.
+type IngestCodecs struct {
+	Video *webrtc.RTPCodecParameters
+	Audio *webrtc.RTPCodecParameters
+}
+
+// IngestH264Codec returns the codec of the H.264 track of the profile of its SPS,
+// the WebRTC subscribers receive it if the profile is enabled
+func IngestH264Codec(profileLevelID string) *webrtc.RTPCodecParameters {
+	return &webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profileLevelID,
+		},
+		PayloadType: ingestVideoPayloadType,
+	}
+}
+
+// IngestAACCodec returns the codec of the AAC track packetized in the AAC-hbr mode of RFC 3640.
+// The WebRTC subscribers can't decode AAC, the track goes to the transcoder only.
+func IngestAACCodec(sampleRate int, channels int, config []byte) *webrtc.RTPCodecParameters {
+	return &webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:  mimeTypeAAC,
+			ClockRate: uint32(sampleRate),
+			Channels:  uint16(channels),
+			SDPFmtpLine: "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=" +
+				hex.EncodeToString(config),
+		},
+		PayloadType: ingestAudioPayloadType,
+	}
+}
+
+// transcoderCodecs lists the codec of every kind of the transcoder SDP
+func (c *IngestCodecs) transcoderCodecs() map[webrtc.RTPCodecType]webrtc.RTPCodecParameters {
+	transcoderCodecs := make(map[webrtc.RTPCodecType]webrtc.RTPCodecParameters)
+	if c.Video != nil {
+		transcoderCodecs[webrtc.RTPCodecTypeVideo] = *c.Video
+	}
+	if c.Audio != nil {
+		transcoderCodecs[webrtc.RTPCodecTypeAudio] = *c.Audio
+	}
+
+	return transcoderCodecs
+}
+
+func (c *IngestCodecs) codec(kind webrtc.RTPCodecType) *webrtc.RTPCodecParameters {
+	if kind == webrtc.RTPCodecTypeVideo {
+		return c.Video
+	}
+
+	return c.Audio
+}
+
+// IngestTrack packetizes the frames of the encoder into the RTP packets of the published track.
+// The packets are forwarded as the packets of the WebRTC publisher, there is no one to ask for a keyframe.
+type IngestTrack struct {
+	mediaTrack *MediaTrack
+	payloader  rtp.Payloader
+	clockRate  int64
+
+	ssrc           uint32
+	baseTimestamp  uint32
+	sequenceNumber uint16
+	writer         *io.PipeWriter
+	buf            []byte
+}
+
+func newIngestTrack(mt *MediaTrack) *IngestTrack {
+	var payloader rtp.Payloader = &aacPayloader{}
+	if mt.Kind == webrtc.RTPCodecTypeVideo {
+		payloader = &codecs.H264Payloader{}
+	}
+
+	reader, writer := io.Pipe()
+	t := &IngestTrack{
+		mediaTrack:     mt,
+		payloader:      payloader,
+		clockRate:      int64(mt.Codec.ClockRate),
+		ssrc:           rand.Uint32() | 1,
+		baseTimestamp:  rand.Uint32(),
+		sequenceNumber: uint16(rand.Uint32()),
+		writer:         writer,
+		buf:            make([]byte, 1500),
+	}
+
+	go func() {
+		mt.forward(&ingestReader{reader}, LowLayer, webrtc.SSRC(t.ssrc), 0, 0)
+
+		// The writer fails if the track is not forwarded anymore
+		_ = reader.CloseWithError(errIngestTrackClosed)
+	}()
+
+	return t
+}
+
+// WriteFrame packetizes the frame of the presentation time, the packets share the timestamp of the frame
+func (t *IngestTrack) WriteFrame(data []byte, timestamp time.Duration) error {
+	payloads := t.payloader.Payload(ingestMTU, data)
+
+	header := rtp.Header{
+		Version:     2,
+		PayloadType: uint8(t.mediaTrack.Codec.PayloadType),
+		SSRC:        t.ssrc,
+		Timestamp:   t.baseTimestamp + uint32(timestamp.Milliseconds()*t.clockRate/1000),
+	}
+	for i, payload := range payloads {
+		t.sequenceNumber++
+		header.SequenceNumber = t.sequenceNumber
+		// The last packet of the frame
+		header.Marker = i == len(payloads)-1
+
+		packet := &rtp.Packet{Header: header, Payload: payload}
+		n, err := packet.MarshalTo(t.buf)
+		if err != nil {
+			return err
+		}
+		if _, err := t.writer.Write(t.buf[:n]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close ends the forwarding of the track
+func (t *IngestTrack) Close() {
+	_ = t.writer.Close()
+}
+
+// ingestReader reads the packets of the ingest track, every write into the pipe is the single packet
+type ingestReader struct {
+	reader *io.PipeReader
+}
+
+func (r *ingestReader) Read(b []byte) (int, interceptor.Attributes, error) {
+	n, err := r.reader.Read(b)
+
+	return n, nil, err
+}
+
+// aacPayloader packetizes the AAC frame in the AAC-hbr mode of RFC 3640. The frame larger than the packet
+// is fragmented, every fragment carries the size of the whole frame.
+type aacPayloader struct{}
+
+func (p *aacPayloader) Payload(mtu uint16, frame []byte) [][]byte {
+	if len(frame) == 0 || len(frame) > maxAACFrameSize || int(mtu) <= aacHeadersSize {
+		return nil
+	}
+
+	maxSize := int(mtu) - aacHeadersSize
+	payloads := make([][]byte, 0, len(frame)/maxSize+1)
+	for offset := 0; offset < len(frame); offset += maxSize {
+		end := offset + maxSize
+		if end > len(frame) {
+			end = len(frame)
+		}
+
+		payload := make([]byte, aacHeadersSize+end-offset)
+		// the length of the AU-headers in bits, the AU-size and the AU-index of the header
+		binary.BigEndian.PutUint16(payload[0:2], 16)
+		binary.BigEndian.PutUint16(payload[2:4], uint16(len(frame))<<3)
+		copy(payload[aacHeadersSize:], frame[offset:end])
+		payloads = append(payloads, payload)
+	}
+
+	return payloads
+}
@@ -1,14 +1,27 @@
 package rtc
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/isqad/livelook-sfu/internal/config"
 	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
 	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 )
 
+const (
+	// initial estimation of the subscriber bandwidth, bps
+	initialBitrate = 1 * 1000 * 1000
+
+	mimeTypeRTX = "video/rtx"
+)
+
 var (
 	enabledCodecParams = map[webrtc.RTPCodecType][]webrtc.RTPCodecParameters{
 		webrtc.RTPCodecTypeAudio: []webrtc.RTPCodecParameters{},
@@ -20,9 +33,10 @@ func createMediaEngine(
 	enabledCodecs config.EnabledCodecs,
 	directionConfig config.DirectionConfig,
 	target rpc.SignalingTarget,
+	onBandwidthEstimator func(estimator cc.BandwidthEstimator),
 ) (*webrtc.MediaEngine, *interceptor.Registry, error) {
 	mediaEngine := &webrtc.MediaEngine{}
-	if err := registerCodecs(mediaEngine, enabledCodecs, directionConfig.RTCPFeedback); err != nil {
+	if err := registerCodecs(mediaEngine, enabledCodecs, directionConfig.RTCPFeedback, directionConfig.RTX); err != nil {
 		return nil, nil, err
 	}
 
@@ -36,60 +50,96 @@ func createMediaEngine(
 		if err := webrtc.RegisterDefaultInterceptors(mediaEngine, ir); err != nil {
 			return nil, nil, err
 		}
+
+		return mediaEngine, ir, nil
 	}
-	// Receiver is not implemented for now
 
-	return mediaEngine, ir, nil
+	// Sender reports let the subscriber report the round trip time in its receiver reports
+	sr, err := report.NewSenderInterceptor()
+	if err != nil {
+		return nil, nil, err
+	}
+	ir.Add(sr)
+
+	isSendSideBWE := false
+	for _, ext := range directionConfig.RTPHeaderExtension.Video {
+		if ext == sdp.TransportCCURI {
+			isSendSideBWE = true
+			break
+		}
+	}
+	for _, ext := range directionConfig.RTPHeaderExtension.Audio {
+		if ext == sdp.TransportCCURI {
+			isSendSideBWE = true
+			break
+		}
+	}
+
+	if isSendSideBWE {
+		gf, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+			return gcc.NewSendSideBWE(
+				gcc.SendSideBWEInitialBitrate(initialBitrate),
+				gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+			)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		gf.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
+			if onBandwidthEstimator != nil {
+				onBandwidthEstimator(estimator)
+			}
+		})
+		ir.Add(gf)
 
-	// if params.Target == rpc.Receiver {
-	// 	isSendSideBWE := false
-	// 	for _, ext := range directionConfig.RTPHeaderExtension.Video {
-	// 		if ext == sdp.TransportCCURI {
-	// 			isSendSideBWE = true
-	// 			break
-	// 		}
-	// 	}
-	// 	for _, ext := range directionConfig.RTPHeaderExtension.Audio {
-	// 		if ext == sdp.TransportCCURI {
-	// 			isSendSideBWE = true
-	// 			break
-	// 		}
-	// 	}
-
-	// 	if isSendSideBWE {
-	// 		gf, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
-	// 			return gcc.NewSendSideBWE(
-	// 				gcc.SendSideBWEInitialBitrate(1*1000*1000),
-	// 				gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
-	// 			)
-	// 		})
-	// 		if err == nil {
-	// 			gf.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
-	// 				if onBandwidthEstimator != nil {
-	// 					onBandwidthEstimator(estimator)
-	// 				}
-	// 			})
-	// 			ir.Add(gf)
-
-	// 			tf, err := twcc.NewHeaderExtensionInterceptor()
-	// 			if err == nil {
-	// 				ir.Add(tf)
-	// 			}
-	// 		}
-	// 	}
-	// }
+		tf, err := twcc.NewHeaderExtensionInterceptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		ir.Add(tf)
+	}
+
+	return mediaEngine, ir, nil
 }
 
 func registerCodecs(
 	mediaEngine *webrtc.MediaEngine,
 	enabledCodecs config.EnabledCodecs,
 	rtcpFeedback config.RTCPFeedbackConfig,
+	rtx bool,
 ) error {
 	if err := registerAudioCodecs(mediaEngine, enabledCodecs, rtcpFeedback); err != nil {
 		return err
 	}
 
-	return registerVideoCodecs(mediaEngine, enabledCodecs, rtcpFeedback)
+	if err := registerVideoCodecs(mediaEngine, enabledCodecs, rtcpFeedback); err != nil {
+		return err
+	}
+
+	if rtx {
+		return registerRTXCodecs(mediaEngine)
+	}
+
+	return nil
+}
+
+// registerRTXCodecs registers the retransmission payload type next to every video codec
+func registerRTXCodecs(mediaEngine *webrtc.MediaEngine) error {
+	for _, params := range enabledCodecParams[webrtc.RTPCodecTypeVideo] {
+		rtxParams := webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    mimeTypeRTX,
+				ClockRate:   90000,
+				SDPFmtpLine: fmt.Sprintf("apt=%d", params.PayloadType),
+			},
+			PayloadType: params.PayloadType + 1,
+		}
+		if err := mediaEngine.RegisterCodec(rtxParams, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func registerAudioCodecs(
@@ -101,7 +151,7 @@ func registerAudioCodecs(
 		opusCodec := webrtc.RTPCodecCapability{
 			MimeType:     webrtc.MimeTypeOpus,
 			ClockRate:    48000,
-			Channels:     1,
+			Channels:     2,
 			SDPFmtpLine:  "minptime=10;useinbandfec=1",
 			RTCPFeedback: rtcpFeedback.Audio,
 		}
@@ -176,6 +226,15 @@ func registerVideoCodecs(
 				},
 				PayloadType: 108,
 			},
+			{
+				RTPCodecCapability: webrtc.RTPCodecCapability{
+					MimeType:     webrtc.MimeTypeH264,
+					ClockRate:    90000,
+					SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f",
+					RTCPFeedback: rtcpFeedback.Video,
+				},
+				PayloadType: 102,
+			},
 			{
 				RTPCodecCapability: webrtc.RTPCodecCapability{
 					MimeType:     webrtc.MimeTypeH264,
@@ -195,9 +254,12 @@ func registerVideoCodecs(
 			},
 		}
 
-		for _, codec := range availableCodecs {
-			if isCodecEnabled(enabledCodecs, codec.RTPCodecCapability) {
-				enabledCodecParams[webrtc.RTPCodecTypeVideo] = append(enabledCodecParams[webrtc.RTPCodecTypeVideo], codec)
+		// The codecs are offered to the subscribers in the order of the preference
+		for _, spec := range enabledCodecs {
+			for _, codec := range availableCodecs {
+				if isCodecSpecMatched(spec, codec.RTPCodecCapability) && !isCodecRegistered(codec) {
+					enabledCodecParams[webrtc.RTPCodecTypeVideo] = append(enabledCodecParams[webrtc.RTPCodecTypeVideo], codec)
+				}
 			}
 		}
 	}
@@ -227,12 +289,49 @@ func registerHeaderExtensions(me *webrtc.MediaEngine, rtpHeaderExtension config.
 	return nil
 }
 
+// transcoderCodec returns the codec in the transcoder SDP, the publisher may negotiate another payload type
+func transcoderCodec(codec webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	for _, codecs := range enabledCodecParams {
+		if params, ok := matchCodec(codec, codecs); ok {
+			return params, true
+		}
+	}
+
+	return webrtc.RTPCodecParameters{}, false
+}
+
+// isSubscriberCodec is true if the codec of the published track is negotiated with the subscribers,
+// the encoders of the ingest may publish other codecs and profiles
+func isSubscriberCodec(codec webrtc.RTPCodecParameters) bool {
+	for _, codecs := range enabledCodecParams {
+		if _, ok := matchCodec(codec, codecs); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isCodecEnabled(codecs config.EnabledCodecs, cap webrtc.RTPCodecCapability) bool {
 	for _, codec := range codecs {
-		if !strings.EqualFold(codec.Mime, cap.MimeType) {
-			continue
+		if isCodecSpecMatched(codec, cap) {
+			return true
 		}
-		if codec.FmtpLine == "" || strings.EqualFold(codec.FmtpLine, cap.SDPFmtpLine) {
+	}
+	return false
+}
+
+func isCodecSpecMatched(codec config.CodecSpec, cap webrtc.RTPCodecCapability) bool {
+	if !strings.EqualFold(codec.Mime, cap.MimeType) {
+		return false
+	}
+
+	return codec.FmtpLine == "" || strings.EqualFold(codec.FmtpLine, cap.SDPFmtpLine)
+}
+
+func isCodecRegistered(codec webrtc.RTPCodecParameters) bool {
+	for _, params := range enabledCodecParams[webrtc.RTPCodecTypeVideo] {
+		if params.PayloadType == codec.PayloadType {
 			return true
 		}
 	}
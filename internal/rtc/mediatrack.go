@@ -2,84 +2,209 @@ package rtc
 
 import (
 	"errors"
-	"fmt"
-	"net"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog/log"
-)
 
-type udpConn struct {
-	conn        *net.UDPConn
-	port        int
-	payloadType webrtc.PayloadType
-}
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/telemetry"
+)
 
 type MediaTrackID string
 
-// TODO
-// ffmpeg -protocol_whitelist file,udp,rtp -i rtp-forwarder.sdp -c:v libx264 -preset veryfast -crf 18 -b:v 3000k -maxrate 3000k -bufsize 6000k -pix_fmt yuv420p -g 30 -flags low_delay -hls_time 2 -hls_flags 'delete_segments' -hls_list_size 5 stream.m3u8
+// KeyframeReason is why the keyframe of the published track is needed
+type KeyframeReason string
+
+const (
+	KeyframeReasonSubscribe   KeyframeReason = "subscribe"
+	KeyframeReasonLayerSwitch KeyframeReason = "layer_switch"
+	KeyframeReasonResume      KeyframeReason = "resume"
+	KeyframeReasonTranscoder  KeyframeReason = "transcoder"
+	// PLI or FIR of the subscriber
+	KeyframeReasonDownstream KeyframeReason = "downstream"
+	KeyframeReasonUnmute     KeyframeReason = "unmute"
+	KeyframeReasonRecording  KeyframeReason = "recording"
+	KeyframeReasonForward    KeyframeReason = "forward"
+
+	// the publisher is asked for a keyframe of the layer not more often than this
+	keyframeRequestInterval = 500 * time.Millisecond
+)
+
+// MediaTrack is a published audio or video track. Its packets are forwarded to the subscribers
+// and to the transcoder, which muxes every track of the participant into the HLS stream.
 type MediaTrack struct {
-	ID             MediaTrackID
-	laddr          *net.UDPAddr
-	transcoderConn *udpConn
+	ID         MediaTrackID
+	StreamID   string
+	Kind       webrtc.RTPCodecType
+	Codec      webrtc.RTPCodecParameters
+	transcoder TranscoderTransport
+	// payload type of the codec in the transcoder SDP
+	transcoderPayloadType webrtc.PayloadType
+	transcoderPackets     atomic.Uint64
+	transcoderDropped     atomic.Uint64
+	// the drops are logged once until the transcoder receives the packets again
+	transcoderFailing atomic.Bool
+	// the layers reach the transcoder as the single RTP stream
+	transcoderStream transcoderStream
+
+	lock       sync.RWMutex
+	downTracks map[core.UserSessionID]*DownTrack
+	// SSRCs of the received spatial layers, zero if the layer is not published
+	layerSSRCs [maxSpatialLayers]webrtc.SSRC
+	// the transcoder receives the highest published layer only
+	transcoderLayer int
+	layerBitrates   [maxSpatialLayers]bitrateMeter
+	// recent packets of every layer for the retransmission on NACK
+	layerCaches [maxSpatialLayers]*packetCache
+	// spatial layers of the single SVC stream, more than one if the publisher sends k-SVC or L3T3
+	svcSpatialLayers int
+	svcBitrates      [maxSpatialLayers]bitrateMeter
+	// when the keyframe of the layer has been requested last time
+	keyframeRequestedAt [maxSpatialLayers]time.Time
+	keyframeRequests    uint64
+	// RTP statistics of the received layers
+	layerStats [maxSpatialLayers]*receiveStats
+	// the muted track is not forwarded, the track muted by the moderator is unmuted by the moderator only
+	muted      bool
+	forceMuted bool
+	// writes the layer forwarded to the transcoder into the file while the participant is recorded
+	recorder *TrackRecorder
+	// the external receivers of the layer forwarded to the transcoder
+	forwardTargets map[string]*ForwardTarget
+
+	onKeyframeRequest func(ssrc webrtc.SSRC)
+	// rooms observing the audio levels of the track, keyed by the room ID
+	audioLevelListeners map[core.UserSessionID]func(level uint8)
+}
+
+type MediaTrackParams struct {
+	TrackID    MediaTrackID
+	StreamID   string
+	Kind       webrtc.RTPCodecType
+	Codec      webrtc.RTPCodecParameters
+	Transcoder TranscoderTransport // nil if the transcoder doesn't receive the codec of the track
+	// payload type of the codec in the transcoder SDP
+	TranscoderPayloadType webrtc.PayloadType
 }
 
-func NewMediaTrack(trackID MediaTrackID, payloadType webrtc.PayloadType, transcoderPort int) (*MediaTrack, error) {
+func NewMediaTrack(params MediaTrackParams) *MediaTrack {
 	mt := &MediaTrack{
-		ID: trackID,
+		ID:                    params.TrackID,
+		StreamID:              params.StreamID,
+		Kind:                  params.Kind,
+		Codec:                 params.Codec,
+		transcoder:            params.Transcoder,
+		transcoderPayloadType: params.TranscoderPayloadType,
+		downTracks:            make(map[core.UserSessionID]*DownTrack),
+		forwardTargets:        make(map[string]*ForwardTarget),
+		audioLevelListeners:   make(map[core.UserSessionID]func(level uint8)),
+		transcoderLayer:       InvalidLayer,
+		transcoderStream:      transcoderStream{layer: InvalidLayer},
 	}
 
-	mt.transcoderConn = &udpConn{
-		port:        transcoderPort,
-		payloadType: payloadType,
+	for layer := range mt.layerCaches {
+		mt.layerCaches[layer] = newPacketCache()
 	}
 
-	var err error
-	if mt.laddr, err = net.ResolveUDPAddr("udp", "127.0.0.1:"); err != nil {
-		return nil, err
-	}
+	return mt
+}
 
-	return mt, nil
+// rtpReader reads the packets of the single layer, the remote track of the peer connection or the ingest track
+type rtpReader interface {
+	Read(b []byte) (int, interceptor.Attributes, error)
 }
 
+// ForwardRTP reads packets of the single layer of the track and forwards them to the subscribers
+// and to the transcoder. It is called for every simulcast layer.
 func (t *MediaTrack) ForwardRTP(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
-	log.Debug().Str("service", "MediaTrack").Str("ID", string(t.ID)).Msgf("forward %v", track.Kind().String())
-
-	var (
-		err   error
-		raddr *net.UDPAddr
-	)
+	log.Debug().Str("service", "MediaTrack").Str("ID", string(t.ID)).Str("rid", track.RID()).Msgf("forward %v", track.Kind().String())
 
-	if raddr, err = net.ResolveUDPAddr("udp", fmt.Sprintf("0.0.0.0:%d", t.transcoderConn.port)); err != nil {
-		log.Error().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("")
+	layer := layerFromRID(track.RID())
+	if layer == InvalidLayer {
+		log.Error().Str("service", "MediaTrack").Str("ID", string(t.ID)).Str("rid", track.RID()).Msg("unsupported simulcast layer")
 		return
 	}
 
-	// Dial udp
-	if t.transcoderConn.conn, err = net.DialUDP("udp", t.laddr, raddr); err != nil {
-		log.Error().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("")
-		return
+	t.forward(track, layer, track.SSRC(), t.audioLevelExtensionID(rtpReceiver), t.headerExtensionID(rtpReceiver, config.DependencyDescriptorURI))
+}
+
+// forward reads the packets of the layer until the reader fails, the zero extension ID is not negotiated
+func (t *MediaTrack) forward(reader rtpReader, layer int, ssrc webrtc.SSRC, audioLevelExtID uint8, ddExtID uint8) {
+	stats := t.addLayer(layer, ssrc)
+	defer t.removeLayer(layer)
+
+	// The transcoder can't decode the layer until its keyframe
+	if layer == t.TranscoderLayer() {
+		t.RequestKeyframe(layer, KeyframeReasonTranscoder)
 	}
 
+	svc := newSVCParser(t.Codec, ddExtID)
+
+	var err error
 	b := make([]byte, 1500)
 	rtpPacket := &rtp.Packet{}
 
 	for {
 		// Read
-		n, _, readErr := track.Read(b)
+		n, _, readErr := reader.Read(b)
 		if readErr != nil {
 			log.Error().Err(readErr).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("read track")
 			return
 		}
+		t.layerBitrates[layer].Add(n)
 
 		// Unmarshal the packet and update the PayloadType
 		if err = rtpPacket.Unmarshal(b[:n]); err != nil {
 			log.Error().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("read track")
 			return
 		}
-		rtpPacket.PayloadType = uint8(t.transcoderConn.payloadType)
+
+		now := time.Now()
+		stats.Update(&rtpPacket.Header, n, now)
+		stats.Report(now)
+		t.layerCaches[layer].Push(rtpPacket.SequenceNumber, b[:n])
+
+		if t.IsMuted() {
+			continue
+		}
+
+		if audioLevelExtID != 0 {
+			t.observeAudioLevel(rtpPacket.GetExtension(audioLevelExtID))
+		}
+
+		var svcInfo *svcLayer
+		if svc != nil {
+			if info, ok := svc.Parse(rtpPacket); ok {
+				svcInfo = &info
+				t.observeSVCLayer(info, n)
+			}
+		}
+
+		t.writeDownTracks(layer, rtpPacket, svcInfo)
+
+		transcoderLayer := t.TranscoderLayer()
+		if layer == transcoderLayer {
+			if recorder := t.Recorder(); recorder != nil {
+				recorder.WriteRTP(rtpPacket, svcInfo)
+			}
+
+			for _, target := range t.ForwardTargets() {
+				target.WriteRTP(rtpPacket)
+			}
+		}
+
+		if t.transcoder == nil || !t.transcoderStream.rewrite(layer, transcoderLayer, rtpPacket, t.Codec) {
+			continue
+		}
+		rtpPacket.PayloadType = uint8(t.transcoderPayloadType)
 
 		// Marshal into original buffer with updated PayloadType
 		if n, err = rtpPacket.MarshalTo(b); err != nil {
@@ -87,29 +212,547 @@ func (t *MediaTrack) ForwardRTP(track *webrtc.TrackRemote, rtpReceiver *webrtc.R
 			return
 		}
 
-		// Write
-		if _, writeErr := t.transcoderConn.conn.Write(b[:n]); writeErr != nil {
-			// For this particular example, third party applications usually timeout after a short
-			// amount of time during which the user doesn't have enough time to provide the answer
-			// to the browser.
-			// That's why, for this particular example, the user first needs to provide the answer
-			// to the browser then open the third party application. Therefore we must not kill
-			// the forward on "connection refused" errors
-			var opError *net.OpError
-			if errors.As(writeErr, &opError) && opError.Err.Error() == "write: connection refused" {
+		t.writeTranscoder(b[:n])
+	}
+}
+
+// transcoderStream rewrites the packets of the layers forwarded to the transcoder into the single RTP stream,
+// ffmpeg doesn't follow the change of the SSRC and the jumps of the sequence numbers and timestamps
+type transcoderStream struct {
+	lock      sync.Mutex
+	layer     int
+	ssrc      uint32
+	started   bool
+	lastSN    uint16
+	lastTS    uint32
+	lastWrite time.Time
+	snOffset  uint16
+	tsOffset  uint32
+}
+
+// rewrite sets the SSRC, the sequence number and the timestamp of the stream, false if the packet is dropped.
+// The stream switches to the target layer on its keyframe, the current layer is forwarded until then.
+func (s *transcoderStream) rewrite(layer int, target int, packet *rtp.Packet, codec webrtc.RTPCodecParameters) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if layer != s.layer {
+		if layer != target || !isKeyframe(codec, packet) {
+			return false
+		}
+		s.switchLayer(layer, packet, codec.ClockRate)
+	}
+
+	packet.SSRC = s.ssrc
+	packet.SequenceNumber += s.snOffset
+	packet.Timestamp += s.tsOffset
+
+	if !s.started || int16(packet.SequenceNumber-s.lastSN) > 0 {
+		s.lastSN = packet.SequenceNumber
+		s.lastTS = packet.Timestamp
+		s.lastWrite = time.Now()
+	}
+	s.started = true
+
+	return true
+}
+
+// switchLayer computes offsets so that the new layer continues the sequence of the previous one,
+// the stream keeps the SSRC of the first layer
+func (s *transcoderStream) switchLayer(layer int, packet *rtp.Packet, clockRate uint32) {
+	s.layer = layer
+	if !s.started {
+		s.ssrc = packet.SSRC
+		return
+	}
+
+	tsDelta := uint32(time.Since(s.lastWrite).Seconds() * float64(clockRate))
+	if tsDelta == 0 {
+		tsDelta = 1
+	}
+
+	s.snOffset = s.lastSN + 1 - packet.SequenceNumber
+	s.tsOffset = s.lastTS + tsDelta - packet.Timestamp
+}
+
+// writeTranscoder sends the packet to the transcoder. The transcoder starts after the publisher
+// and may exit at any time, the failed packet is dropped and the forwarding to the subscribers goes on.
+func (t *MediaTrack) writeTranscoder(packet []byte) {
+	if err := t.transcoder.Write(packet); err != nil {
+		t.transcoderDropped.Add(1)
+		telemetry.TranscoderPacket(false)
+
+		if t.transcoderFailing.CompareAndSwap(false, true) {
+			log.Warn().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("transcoder drops packets")
+		}
+		return
+	}
+
+	t.transcoderPackets.Add(1)
+	telemetry.TranscoderPacket(true)
+
+	if t.transcoderFailing.CompareAndSwap(true, false) {
+		log.Info().Str("service", "MediaTrack").Str("ID", string(t.ID)).Uint64("dropped", t.transcoderDropped.Load()).Msg("transcoder receives packets")
+	}
+}
+
+// audioLevelExtensionID returns the negotiated ID of the audio level extension, zero if it is not negotiated
+func (t *MediaTrack) audioLevelExtensionID(rtpReceiver *webrtc.RTPReceiver) uint8 {
+	if t.Kind != webrtc.RTPCodecTypeAudio {
+		return 0
+	}
+
+	return t.headerExtensionID(rtpReceiver, sdp.AudioLevelURI)
+}
+
+// headerExtensionID returns the negotiated ID of the extension, zero if it is not negotiated
+func (t *MediaTrack) headerExtensionID(rtpReceiver *webrtc.RTPReceiver, uri string) uint8 {
+	for _, ext := range rtpReceiver.GetParameters().HeaderExtensions {
+		if ext.URI == uri {
+			return uint8(ext.ID)
+		}
+	}
+
+	return 0
+}
+
+// observeSVCLayer measures the bitrate of the spatial layers of the SVC stream
+func (t *MediaTrack) observeSVCLayer(info svcLayer, size int) {
+	if info.spatial < LowLayer || info.spatial >= maxSpatialLayers {
+		return
+	}
+	t.svcBitrates[info.spatial].Add(size)
+
+	t.lock.Lock()
+	if info.spatial >= t.svcSpatialLayers {
+		t.svcSpatialLayers = info.spatial + 1
+	}
+	t.lock.Unlock()
+}
+
+func (t *MediaTrack) observeAudioLevel(payload []byte) {
+	if payload == nil {
+		return
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if len(t.audioLevelListeners) == 0 {
+		return
+	}
+
+	ext := rtp.AudioLevelExtension{}
+	if err := ext.Unmarshal(payload); err != nil {
+		return
+	}
+
+	// The listeners only pass the level to the observers of the rooms, they don't lock the track
+	for _, listener := range t.audioLevelListeners {
+		listener(ext.Level)
+	}
+}
+
+// addLayer registers the received layer, its statistics start over
+func (t *MediaTrack) addLayer(layer int, ssrc webrtc.SSRC) *receiveStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.layerSSRCs[layer] = ssrc
+	if layer > t.transcoderLayer {
+		t.transcoderLayer = layer
+	}
+	t.layerStats[layer] = newReceiveStats(t.Codec.ClockRate)
+
+	return t.layerStats[layer]
+}
+
+func (t *MediaTrack) removeLayer(layer int) {
+	t.lock.Lock()
+	t.layerSSRCs[layer] = 0
+	if layer != t.transcoderLayer {
+		t.lock.Unlock()
+		return
+	}
+	t.transcoderLayer = t.availableLayer(HighLayer)
+	transcoderLayer := t.transcoderLayer
+	t.lock.Unlock()
+
+	// The transcoder continues with the lower layer from its keyframe
+	t.RequestKeyframe(transcoderLayer, KeyframeReasonTranscoder)
+}
+
+// Layers returns the published spatial layers in ascending order, the simulcast or the SVC ones
+func (t *MediaTrack) Layers() []int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	layers := make([]int, 0, maxSpatialLayers)
+	if t.svcSpatialLayers > 1 {
+		for layer := LowLayer; layer < t.svcSpatialLayers; layer++ {
+			layers = append(layers, layer)
+		}
+		return layers
+	}
+
+	for layer, ssrc := range t.layerSSRCs {
+		if ssrc != 0 {
+			layers = append(layers, layer)
+		}
+	}
+
+	return layers
+}
+
+// LayerBitrate returns the measured bitrate of the layer, bps.
+// The SVC layer depends on the lower ones, its bitrate includes them.
+func (t *MediaTrack) LayerBitrate(layer int) int {
+	if layer < LowLayer || layer >= maxSpatialLayers {
+		return 0
+	}
+
+	if t.IsSVC() {
+		bitrate := 0
+		for l := LowLayer; l <= layer; l++ {
+			bitrate += t.svcBitrates[l].Bitrate()
+		}
+		return bitrate
+	}
+
+	return t.layerBitrates[layer].Bitrate()
+}
+
+// IsSVC is true when the publisher sends the spatial layers in the single stream
+func (t *MediaTrack) IsSVC() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.svcSpatialLayers > 1
+}
+
+// GetPacket reads the cached packet of the layer into buf
+func (t *MediaTrack) GetPacket(layer int, sn uint16, buf []byte) (int, bool) {
+	if layer < LowLayer || layer >= maxSpatialLayers {
+		return 0, false
+	}
+
+	return t.layerCaches[layer].Get(sn, buf)
+}
+
+// IsSimulcast is true when the publisher sends more than one spatial layer
+func (t *MediaTrack) IsSimulcast() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	layers := 0
+	for _, ssrc := range t.layerSSRCs {
+		if ssrc != 0 {
+			layers++
+		}
+	}
+
+	return layers > 1
+}
+
+func (t *MediaTrack) TranscoderLayer() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.transcoderLayer
+}
+
+// AvailableLayer returns the highest published layer not above the target one,
+// or the lowest published layer if there is no such layer
+func (t *MediaTrack) AvailableLayer(target int) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.availableLayer(target)
+}
+
+func (t *MediaTrack) availableLayer(target int) int {
+	for layer := target; layer >= LowLayer; layer-- {
+		if t.layerSSRCs[layer] != 0 {
+			return layer
+		}
+	}
+	for layer := target + 1; layer < maxSpatialLayers; layer++ {
+		if t.layerSSRCs[layer] != 0 {
+			return layer
+		}
+	}
+
+	return InvalidLayer
+}
+
+// OnKeyframeRequest sets the handler which asks the publisher for a keyframe
+func (t *MediaTrack) OnKeyframeRequest(f func(ssrc webrtc.SSRC)) {
+	t.lock.Lock()
+	t.onKeyframeRequest = f
+	t.lock.Unlock()
+}
+
+// RequestKeyframe asks the publisher for a keyframe of the layer.
+// The requests are throttled, the keyframe of the recent request serves the others.
+func (t *MediaTrack) RequestKeyframe(layer int, reason KeyframeReason) {
+	if t.Kind != webrtc.RTPCodecTypeVideo || layer < LowLayer || layer >= maxSpatialLayers {
+		return
+	}
+
+	t.lock.Lock()
+	ssrc := t.layerSSRCs[layer]
+	onKeyframeRequest := t.onKeyframeRequest
+	if ssrc == 0 || onKeyframeRequest == nil {
+		t.lock.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(t.keyframeRequestedAt[layer]) < keyframeRequestInterval {
+		t.lock.Unlock()
+		telemetry.KeyframeRequestThrottled(string(reason))
+		return
+	}
+	t.keyframeRequestedAt[layer] = now
+	t.keyframeRequests++
+	t.lock.Unlock()
+
+	log.Debug().Str("service", "MediaTrack").Str("ID", string(t.ID)).Int("layer", layer).Str("reason", string(reason)).Msg("request keyframe")
+	telemetry.KeyframeRequested(string(reason))
+
+	onKeyframeRequest(ssrc)
+}
+
+// Stats returns the statistics of the received layers and of the forwarding to every subscriber.
+// The counters of the layers are summed up, the loss and the jitter are of the worst layer.
+func (t *MediaTrack) Stats() TrackStats {
+	stats := TrackStats{
+		TrackID:   t.ID,
+		Kind:      t.Kind.String(),
+		Direction: directionInbound,
+		Codec:     t.Codec.MimeType,
+	}
+
+	t.lock.RLock()
+	layerStats := t.layerStats
+	stats.KeyframeRequests = t.keyframeRequests
+	stats.SpatialLayer = t.transcoderLayer
+	t.lock.RUnlock()
+
+	stats.TranscoderPackets = t.transcoderPackets.Load()
+	stats.TranscoderDropped = t.transcoderDropped.Load()
+
+	for layer, ls := range layerStats {
+		if ls == nil {
+			continue
+		}
+		ls.Snapshot(&stats)
+		stats.Bitrate += t.layerBitrates[layer].Bitrate()
+	}
+
+	for _, dt := range t.DownTracks() {
+		stats.Subscribers = append(stats.Subscribers, dt.Stats())
+	}
+
+	return stats
+}
+
+// SetMuted stops or resumes forwarding the track to the subscribers and to the transcoder.
+// It returns true if the state has changed.
+func (t *MediaTrack) SetMuted(muted bool, moderator bool) (bool, error) {
+	t.lock.Lock()
+	if !muted && t.forceMuted && !moderator {
+		t.lock.Unlock()
+		return false, errTrackForceMuted
+	}
+
+	changed := t.muted != muted || (muted && moderator && !t.forceMuted)
+	t.muted = muted
+	if !muted {
+		t.forceMuted = false
+	} else if moderator {
+		t.forceMuted = true
+	}
+	t.lock.Unlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	log.Debug().Str("service", "MediaTrack").Str("ID", string(t.ID)).Bool("muted", muted).Bool("moderator", moderator).Msg("set muted")
+
+	if muted {
+		// The subscribers continue from the keyframe after the unmute
+		for _, dt := range t.DownTracks() {
+			dt.restart()
+		}
+		return true, nil
+	}
+
+	for _, layer := range t.Layers() {
+		t.RequestKeyframe(layer, KeyframeReasonUnmute)
+	}
+
+	return true, nil
+}
+
+// MuteState returns whether the track is muted and whether it is muted by the moderator
+func (t *MediaTrack) MuteState() (bool, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.muted, t.forceMuted
+}
+
+func (t *MediaTrack) IsMuted() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.muted
+}
+
+// Recorder returns the recorder of the track, nil if the track is not recorded
+func (t *MediaTrack) Recorder() *TrackRecorder {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.recorder
+}
+
+// setRecorder replaces the recorder of the track and returns the previous one
+func (t *MediaTrack) setRecorder(recorder *TrackRecorder) *TrackRecorder {
+	t.lock.Lock()
+	previous := t.recorder
+	t.recorder = recorder
+	t.lock.Unlock()
+
+	// The recording starts from the keyframe
+	if recorder != nil {
+		t.RequestKeyframe(t.TranscoderLayer(), KeyframeReasonRecording)
+	}
+
+	return previous
+}
+
+// AddAudioLevelListener passes the audio levels sent by the publisher to the room
+func (t *MediaTrack) AddAudioLevelListener(roomID core.UserSessionID, f func(level uint8)) {
+	t.lock.Lock()
+	t.audioLevelListeners[roomID] = f
+	t.lock.Unlock()
+}
+
+func (t *MediaTrack) RemoveAudioLevelListener(roomID core.UserSessionID) {
+	t.lock.Lock()
+	delete(t.audioLevelListeners, roomID)
+	t.lock.Unlock()
+}
+
+// AddForwardTarget starts forwarding the track to the external receiver
+func (t *MediaTrack) AddForwardTarget(params ForwardTargetParams) (*ForwardTarget, error) {
+	t.lock.RLock()
+	targets := len(t.forwardTargets)
+	t.lock.RUnlock()
+	if targets >= maxForwardTargets {
+		return nil, errTooManyForwardTargets
+	}
+
+	target, err := newForwardTarget(t.ID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	t.forwardTargets[target.ID] = target
+	t.lock.Unlock()
+
+	log.Debug().Str("service", "MediaTrack").Str("ID", string(t.ID)).Str("target", target.ID).Str("addr", target.conn.RemoteAddr().String()).Msg("add forward target")
+
+	// The receiver can't decode the stream until its keyframe
+	t.RequestKeyframe(t.TranscoderLayer(), KeyframeReasonForward)
+
+	return target, nil
+}
+
+// RemoveForwardTarget stops forwarding the track to the receiver, it returns false if the track has no such target
+func (t *MediaTrack) RemoveForwardTarget(targetID string) bool {
+	t.lock.Lock()
+	target, ok := t.forwardTargets[targetID]
+	delete(t.forwardTargets, targetID)
+	t.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	log.Debug().Str("service", "MediaTrack").Str("ID", string(t.ID)).Str("target", targetID).Msg("remove forward target")
+
+	if err := target.Close(); err != nil {
+		log.Error().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Str("target", targetID).Msg("close forward target")
+	}
+
+	return true
+}
+
+func (t *MediaTrack) ForwardTargets() []*ForwardTarget {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	targets := make([]*ForwardTarget, 0, len(t.forwardTargets))
+	for _, target := range t.forwardTargets {
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+func (t *MediaTrack) AddDownTrack(downTrack *DownTrack) {
+	t.lock.Lock()
+	t.downTracks[downTrack.SubscriberID] = downTrack
+	t.lock.Unlock()
+}
+
+func (t *MediaTrack) RemoveDownTrack(subscriberID core.UserSessionID) {
+	t.lock.Lock()
+	delete(t.downTracks, subscriberID)
+	t.lock.Unlock()
+}
+
+func (t *MediaTrack) DownTracks() []*DownTrack {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	downTracks := make([]*DownTrack, 0, len(t.downTracks))
+	for _, dt := range t.downTracks {
+		downTracks = append(downTracks, dt)
+	}
+
+	return downTracks
+}
+
+// writeDownTracks forwards the packet of the layer to every subscriber
+func (t *MediaTrack) writeDownTracks(layer int, packet *rtp.Packet, svc *svcLayer) {
+	for _, dt := range t.DownTracks() {
+		if err := dt.WriteRTP(layer, packet, svc); err != nil {
+			if errors.Is(err, io.ErrClosedPipe) {
+				t.RemoveDownTrack(dt.SubscriberID)
 				continue
 			}
-			log.Error().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("write track")
-			return
+			log.Error().Err(err).Str("service", "MediaTrack").Str("ID", string(t.ID)).Str("subscriber", string(dt.SubscriberID)).Msg("write down track")
 		}
 	}
-	// go t.forwardRTP()
 }
 
 func (t *MediaTrack) Close() {
 	log.Debug().Str("service", "participant").Str("ID", string(t.ID)).Msg("TODO: close exists MediaTrack")
 
-	if closeErr := t.transcoderConn.conn.Close(); closeErr != nil {
+	for _, target := range t.ForwardTargets() {
+		t.RemoveForwardTarget(target.ID)
+	}
+
+	if t.transcoder == nil {
+		return
+	}
+	if closeErr := t.transcoder.Close(); closeErr != nil {
 		log.Error().Err(closeErr).Str("service", "MediaTrack").Str("ID", string(t.ID)).Msg("")
 	}
 }
@@ -0,0 +1,48 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscoderStreamRewrite(t *testing.T) {
+	codec := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}}
+	keyframe := []byte{0x65, 0x88}
+	delta := []byte{0x41, 0x9a}
+
+	packet := func(ssrc uint32, sn uint16, ts uint32, payload []byte) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{SSRC: ssrc, SequenceNumber: sn, Timestamp: ts}, Payload: payload}
+	}
+
+	stream := &transcoderStream{layer: InvalidLayer}
+
+	// The stream starts on the keyframe
+	assert.False(t, stream.rewrite(HighLayer, HighLayer, packet(3, 100, 9000, delta), codec))
+	assert.True(t, stream.rewrite(HighLayer, HighLayer, packet(3, 101, 9000, keyframe), codec))
+	assert.True(t, stream.rewrite(HighLayer, HighLayer, packet(3, 102, 12000, delta), codec))
+
+	// The lower layer becomes the target, the high one is forwarded until the keyframe of the lower one
+	assert.False(t, stream.rewrite(LowLayer, LowLayer, packet(1, 5000, 700000, delta), codec))
+	p := packet(3, 103, 15000, delta)
+	assert.True(t, stream.rewrite(HighLayer, LowLayer, p, codec))
+	assert.Equal(t, uint16(103), p.SequenceNumber)
+
+	p = packet(1, 5001, 703000, keyframe)
+	assert.True(t, stream.rewrite(LowLayer, LowLayer, p, codec))
+	assert.Equal(t, uint32(3), p.SSRC)
+	assert.Equal(t, uint16(104), p.SequenceNumber)
+	assert.Greater(t, p.Timestamp, uint32(15000))
+	switchTS := p.Timestamp
+
+	p = packet(1, 5002, 706000, delta)
+	assert.True(t, stream.rewrite(LowLayer, LowLayer, p, codec))
+	assert.Equal(t, uint32(3), p.SSRC)
+	assert.Equal(t, uint16(105), p.SequenceNumber)
+	assert.Equal(t, switchTS+3000, p.Timestamp)
+
+	// The packets of the previous layer are dropped after the switch
+	assert.False(t, stream.rewrite(HighLayer, LowLayer, packet(3, 104, 18000, delta), codec))
+}
@@ -0,0 +1,48 @@
+package rtc
+
+import "sync"
+
+const (
+	// number of the recent packets kept for retransmission, must be a power of two
+	packetCacheSize = 512
+)
+
+type cachedPacket struct {
+	sn    uint16
+	valid bool
+	buf   []byte
+}
+
+// packetCache is a ring buffer of recent RTP packets keyed by sequence number
+type packetCache struct {
+	lock    sync.Mutex
+	packets [packetCacheSize]cachedPacket
+}
+
+func newPacketCache() *packetCache {
+	return &packetCache{}
+}
+
+// Push stores the copy of the raw packet
+func (c *packetCache) Push(sn uint16, raw []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	slot := &c.packets[sn%packetCacheSize]
+	slot.sn = sn
+	slot.valid = true
+	slot.buf = append(slot.buf[:0], raw...)
+}
+
+// Get copies the packet into buf, returns false if the packet has been overwritten
+func (c *packetCache) Get(sn uint16, buf []byte) (int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	slot := &c.packets[sn%packetCacheSize]
+	if !slot.valid || slot.sn != sn || len(buf) < len(slot.buf) {
+		return 0, false
+	}
+
+	return copy(buf, slot.buf), true
+}
@@ -2,10 +2,13 @@ package rtc
 
 import (
 	"encoding/json"
+	"errors"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 
@@ -23,24 +26,71 @@ import (
 
 const (
 	ReliableDataChannel = "_reliable"
+	// unordered data channel without retransmits, late data is worse than lost one
+	LossyDataChannel = "_lossy"
+)
+
+var (
+	errReceiverOfferNotSupported = errors.New("receiver offer is not supported, subscriber transport is server-initiated")
+	errNoSubscriberTransport     = errors.New("subscriber transport is not initialized")
+	errTrackNotFound             = errors.New("track is not published")
+	errTrackForceMuted           = errors.New("track is muted by the moderator")
+	errParticipantClosed         = errors.New("participant is closed")
 )
 
 type Participant struct {
 	sync.RWMutex
 
-	ID              core.UserSessionID
-	publisher       *PCTransport
-	subscriber      *PCTransport
-	reliableDC      *webrtc.DataChannel
-	publishedTracks map[MediaTrackID]*MediaTrack
-	sink            eventbus.Publisher
-	rtcConf         *config.WebRTCConfig
-	nc              *nats.Conn
+	ID               core.UserSessionID
+	publisher        *PCTransport
+	subscriber       *PCTransport
+	reliableDC       *webrtc.DataChannel
+	lossyDC          *webrtc.DataChannel
+	publishedTracks  map[MediaTrackID]*MediaTrack
+	subscribedTracks map[MediaTrackID]*DownTrack
+	sink             eventbus.Publisher
+	enabledCodecs    config.EnabledCodecs
+	rtcConf          *config.WebRTCConfig
+	nc               *nats.Conn
+	closed           bool
+	subscriptionLock sync.Mutex
+
+	// rooms the participant publishes to, keyed by the room ID
+	trackListeners map[core.UserSessionID]func(*Participant, *MediaTrack)
+	// rooms notified when the track of the participant is muted or unmuted
+	muteListeners map[core.UserSessionID]func(*Participant, *MediaTrack)
+
+	// the participant is closed if the failed transports do not reconnect during the grace period
+	failedTransports   map[rpc.SignalingTarget]struct{}
+	reconnectTimer     *time.Timer
+	onReconnectTimeout func(*Participant)
+
+	dataLimiter      *dataRateLimiter
+	lossyDataLimiter *dataRateLimiter
+	onDataPacket     func(*Participant, *DataPacket)
+
+	// the published tracks are written into the files while it is set
+	recording *RecordingParams
+
+	// the tracks of the encoder publishing outside of WebRTC, nil for the WebRTC publisher
+	ingest       *IngestCodecs
+	ingestTracks []*IngestTrack
 
 	// TODO: extract into TranscoderGateway
-	portsAllocator *PortsAllocator
-	allocatedPorts map[webrtc.PayloadType]int
-	transcoderSDP  *sdp.SessionDescription
+	portsAllocator   *PortsAllocator
+	allocatedPorts   map[webrtc.RTPCodecType]int
+	transcoderSDP    *sdp.SessionDescription
+	transcoderDialer TranscoderDialer
+	// the codec of every kind the transcoder receives, nil until it is started
+	transcoderCodecs map[webrtc.RTPCodecType]webrtc.RTPCodecParameters
+}
+
+// RecordingParams sets where the tracks of the participant are recorded and who is notified about the files
+type RecordingParams struct {
+	// the files are written into the subdirectory of the participant
+	Dir        string
+	OnStarted  func(*TrackRecorder)
+	OnFinished func(recorder *TrackRecorder, err error)
 }
 
 type ParticipantOptions struct {
@@ -49,20 +99,34 @@ type ParticipantOptions struct {
 	EnabledCodecs  config.EnabledCodecs
 	RtcConf        *config.WebRTCConfig
 	PortsAllocator *PortsAllocator
-	NatsConn       *nats.Conn
+	// connects the published tracks to the inputs of the transcoder of the allocated ports
+	TranscoderDialer TranscoderDialer
+	NatsConn         *nats.Conn
+	// the participant publishes the tracks of the encoder instead of the WebRTC ones,
+	// the transcoder receives the codecs of the ingest only
+	Ingest *IngestCodecs
 }
 
 func NewParticipant(opts ParticipantOptions) (*Participant, error) {
 	var err error
 
 	p := &Participant{
-		ID:              opts.UserID,
-		sink:            opts.RpcSink,
-		rtcConf:         opts.RtcConf,
-		publishedTracks: make(map[MediaTrackID]*MediaTrack),
-		portsAllocator:  opts.PortsAllocator,
-		allocatedPorts:  make(map[webrtc.PayloadType]int),
-		nc:              opts.NatsConn,
+		ID:               opts.UserID,
+		sink:             opts.RpcSink,
+		enabledCodecs:    opts.EnabledCodecs,
+		rtcConf:          opts.RtcConf,
+		publishedTracks:  make(map[MediaTrackID]*MediaTrack),
+		subscribedTracks: make(map[MediaTrackID]*DownTrack),
+		trackListeners:   make(map[core.UserSessionID]func(*Participant, *MediaTrack)),
+		muteListeners:    make(map[core.UserSessionID]func(*Participant, *MediaTrack)),
+		failedTransports: make(map[rpc.SignalingTarget]struct{}),
+		dataLimiter:      newDataRateLimiter(opts.RtcConf.DataChannel.MessagesPerSecond, opts.RtcConf.DataChannel.MessagesBurst),
+		lossyDataLimiter: newDataRateLimiter(opts.RtcConf.DataChannel.LossyMessagesPerSecond, opts.RtcConf.DataChannel.LossyMessagesBurst),
+		portsAllocator:   opts.PortsAllocator,
+		allocatedPorts:   make(map[webrtc.RTPCodecType]int),
+		transcoderDialer: opts.TranscoderDialer,
+		nc:               opts.NatsConn,
+		ingest:           opts.Ingest,
 	}
 
 	if p.transcoderSDP, err = sdp.NewJSEPSessionDescription(false); err != nil {
@@ -88,53 +152,119 @@ func NewParticipant(opts ParticipantOptions) (*Participant, error) {
 	p.publisher.pc.OnDataChannel(p.onDataChannel)
 	p.publisher.pc.OnTrack(p.onMediaTrack)
 
-	// allocate udp ports for transcoder
-	for codecType, codecs := range enabledCodecParams {
-		for _, codecParams := range codecs {
-			udpPort, err := p.portsAllocator.Allocate()
-			if err != nil {
-				return nil, err
-			}
+	// The codecs of the WebRTC publisher are known after the answer, the ones of the ingest are started at once
+	if err := p.allocatePorts(); err != nil {
+		p.releasePorts()
+		p.publisher.Close()
+		return nil, err
+	}
+	if p.ingest != nil {
+		p.transcoderCodecs = p.ingest.transcoderCodecs()
+		if err := p.startTranscoder(p.transcoderCodecs); err != nil {
+			p.releasePorts()
+			p.publisher.Close()
+			return nil, err
+		}
+	}
 
-			p.allocatedPorts[codecParams.PayloadType] = udpPort
+	return p, nil
+}
 
-			md := sdp.NewJSEPMediaDescription(codecType.String(), []string{})
-			codecName := strings.TrimPrefix(codecParams.MimeType, "audio/")
-			codecName = strings.TrimPrefix(codecName, "video/")
-			md.WithCodec(
-				uint8(codecParams.PayloadType),
-				codecName,
-				codecParams.ClockRate,
-				codecParams.Channels,
-				codecParams.SDPFmtpLine,
-			)
+// allocatePorts allocates the RTP/RTCP port pair of every kind the transcoder may receive
+func (p *Participant) allocatePorts() error {
+	kinds := []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio}
+	if p.ingest != nil {
+		kinds = kinds[:0]
+		for kind := range p.ingest.transcoderCodecs() {
+			kinds = append(kinds, kind)
+		}
+	}
 
-			md.MediaName.Port = sdp.RangedPort{Value: udpPort}
+	for _, kind := range kinds {
+		udpPort, err := p.portsAllocator.Allocate(p)
+		if err != nil {
+			return err
+		}
+		p.allocatedPorts[kind] = udpPort
+	}
+
+	return nil
+}
 
-			p.transcoderSDP.WithMedia(md)
+// startNegotiatedTranscoder starts the transcoder on the codecs of the first answer to the publisher
+// receiving the media, the later negotiations don't change them
+func (p *Participant) startNegotiatedTranscoder() {
+	p.Lock()
+	if p.closed || p.transcoderCodecs != nil || p.publisher.pc.LocalDescription() == nil {
+		p.Unlock()
+		return
+	}
+
+	codecs, err := negotiatedTranscoderCodecs(p.publisher.pc.LocalDescription())
+	if err != nil || len(codecs) == 0 {
+		p.Unlock()
+		if err != nil {
+			log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("negotiated transcoder codecs")
 		}
+		return
+	}
+	p.transcoderCodecs = codecs
+	p.Unlock()
+
+	if err := p.startTranscoder(codecs); err != nil {
+		log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("start transcoder")
+	}
+}
+
+// startTranscoder asks the transcode daemon to receive the single stream of every kind
+// on the RTP port of the kind
+func (p *Participant) startTranscoder(transcoderCodecs map[webrtc.RTPCodecType]webrtc.RTPCodecParameters) error {
+	for _, codecType := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		codecParams, ok := transcoderCodecs[codecType]
+		if !ok {
+			continue
+		}
+
+		md := sdp.NewJSEPMediaDescription(codecType.String(), []string{})
+		codecName := strings.TrimPrefix(codecParams.MimeType, "audio/")
+		codecName = strings.TrimPrefix(codecName, "video/")
+		md.WithCodec(
+			uint8(codecParams.PayloadType),
+			codecName,
+			codecParams.ClockRate,
+			codecParams.Channels,
+			codecParams.SDPFmtpLine,
+		)
+
+		md.MediaName.Port = sdp.RangedPort{Value: p.allocatedPorts[codecType]}
+
+		p.transcoderSDP.WithMedia(md)
 	}
 
 	sd, err := p.transcoderSDP.Marshal()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	message := &transcode.Message{
 		UserID: p.ID,
-		SDP: sd,
+		SDP:    sd,
 	}
 
 	payload, err := json.Marshal(message)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := p.nc.Publish(transcode.TranscoderStartSubj, payload);  err != nil {
-		return nil, err
-	}
+	return p.nc.Publish(transcode.TranscoderStartSubj, payload)
+}
 
-	return p, nil
+// releasePorts returns the port pairs of the transcoder inputs to the allocator
+func (p *Participant) releasePorts() {
+	for _, port := range p.allocatedPorts {
+		p.portsAllocator.Deallocate(p, port)
+	}
+	p.allocatedPorts = nil
 }
 
 func (p *Participant) AddICECandidate(params rpc.ICECandidateParams) error {
@@ -142,9 +272,17 @@ func (p *Participant) AddICECandidate(params rpc.ICECandidateParams) error {
 
 	if params.Target == rpc.Publisher {
 		return p.publisher.AddICECandidate(params.ICECandidateInit)
-	} else {
-		return p.subscriber.AddICECandidate(params.ICECandidateInit)
 	}
+
+	p.RLock()
+	subscriber := p.subscriber
+	p.RUnlock()
+
+	if subscriber == nil {
+		return errNoSubscriberTransport
+	}
+
+	return subscriber.AddICECandidate(params.ICECandidateInit)
 }
 
 func (p *Participant) sendICECandidate(candidate *webrtc.ICECandidate, target rpc.SignalingTarget) error {
@@ -166,7 +304,9 @@ func (p *Participant) HandleOffer(params rpc.SDPParams) error {
 	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Interface("params", params).Msg("handle offer")
 
 	if params.Target == rpc.Publisher {
-		if err := p.publisher.SetRemoteDescription(params.SessionDescription); err != nil {
+		iceRestart := isICERestart(p.publisher.pc.RemoteDescription(), &params.SessionDescription)
+
+		if err := p.applyPublisherOffer(params.SessionDescription); err != nil {
 			return err
 		}
 
@@ -186,13 +326,455 @@ func (p *Participant) HandleOffer(params rpc.SDPParams) error {
 		if err := p.sink.PublishClient(p.ID, rpc); err != nil {
 			return err
 		}
+
+		p.startNegotiatedTranscoder()
+
+		// The network of the client has changed, the subscriber transport has to move too
+		if iceRestart {
+			p.restartSubscriberICE()
+		}
 	} else {
 		log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("handle offer of the receiver")
+		return errReceiverOfferNotSupported
+	}
+
+	return nil
+}
+
+// AnswerPublisher applies the offer of the publisher and returns the answer with the gathered ICE candidates,
+// it serves the clients without the signaling of the candidates (WHIP)
+func (p *Participant) AnswerPublisher(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("answer publisher")
+
+	if err := p.applyPublisherOffer(offer); err != nil {
+		return nil, err
+	}
+
+	answer, err := p.publisher.AnswerGathered()
+	if err != nil {
+		return nil, err
+	}
+
+	p.startNegotiatedTranscoder()
+
+	return &answer, nil
+}
+
+func (p *Participant) applyPublisherOffer(offer webrtc.SessionDescription) error {
+	if err := p.publisher.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+
+	// The answer lists the offered codecs in the order of the preference
+	if err := p.publisher.PreferCodecs(); err != nil {
+		log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("prefer codecs")
 	}
 
 	return nil
 }
 
+// HandleAnswer applies the answer of the client to the offer of the subscriber transport
+func (p *Participant) HandleAnswer(params rpc.SDPParams) error {
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Str("target", string(params.Target)).Msg("handle answer")
+
+	p.RLock()
+	subscriber := p.subscriber
+	p.RUnlock()
+
+	if params.Target != rpc.Receiver || subscriber == nil {
+		return errNoSubscriberTransport
+	}
+
+	return subscriber.SetRemoteDescription(params.SessionDescription)
+}
+
+// AddTrackListener sets the handlers of the tracks published to the room and of their mute state
+func (p *Participant) AddTrackListener(roomID core.UserSessionID, onPublished, onMuted func(*Participant, *MediaTrack)) {
+	p.Lock()
+	p.trackListeners[roomID] = onPublished
+	p.muteListeners[roomID] = onMuted
+	p.Unlock()
+}
+
+func (p *Participant) RemoveTrackListener(roomID core.UserSessionID) {
+	p.Lock()
+	delete(p.trackListeners, roomID)
+	delete(p.muteListeners, roomID)
+	p.Unlock()
+}
+
+// SetTrackMuted mutes or unmutes the published track, every track if the ID is empty.
+// The rooms of the participant are notified about the changed tracks.
+func (p *Participant) SetTrackMuted(trackID MediaTrackID, muted bool, moderator bool) error {
+	p.RLock()
+	tracks := make([]*MediaTrack, 0, len(p.publishedTracks))
+	for id, mt := range p.publishedTracks {
+		if trackID == "" || id == trackID {
+			tracks = append(tracks, mt)
+		}
+	}
+	listeners := make([]func(*Participant, *MediaTrack), 0, len(p.muteListeners))
+	for _, listener := range p.muteListeners {
+		listeners = append(listeners, listener)
+	}
+	p.RUnlock()
+
+	if trackID != "" && len(tracks) == 0 {
+		return errTrackNotFound
+	}
+
+	for _, mt := range tracks {
+		changed, err := mt.SetMuted(muted, moderator)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+
+		for _, listener := range listeners {
+			listener(p, mt)
+		}
+	}
+
+	return nil
+}
+
+// AddForwardTarget forwards the published track to the external receiver
+func (p *Participant) AddForwardTarget(trackID MediaTrackID, params ForwardTargetParams) (*ForwardTarget, error) {
+	p.RLock()
+	mt := p.publishedTracks[trackID]
+	p.RUnlock()
+
+	if mt == nil {
+		return nil, errTrackNotFound
+	}
+
+	return mt.AddForwardTarget(params)
+}
+
+func (p *Participant) PublishedTracks() []*MediaTrack {
+	p.RLock()
+	defer p.RUnlock()
+
+	tracks := make([]*MediaTrack, 0, len(p.publishedTracks))
+	for _, t := range p.publishedTracks {
+		tracks = append(tracks, t)
+	}
+
+	return tracks
+}
+
+// Stats returns the snapshot of the statistics of the published and the subscribed tracks
+func (p *Participant) Stats() ParticipantStats {
+	p.RLock()
+	published := make([]*MediaTrack, 0, len(p.publishedTracks))
+	for _, mt := range p.publishedTracks {
+		published = append(published, mt)
+	}
+	subscribed := make([]*DownTrack, 0, len(p.subscribedTracks))
+	for _, dt := range p.subscribedTracks {
+		subscribed = append(subscribed, dt)
+	}
+	p.RUnlock()
+
+	stats := ParticipantStats{
+		UserID:     p.ID,
+		Published:  make([]TrackStats, 0, len(published)),
+		Subscribed: make([]TrackStats, 0, len(subscribed)),
+	}
+	for _, mt := range published {
+		stats.Published = append(stats.Published, mt.Stats())
+	}
+	for _, dt := range subscribed {
+		stats.Subscribed = append(stats.Subscribed, dt.Stats())
+	}
+
+	return stats
+}
+
+// StartRecording writes every published track into the file, the tracks published later are recorded as well
+func (p *Participant) StartRecording(params RecordingParams) error {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return errParticipantClosed
+	}
+	if p.recording != nil {
+		p.Unlock()
+		return errAlreadyRecording
+	}
+	p.recording = &params
+	tracks := make([]*MediaTrack, 0, len(p.publishedTracks))
+	for _, mt := range p.publishedTracks {
+		tracks = append(tracks, mt)
+	}
+	p.Unlock()
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Str("dir", params.Dir).Msg("start recording")
+
+	for _, mt := range tracks {
+		p.recordTrack(&params, mt)
+	}
+
+	return nil
+}
+
+// StopRecording finishes the files of the recorded tracks
+func (p *Participant) StopRecording() error {
+	p.Lock()
+	recording := p.recording
+	p.recording = nil
+	tracks := make([]*MediaTrack, 0, len(p.publishedTracks))
+	for _, mt := range p.publishedTracks {
+		tracks = append(tracks, mt)
+	}
+	p.Unlock()
+
+	if recording == nil {
+		return errNotRecording
+	}
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("stop recording")
+
+	for _, mt := range tracks {
+		finishRecording(recording, mt.setRecorder(nil))
+	}
+
+	return nil
+}
+
+func (p *Participant) IsRecording() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.recording != nil
+}
+
+// recordTrack starts the recorder of the track, the tracks of the codecs without the writer are skipped
+func (p *Participant) recordTrack(recording *RecordingParams, mt *MediaTrack) {
+	recorder, err := newTrackRecorder(mt, filepath.Join(recording.Dir, string(p.ID)))
+	if err != nil {
+		log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Str("track", string(mt.ID)).Msg("record track")
+		return
+	}
+
+	// The track may be recorded already if it has been published while the recording started
+	if previous := mt.setRecorder(recorder); previous != nil {
+		finishRecording(recording, previous)
+	}
+
+	if recording.OnStarted != nil {
+		recording.OnStarted(recorder)
+	}
+
+	// The recording may have been stopped while the track was published
+	p.RLock()
+	stopped := p.recording != recording
+	p.RUnlock()
+	if stopped {
+		finishRecording(recording, mt.setRecorder(nil))
+	}
+}
+
+func finishRecording(recording *RecordingParams, recorder *TrackRecorder) {
+	if recorder == nil {
+		return
+	}
+
+	err := recorder.Close()
+	if err != nil {
+		log.Error().Err(err).Str("service", "participant").Str("track", string(recorder.TrackID)).Msg("finish recording")
+	}
+
+	if recording.OnFinished != nil {
+		recording.OnFinished(recorder, err)
+	}
+}
+
+func (p *Participant) IsClosed() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.closed
+}
+
+// SubscribeTo adds the published tracks to the subscriber transport and starts the negotiation
+func (p *Participant) SubscribeTo(tracks ...*MediaTrack) error {
+	p.subscriptionLock.Lock()
+	defer p.subscriptionLock.Unlock()
+
+	subscriber, err := p.getOrCreateSubscriber()
+	if err != nil {
+		return err
+	}
+
+	added := 0
+	for _, mt := range tracks {
+		// The track of the ingest may be of the codec the subscribers can't receive
+		if !isSubscriberCodec(mt.Codec) {
+			continue
+		}
+
+		p.RLock()
+		_, exists := p.subscribedTracks[mt.ID]
+		p.RUnlock()
+		if exists {
+			continue
+		}
+
+		dt, err := NewDownTrack(p.ID, mt)
+		if err != nil {
+			return err
+		}
+		if err := dt.Attach(subscriber); err != nil {
+			return err
+		}
+
+		p.Lock()
+		p.subscribedTracks[mt.ID] = dt
+		p.Unlock()
+
+		mt.AddDownTrack(dt)
+		subscriber.streamAllocator.AddTrack(dt)
+		added++
+	}
+
+	if added == 0 {
+		return nil
+	}
+
+	return subscriber.Negotiate()
+}
+
+// UnsubscribeFrom removes the tracks from the subscriber transport
+func (p *Participant) UnsubscribeFrom(tracks ...*MediaTrack) error {
+	p.subscriptionLock.Lock()
+	defer p.subscriptionLock.Unlock()
+
+	p.RLock()
+	subscriber := p.subscriber
+	p.RUnlock()
+
+	if subscriber == nil {
+		return nil
+	}
+
+	removed := 0
+	for _, mt := range tracks {
+		p.Lock()
+		dt, exists := p.subscribedTracks[mt.ID]
+		delete(p.subscribedTracks, mt.ID)
+		p.Unlock()
+		if !exists {
+			continue
+		}
+
+		mt.RemoveDownTrack(p.ID)
+		subscriber.streamAllocator.RemoveTrack(dt)
+		if err := dt.Detach(subscriber); err != nil {
+			log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Str("track", string(mt.ID)).Msg("remove subscribed track")
+		}
+		removed++
+	}
+
+	if removed == 0 {
+		return nil
+	}
+
+	// Nothing is received anymore, the transport is torn down instead of the renegotiation
+	if p.closeIdleSubscriber() {
+		return nil
+	}
+
+	return subscriber.Negotiate()
+}
+
+// closeIdleSubscriber closes the subscriber transport without subscribed tracks,
+// the next subscription creates a new one
+func (p *Participant) closeIdleSubscriber() bool {
+	p.Lock()
+	subscriber := p.subscriber
+	if subscriber == nil || len(p.subscribedTracks) > 0 {
+		p.Unlock()
+		return false
+	}
+	p.subscriber = nil
+
+	// The closed transport is not waited for to reconnect
+	delete(p.failedTransports, rpc.Receiver)
+	if len(p.failedTransports) == 0 && p.reconnectTimer != nil {
+		p.reconnectTimer.Stop()
+		p.reconnectTimer = nil
+	}
+	p.Unlock()
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("close idle subscriber transport")
+
+	// Close blocks while the transport is gathering candidates
+	go subscriber.Close()
+
+	return true
+}
+
+// SetVideoQuality switches the subscribed video tracks to the spatial layer of the quality
+// and the SVC tracks to the temporal layer
+func (p *Participant) SetVideoQuality(tracks []*MediaTrack, quality rpc.VideoQuality, temporal rpc.VideoQuality) {
+	layer := layerFromQuality(quality)
+	temporalLayer := layerFromQuality(temporal)
+
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, mt := range tracks {
+		if mt.Kind != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if dt, ok := p.subscribedTracks[mt.ID]; ok {
+			dt.SetTargetTemporalLayer(temporalLayer)
+			dt.SetMaxLayer(layer)
+		}
+	}
+
+	if p.subscriber != nil && p.subscriber.streamAllocator != nil {
+		p.subscriber.streamAllocator.Allocate()
+	}
+}
+
+func (p *Participant) getOrCreateSubscriber() (*PCTransport, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.subscriber != nil {
+		return p.subscriber, nil
+	}
+
+	subscriber, err := NewPCTransport(TransportParams{
+		EnabledCodecs: p.enabledCodecs,
+		Config:        p.rtcConf,
+		Target:        rpc.Receiver,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if err := p.sendICECandidate(candidate, rpc.Receiver); err != nil {
+			log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("error on send ICE candidate")
+		}
+	})
+	subscriber.pc.OnConnectionStateChange(p.handleSecondaryStateChange)
+	subscriber.OnOffer(func(offer webrtc.SessionDescription) error {
+		log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("send subscriber offer")
+
+		return p.sink.PublishClient(p.ID, rpc.NewSDPOfferRpc(&offer, rpc.Receiver))
+	})
+
+	p.subscriber = subscriber
+
+	return subscriber, nil
+}
+
 func (p *Participant) StartPublish() error {
 	return nil
 }
@@ -206,99 +788,379 @@ func (p *Participant) handlePrimaryStateChange(state webrtc.PeerConnectionState)
 
 	if state == webrtc.PeerConnectionStateConnected {
 		telemetry.ServiceOperationCounter.WithLabelValues("ice_connection", "success", "").Add(1)
+		p.handleTransportConnected(rpc.Publisher)
 	} else if state == webrtc.PeerConnectionStateFailed {
 		telemetry.ServiceOperationCounter.WithLabelValues("ice_connection", "error", "state_failed").Add(1)
-		p.closeSignalConnection()
-		p.Close()
+		p.handleTransportFailed(rpc.Publisher)
+
+		// Only the client can restart ICE of the publisher transport, it is the offerer
+		if err := p.sink.PublishClient(p.ID, rpc.NewICERestartRpc(rpc.Publisher)); err != nil {
+			log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("request ICE restart")
+		}
+	}
+}
+
+func (p *Participant) handleSecondaryStateChange(state webrtc.PeerConnectionState) {
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Str("state", state.String()).Msg("secondary connection state changed")
+
+	if state == webrtc.PeerConnectionStateConnected {
+		telemetry.ServiceOperationCounter.WithLabelValues("ice_connection_receiver", "success", "").Add(1)
+		p.handleTransportConnected(rpc.Receiver)
+	} else if state == webrtc.PeerConnectionStateFailed {
+		telemetry.ServiceOperationCounter.WithLabelValues("ice_connection_receiver", "error", "state_failed").Add(1)
+		p.handleTransportFailed(rpc.Receiver)
+		p.restartSubscriberICE()
+	}
+}
+
+// OnReconnectTimeout sets the handler called when the failed connection has not been restored in time
+func (p *Participant) OnReconnectTimeout(callback func(*Participant)) {
+	p.Lock()
+	p.onReconnectTimeout = callback
+	p.Unlock()
+}
+
+// handleTransportFailed starts the grace period, the room, the published tracks
+// and the transcoder are kept until it expires
+func (p *Participant) handleTransportFailed(target rpc.SignalingTarget) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.failedTransports[target] = struct{}{}
+	if p.reconnectTimer != nil {
+		return
+	}
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Dur("timeout", p.rtcConf.ReconnectTimeout).Msg("wait for reconnection")
+	p.reconnectTimer = time.AfterFunc(p.rtcConf.ReconnectTimeout, p.handleReconnectTimeout)
+}
+
+func (p *Participant) handleTransportConnected(target rpc.SignalingTarget) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, failed := p.failedTransports[target]; !failed {
+		return
+	}
+	delete(p.failedTransports, target)
+
+	if len(p.failedTransports) > 0 || p.reconnectTimer == nil {
+		return
+	}
+
+	p.reconnectTimer.Stop()
+	p.reconnectTimer = nil
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("reconnected")
+	telemetry.ServiceOperationCounter.WithLabelValues("reconnect", "success", "").Add(1)
+}
+
+func (p *Participant) handleReconnectTimeout() {
+	p.Lock()
+	if p.reconnectTimer == nil || p.closed {
+		p.Unlock()
+		return
 	}
+	p.reconnectTimer = nil
+	onReconnectTimeout := p.onReconnectTimeout
+	p.Unlock()
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("reconnection timed out")
+	telemetry.ServiceOperationCounter.WithLabelValues("reconnect", "error", "timeout").Add(1)
+
+	p.closeSignalConnection()
+
+	if onReconnectTimeout != nil {
+		onReconnectTimeout(p)
+		return
+	}
+	p.Close()
 }
 
-// func (p *Participant) handleSecondaryStateChange(state webrtc.PeerConnectionState) {
-// 	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Str("state", state.String()).Msg("secondary connection state changed")
+func (p *Participant) restartSubscriberICE() {
+	p.RLock()
+	subscriber := p.subscriber
+	p.RUnlock()
 
-// 	if state == webrtc.PeerConnectionStateConnected {
-// 		telemetry.ServiceOperationCounter.WithLabelValues("ice_connection_receiver", "success", "").Add(1)
-// 	} else if state == webrtc.PeerConnectionStateFailed {
-// 		telemetry.ServiceOperationCounter.WithLabelValues("ice_connection_receiver", "error", "state_failed").Add(1)
-// 		p.closeSignalConnection()
-// 	}
-// }
+	if subscriber == nil {
+		return
+	}
+
+	if err := subscriber.RestartICE(); err != nil {
+		log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("restart subscriber ICE")
+	}
+}
+
+// OnDataPacket sets the handler of the data packets the participant sends to the rooms
+func (p *Participant) OnDataPacket(callback func(*Participant, *DataPacket)) {
+	p.Lock()
+	p.onDataPacket = callback
+	p.Unlock()
+}
+
+// SendData writes the message into the reliable or the lossy data channel of the participant
+func (p *Participant) SendData(data []byte, lossy bool) error {
+	p.RLock()
+	dc := p.reliableDC
+	if lossy {
+		dc = p.lossyDC
+	}
+	p.RUnlock()
+
+	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return errDataChannelNotReady
+	}
+
+	return dc.Send(data)
+}
 
 func (p *Participant) onDataChannel(dc *webrtc.DataChannel) {
 	switch dc.Label() {
 	case ReliableDataChannel:
+		p.Lock()
 		p.reliableDC = dc
+		p.Unlock()
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			p.handleDataMessage(msg, false)
+		})
+	case LossyDataChannel:
+		if dc.Ordered() || dc.MaxRetransmits() == nil || *dc.MaxRetransmits() != 0 {
+			log.Error().Err(errLossyDataChannel).Str("service", "participant").Str("ID", string(p.ID)).Msg("reject datachannel")
+			if err := dc.Close(); err != nil {
+				log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("close datachannel")
+			}
+			return
+		}
+
+		p.Lock()
+		p.lossyDC = dc
+		p.Unlock()
+
 		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-			// Just ignore
+			p.handleDataMessage(msg, true)
 		})
 	default:
 		log.Error().Str("service", "participant").Str("ID", string(p.ID)).Str("label", dc.Label()).Msg("unsupported datachannel added")
 	}
 }
 
+func (p *Participant) handleDataMessage(msg webrtc.DataChannelMessage, lossy bool) {
+	limiter := p.dataLimiter
+	if lossy {
+		limiter = p.lossyDataLimiter
+	}
+
+	if !limiter.Allow() {
+		telemetry.ServiceOperationCounter.WithLabelValues("data_packet", "error", "rate_limited").Add(1)
+		log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Bool("lossy", lossy).Err(errDataPacketRateLimited).Msg("drop data packet")
+		return
+	}
+
+	packet, err := parseDataPacket(msg.Data, p.rtcConf.DataChannel.MaxMessageSize)
+	if err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("data_packet", "error", "invalid").Add(1)
+		log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Bool("lossy", lossy).Err(err).Msg("drop data packet")
+		return
+	}
+
+	packet.From = p.ID
+	packet.lossy = lossy
+	if packet.RoomID == "" {
+		packet.RoomID = p.ID
+	}
+
+	p.RLock()
+	onDataPacket := p.onDataPacket
+	p.RUnlock()
+
+	if onDataPacket != nil {
+		onDataPacket(p, packet)
+	}
+}
+
 // Метод вызывается для каждого трека
 func (p *Participant) onMediaTrack(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
-	payloadType := track.Codec().PayloadType
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Str("kind", track.Kind().String()).Uint8("codec type", uint8(track.Codec().PayloadType)).Msg("on media track")
+
+	// The transcoder SDP lists the payload types of the enabled codecs, the publisher may negotiate others
+	codec, ok := transcoderCodec(track.Codec())
+	if !ok {
+		log.Error().Str("service", "participant").Str("ID", string(p.ID)).Str("mime", track.Codec().MimeType).Msg("codec is not enabled")
+		return
+	}
 
-	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Uint8("codec type", uint8(payloadType)).Msg("on media track")
+	// The answer is set before the media arrives
+	p.startNegotiatedTranscoder()
 
-	// Send a PLI on an interval so that the publisher is pushing a keyframe every rtcpPLIInterval
-	go func() {
-		ticker := time.NewTicker(time.Second * 2)
-		for range ticker.C {
-			if rtcpErr := p.publisher.pc.WriteRTCP(
-				[]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}},
-			); rtcpErr != nil {
-				log.Error().Err(rtcpErr).Str("service", "participant").Str("ID", string(p.ID)).Msg("")
+	id := MediaTrackID(track.ID())
+
+	// Simulcast layers come as separate tracks with the same ID
+	p.Lock()
+	mt, exists := p.publishedTracks[id]
+	if !exists {
+		// The transcoder listens to the single codec of the kind, the track of another one is not transcoded
+		var transcoder TranscoderTransport
+		if transcoderCodec, ok := p.transcoderCodecs[track.Kind()]; ok && transcoderCodec.PayloadType == codec.PayloadType {
+			var err error
+			if transcoder, err = p.transcoderDialer.Dial(p.ID, p.allocatedPorts[track.Kind()]); err != nil {
+				p.Unlock()
+				log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("dial transcoder")
 				return
 			}
+		} else {
+			log.Warn().Str("service", "participant").Str("ID", string(p.ID)).Str("mime", codec.MimeType).Msg("codec is not received by the transcoder")
 		}
-	}()
+		mt = NewMediaTrack(MediaTrackParams{
+			TrackID:               id,
+			StreamID:              track.StreamID(),
+			Kind:                  track.Kind(),
+			Codec:                 track.Codec(),
+			Transcoder:            transcoder,
+			TranscoderPayloadType: codec.PayloadType,
+		})
+		mt.OnKeyframeRequest(p.sendKeyframeRequest)
+		p.publishedTracks[id] = mt
+	}
+	listeners := p.publishedTrackListeners()
+	recording := p.recording
+	p.Unlock()
 
-	id := MediaTrackID(track.ID())
-	mt, err := NewMediaTrack(id, payloadType, p.allocatedPorts[payloadType])
-	if err != nil {
-		log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("")
-		return
+	if !exists {
+		p.notifyTrackPublished(mt, listeners, recording)
 	}
 
+	mt.ForwardRTP(track, rtpReceiver)
+}
+
+// PublishIngestTrack publishes the track of the encoder of the ingest codec of the kind,
+// its frames are packetized into RTP and forwarded as the packets of the WebRTC track
+func (p *Participant) PublishIngestTrack(kind webrtc.RTPCodecType) (*IngestTrack, error) {
+	if p.ingest == nil || p.ingest.codec(kind) == nil {
+		return nil, errIngestCodec
+	}
+	codec := *p.ingest.codec(kind)
+
+	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Str("kind", kind.String()).Str("mime", codec.MimeType).Msg("publish ingest track")
+
 	p.Lock()
-	p.publishedTracks[id] = mt
+	if p.closed {
+		p.Unlock()
+		return nil, errParticipantClosed
+	}
+	transcoder, err := p.transcoderDialer.Dial(p.ID, p.allocatedPorts[kind])
+	if err != nil {
+		p.Unlock()
+		return nil, err
+	}
+	mt := NewMediaTrack(MediaTrackParams{
+		TrackID:               MediaTrackID(uuid.New().String()),
+		StreamID:              string(p.ID),
+		Kind:                  kind,
+		Codec:                 codec,
+		Transcoder:            transcoder,
+		TranscoderPayloadType: codec.PayloadType,
+	})
+	p.publishedTracks[mt.ID] = mt
+	t := newIngestTrack(mt)
+	p.ingestTracks = append(p.ingestTracks, t)
+	listeners := p.publishedTrackListeners()
+	recording := p.recording
 	p.Unlock()
 
-	mt.ForwardRTP(track, rtpReceiver)
+	p.notifyTrackPublished(mt, listeners, recording)
+
+	return t, nil
+}
+
+// publishedTrackListeners returns the handlers of the rooms, the lock of the participant is held
+func (p *Participant) publishedTrackListeners() []func(*Participant, *MediaTrack) {
+	listeners := make([]func(*Participant, *MediaTrack), 0, len(p.trackListeners))
+	for _, listener := range p.trackListeners {
+		listeners = append(listeners, listener)
+	}
+
+	return listeners
+}
+
+// notifyTrackPublished attaches the newly published track to the rooms and to the recording
+func (p *Participant) notifyTrackPublished(mt *MediaTrack, listeners []func(*Participant, *MediaTrack), recording *RecordingParams) {
+	for _, listener := range listeners {
+		listener(p, mt)
+	}
+	if recording != nil {
+		p.recordTrack(recording, mt)
+	}
+}
+
+// sendKeyframeRequest asks the publisher for a keyframe of the stream
+func (p *Participant) sendKeyframeRequest(ssrc webrtc.SSRC) {
+	if rtcpErr := p.publisher.pc.WriteRTCP(
+		[]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}},
+	); rtcpErr != nil {
+		log.Error().Err(rtcpErr).Str("service", "participant").Str("ID", string(p.ID)).Msg("send keyframe request")
+	}
 }
 
 // closes signal connection to notify client to resume/reconnect
 func (p *Participant) closeSignalConnection() {
-	// Need to send RPC to client
+	if err := p.sink.PublishClient(p.ID, rpc.NewCloseSessionRpc()); err != nil {
+		log.Error().Err(err).Str("service", "participant").Str("ID", string(p.ID)).Msg("close signal connection")
+	}
 }
 
 func (p *Participant) Close() {
 	log.Debug().Str("service", "participant").Str("ID", string(p.ID)).Msg("close participant")
 
+	// The files are finished before the tracks are closed, the participant may be not recorded
+	_ = p.StopRecording()
+
 	p.Lock()
 	defer p.Unlock()
 
+	if p.closed {
+		return
+	}
+	p.closed = true
+
+	if p.reconnectTimer != nil {
+		p.reconnectTimer.Stop()
+		p.reconnectTimer = nil
+	}
+
 	for _, t := range p.publishedTracks {
 		t.Close()
 		delete(p.publishedTracks, t.ID)
 	}
 
-	for _, port := range p.allocatedPorts {
-		p.portsAllocator.Deallocate(port)
+	for _, t := range p.ingestTracks {
+		t.Close()
+	}
+	p.ingestTracks = nil
+
+	for id, dt := range p.subscribedTracks {
+		dt.mediaTrack.RemoveDownTrack(p.ID)
+		delete(p.subscribedTracks, id)
 	}
 
+	p.releasePorts()
+
 	p.publishedTracks = nil
-	p.allocatedPorts = nil
 	// Close peer connections without blocking participant close. If peer connections are gathering candidates
 	// Close will block.
-	if p.publisher != nil {
-		go func() {
-			p.publisher.Close()
-			// p.subscriber.Close()
-		}()
-	}
+	publisher, subscriber := p.publisher, p.subscriber
+	go func() {
+		if publisher != nil {
+			publisher.Close()
+		}
+		if subscriber != nil {
+			subscriber.Close()
+		}
+	}()
 
 	message := &transcode.Message{
 		UserID: p.ID,
@@ -309,7 +1171,7 @@ func (p *Participant) Close() {
 		log.Error().Err(err).Msg("")
 	}
 
-	if err := p.nc.Publish(transcode.TranscoderStopSubj, payload);  err != nil {
+	if err := p.nc.Publish(transcode.TranscoderStopSubj, payload); err != nil {
 		log.Error().Err(err).Msg("")
 	}
 }
@@ -2,42 +2,260 @@ package rtc
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/telemetry"
+)
+
+// the lease younger than this is not reclaimed, the participant joins the room after it allocates the ports
+const portLeaseGracePeriod = time.Minute
+
+var (
+	errNoFreePorts    = errors.New("no free ports")
+	errNoPortPairs    = errors.New("port range fits no RTP/RTCP pair")
+	errPortPairLocked = errors.New("port pair is reserved by another process")
 )
 
+type PortsAllocatorParams struct {
+	// the pairs are allocated in [RangeStart, RangeEnd)
+	RangeStart int
+	RangeEnd   int
+	// directory of the lock files reserving the pairs among the SFU instances of the host,
+	// the pairs are reserved within the process only if empty
+	LockDir string
+}
+
+type portLease struct {
+	owner       *Participant
+	allocatedAt time.Time
+	lockFile    *os.File
+}
+
+// PortsAllocator allocates the RTP/RTCP port pairs of the transcoder inputs, the even RTP port
+// and the next one for RTCP as ffmpeg expects. The lock file of the pair reserves it host-wide,
+// the kernel releases the lock if the SFU instance dies.
 type PortsAllocator struct {
-	sync.Mutex
-	udpPorts map[int]bool
+	lock   sync.Mutex
+	params PortsAllocatorParams
+	leases map[int]*portLease
+	// the allocation goes on after the last allocated pair, ffmpeg may still listen on the released one
+	next int
+	// the pairs locked by the other instances of the host when the lock files were probed last time
+	reserved int
 }
 
-func NewPortsAllocator(rangeStart, rangeEnd int) *PortsAllocator {
+func NewPortsAllocator(params PortsAllocatorParams) (*PortsAllocator, error) {
+	// The RTP port is even
+	if params.RangeStart%2 != 0 {
+		params.RangeStart++
+	}
+	if params.RangeEnd-params.RangeStart < 2 {
+		return nil, errNoPortPairs
+	}
+
+	if params.LockDir != "" {
+		if err := os.MkdirAll(params.LockDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
 	p := &PortsAllocator{
-		udpPorts: make(map[int]bool),
+		params: params,
+		leases: make(map[int]*portLease),
+		next:   params.RangeStart,
+	}
+	p.probeReserved()
+	p.reportUsage()
+
+	return p, nil
+}
+
+// Allocate reserves the pair for the participant and returns its RTP port, the RTCP port is the next one
+func (p *PortsAllocator) Allocate(owner *Participant) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i := 0; i < p.pairs(); i++ {
+		port := p.next
+		p.next += 2
+		if p.next+2 > p.params.RangeEnd {
+			p.next = p.params.RangeStart
+		}
+
+		if _, ok := p.leases[port]; ok {
+			continue
+		}
+
+		lockFile, err := p.reserve(port)
+		if err != nil {
+			if !errors.Is(err, errPortPairLocked) {
+				log.Error().Err(err).Str("service", "PortsAllocator").Int("port", port).Msg("reserve port pair")
+			}
+			continue
+		}
+
+		p.leases[port] = &portLease{
+			owner:       owner,
+			allocatedAt: time.Now(),
+			lockFile:    lockFile,
+		}
+		p.reportUsage()
+
+		return port, nil
 	}
 
-	for i := rangeStart; i < rangeEnd; i++ {
-		p.udpPorts[i] = false
+	log.Warn().Str("service", "PortsAllocator").Int("rangeStart", p.params.RangeStart).Int("rangeEnd", p.params.RangeEnd).Msg("ports are exhausted")
+	telemetry.ServiceOperationCounter.WithLabelValues("ports", "error", "exhausted").Add(1)
+
+	return 0, errNoFreePorts
+}
+
+// Deallocate releases the pair of the RTP port. The reclaimed pair may be allocated to another participant already,
+// it is released by its current owner only.
+func (p *PortsAllocator) Deallocate(owner *Participant, port int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	lease, ok := p.leases[port]
+	if !ok || lease.owner != owner {
+		return
 	}
 
-	return p
+	p.release(port, lease)
 }
 
-func (p *PortsAllocator) Allocate() (int, error) {
-	p.Lock()
-	defer p.Unlock()
+// Reclaim releases the pairs of the participants which are not active anymore but have not been closed,
+// e.g. dropped from the rooms on the failed join. It returns the number of the released pairs.
+func (p *PortsAllocator) Reclaim(isActive func(owner *Participant) bool) int {
+	p.lock.Lock()
+	leases := make(map[int]*portLease, len(p.leases))
+	for port, lease := range p.leases {
+		if time.Since(lease.allocatedAt) >= portLeaseGracePeriod {
+			leases[port] = lease
+		}
+	}
+	p.lock.Unlock()
 
-	for port, allocated := range p.udpPorts {
-		if !allocated {
-			p.udpPorts[port] = true
-			return port, nil
+	// The owners are checked without the lock, the sessions of the participants may allocate meanwhile
+	for port, lease := range leases {
+		if isActive(lease.owner) {
+			delete(leases, port)
 		}
 	}
 
-	return 0, errors.New("no free ports")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	reclaimed := 0
+	for port, lease := range leases {
+		if p.leases[port] != lease {
+			continue
+		}
+
+		log.Warn().Str("service", "PortsAllocator").Int("port", port).Str("owner", string(lease.owner.ID)).Time("allocatedAt", lease.allocatedAt).Msg("reclaim leaked port pair")
+		p.release(port, lease)
+		reclaimed++
+	}
+
+	p.probeReserved()
+	p.reportUsage()
+
+	return reclaimed
+}
+
+func (p *PortsAllocator) pairs() int {
+	return (p.params.RangeEnd - p.params.RangeStart) / 2
+}
+
+// reserve locks the file of the pair, the lock is not blocking. The file is nil without the lock directory.
+func (p *PortsAllocator) reserve(port int) (*os.File, error) {
+	if p.params.LockDir == "" {
+		return nil, nil
+	}
+
+	f, err := os.OpenFile(p.lockPath(port), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errPortPairLocked
+		}
+		return nil, err
+	}
+
+	// The operator finds the holder of the pair by its PID
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return f, nil
 }
 
-func (p *PortsAllocator) Deallocate(port int) {
-	p.Lock()
-	p.udpPorts[port] = false
-	p.Unlock()
+func (p *PortsAllocator) lockPath(port int) string {
+	return filepath.Join(p.params.LockDir, fmt.Sprintf("%d.lock", port))
+}
+
+// release frees the pair, the lock must be held. The lock file is kept, removing it would race with the other instances.
+func (p *PortsAllocator) release(port int, lease *portLease) {
+	delete(p.leases, port)
+
+	if lease.lockFile != nil {
+		if err := syscall.Flock(int(lease.lockFile.Fd()), syscall.LOCK_UN); err != nil {
+			log.Error().Err(err).Str("service", "PortsAllocator").Int("port", port).Msg("unlock port pair")
+		}
+		lease.lockFile.Close()
+	}
+
+	p.reportUsage()
+}
+
+// probeReserved counts the pairs locked by the other instances of the host, the lock must be held.
+// The lock file is locked for a moment, the instance allocating the pair meanwhile skips it.
+func (p *PortsAllocator) probeReserved() {
+	p.reserved = 0
+	if p.params.LockDir == "" {
+		return
+	}
+
+	for port := p.params.RangeStart; port+2 <= p.params.RangeEnd; port += 2 {
+		if _, ok := p.leases[port]; ok {
+			continue
+		}
+
+		f, err := os.OpenFile(p.lockPath(port), os.O_RDWR, 0644)
+		if err != nil {
+			// The pair has never been reserved on the host
+			continue
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			p.reserved++
+		} else if err == nil {
+			_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		}
+		f.Close()
+	}
+}
+
+// reportUsage updates the gauges of the ports, the lock must be held. The ports reserved by the other instances
+// are counted when the lock files are probed, on the start and on every reclaim.
+func (p *PortsAllocator) reportUsage() {
+	used := 2 * len(p.leases)
+	reserved := 2 * p.reserved
+	free := 2*p.pairs() - used - reserved
+	if free < 0 {
+		free = 0
+	}
+	telemetry.TranscoderPorts(used, reserved, free)
 }
@@ -0,0 +1,169 @@
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+func TestNewPortsAllocator(t *testing.T) {
+	tests := []struct {
+		name       string
+		rangeStart int
+		rangeEnd   int
+		ports      []int
+		err        error
+	}{
+		{"even start", 4000, 4006, []int{4000, 4002, 4004}, nil},
+		{"odd start", 4001, 4008, []int{4002, 4004, 4006}, nil},
+		{"odd end", 4000, 4005, []int{4000, 4002}, nil},
+		{"single pair", 4001, 4004, []int{4002}, nil},
+		{"no pair", 4001, 4003, nil, errNoPortPairs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocator, err := NewPortsAllocator(PortsAllocatorParams{RangeStart: tt.rangeStart, RangeEnd: tt.rangeEnd})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			var ports []int
+			for {
+				port, err := allocator.Allocate(&Participant{})
+				if err != nil {
+					assert.ErrorIs(t, err, errNoFreePorts)
+					break
+				}
+				ports = append(ports, port)
+			}
+			assert.Equal(t, tt.ports, ports)
+		})
+	}
+}
+
+func TestPortsAllocatorDeallocate(t *testing.T) {
+	allocator, err := NewPortsAllocator(PortsAllocatorParams{RangeStart: 4000, RangeEnd: 4004, LockDir: t.TempDir()})
+	require.NoError(t, err)
+
+	first, second := &Participant{}, &Participant{}
+	port, err := allocator.Allocate(first)
+	require.NoError(t, err)
+	_, err = allocator.Allocate(second)
+	require.NoError(t, err)
+	_, err = allocator.Allocate(&Participant{})
+	require.ErrorIs(t, err, errNoFreePorts)
+
+	// The pair is released by its owner only
+	allocator.Deallocate(second, port)
+	_, err = allocator.Allocate(second)
+	require.ErrorIs(t, err, errNoFreePorts)
+
+	allocator.Deallocate(first, port)
+	reallocated, err := allocator.Allocate(second)
+	require.NoError(t, err)
+	assert.Equal(t, port, reallocated)
+}
+
+func TestPortsAllocatorSkipsPairsOfOtherInstance(t *testing.T) {
+	params := PortsAllocatorParams{RangeStart: 4000, RangeEnd: 4006, LockDir: t.TempDir()}
+
+	instance, err := NewPortsAllocator(params)
+	require.NoError(t, err)
+	other, err := NewPortsAllocator(params)
+	require.NoError(t, err)
+
+	owner := &Participant{}
+	port, err := instance.Allocate(owner)
+	require.NoError(t, err)
+	assert.Equal(t, 4000, port)
+
+	port, err = other.Allocate(&Participant{})
+	require.NoError(t, err)
+	assert.Equal(t, 4002, port)
+	port, err = other.Allocate(&Participant{})
+	require.NoError(t, err)
+	assert.Equal(t, 4004, port)
+	_, err = other.Allocate(&Participant{})
+	require.ErrorIs(t, err, errNoFreePorts)
+
+	// The lock of the released pair is taken by the other instance
+	instance.Deallocate(owner, 4000)
+	port, err = other.Allocate(&Participant{})
+	require.NoError(t, err)
+	assert.Equal(t, 4000, port)
+}
+
+func TestPortsAllocatorReclaim(t *testing.T) {
+	allocator, err := NewPortsAllocator(PortsAllocatorParams{RangeStart: 4000, RangeEnd: 4008, LockDir: t.TempDir()})
+	require.NoError(t, err)
+
+	active := &Participant{ID: core.UserSessionID("active")}
+	leaked := &Participant{ID: core.UserSessionID("leaked")}
+	joining := &Participant{ID: core.UserSessionID("joining")}
+
+	activePort, err := allocator.Allocate(active)
+	require.NoError(t, err)
+	leakedPort, err := allocator.Allocate(leaked)
+	require.NoError(t, err)
+	joiningPort, err := allocator.Allocate(joining)
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-portLeaseGracePeriod)
+	allocator.leases[activePort].allocatedAt = expired
+	allocator.leases[leakedPort].allocatedAt = expired
+
+	isActive := func(owner *Participant) bool { return owner == active }
+	// The joining participant is not in the room yet but its lease is within the grace period
+	assert.Equal(t, 1, allocator.Reclaim(isActive))
+	assert.Contains(t, allocator.leases, activePort)
+	assert.NotContains(t, allocator.leases, leakedPort)
+	assert.Contains(t, allocator.leases, joiningPort)
+
+	assert.Equal(t, 0, allocator.Reclaim(isActive))
+
+	// The reclaimed pair is allocated again
+	port, err := allocator.Allocate(&Participant{})
+	require.NoError(t, err)
+	assert.Equal(t, 4006, port)
+	port, err = allocator.Allocate(&Participant{})
+	require.NoError(t, err)
+	assert.Equal(t, leakedPort, port)
+}
+
+func TestPortsAllocatorCountsPairsOfOtherInstances(t *testing.T) {
+	params := PortsAllocatorParams{RangeStart: 4000, RangeEnd: 4008, LockDir: t.TempDir()}
+
+	instance, err := NewPortsAllocator(params)
+	require.NoError(t, err)
+	other, err := NewPortsAllocator(params)
+	require.NoError(t, err)
+
+	_, err = instance.Allocate(&Participant{})
+	require.NoError(t, err)
+	owner := &Participant{}
+	port, err := other.Allocate(owner)
+	require.NoError(t, err)
+	_, err = other.Allocate(&Participant{})
+	require.NoError(t, err)
+
+	isActive := func(*Participant) bool { return true }
+	instance.Reclaim(isActive)
+	assert.Equal(t, 2, instance.reserved)
+
+	// The probing doesn't keep the locks, the other instance allocates the released pair again
+	other.Deallocate(owner, port)
+	instance.Reclaim(isActive)
+	assert.Equal(t, 1, instance.reserved)
+
+	_, err = other.Allocate(&Participant{})
+	require.NoError(t, err)
+	instance.Reclaim(isActive)
+	assert.Equal(t, 2, instance.reserved)
+}
@@ -0,0 +1,285 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// packets the VP9 frame may be late for
+	recorderMaxLate = 128
+	// timebase of the frames of the VP9 recording, the clock rate of the video
+	ivfTimebase   = 90000
+	ivfHeaderSize = 32
+)
+
+var (
+	errRecordingCodec     = errors.New("codec can't be recorded")
+	errAlreadyRecording   = errors.New("participant is recorded already")
+	errNotRecording       = errors.New("participant is not recorded")
+	unsafeFilenameSymbols = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// mediaWriter writes the RTP packets into the media file, see pion's media.Writer
+type mediaWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// TrackRecorder writes the published track into the file: VP8, VP9 and AV1 into IVF,
+// H.264 into the Annex B stream and Opus into Ogg.
+// The video is recorded from the layer forwarded to the transcoder, the base spatial layer of the SVC stream.
+type TrackRecorder struct {
+	TrackID   MediaTrackID
+	Kind      webrtc.RTPCodecType
+	MimeType  string
+	Filename  string
+	StartedAt time.Time
+
+	lock       sync.Mutex
+	writer     mediaWriter
+	closed     bool
+	finishedAt time.Time
+	err        error
+	// the dropped spatial layers of the SVC stream don't break the sequence of the writer
+	droppedPackets uint16
+}
+
+func newTrackRecorder(track *MediaTrack, dir string) (*TrackRecorder, error) {
+	ext, err := recordingExtension(track.Codec.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	startedAt := time.Now()
+	trackName := unsafeFilenameSymbols.ReplaceAllString(string(track.ID), "_")
+	filename := filepath.Join(dir, fmt.Sprintf("%d_%s.%s", startedAt.UnixMilli(), trackName, ext))
+
+	writer, err := newMediaWriter(track.Codec, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrackRecorder{
+		TrackID:   track.ID,
+		Kind:      track.Kind,
+		MimeType:  track.Codec.MimeType,
+		Filename:  filename,
+		StartedAt: startedAt,
+		writer:    writer,
+	}, nil
+}
+
+func recordingExtension(mimeType string) (string, error) {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeVP9), strings.ToLower(webrtc.MimeTypeAV1):
+		return "ivf", nil
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return "h264", nil
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return "ogg", nil
+	default:
+		return "", errRecordingCodec
+	}
+}
+
+func newMediaWriter(codec webrtc.RTPCodecParameters, filename string) (mediaWriter, error) {
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeAV1):
+		return ivfwriter.New(filename, ivfwriter.WithCodec(codec.MimeType))
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return newVP9Writer(filename, codec)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return h264writer.New(filename)
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		channels := codec.Channels
+		if channels == 0 {
+			channels = 2
+		}
+		return oggwriter.New(filename, codec.ClockRate, channels)
+	default:
+		return nil, errRecordingCodec
+	}
+}
+
+// WriteRTP writes the packet of the recorded layer, the first error stops the recording.
+// Only the base spatial layer of the SVC stream is written.
+func (r *TrackRecorder) WriteRTP(packet *rtp.Packet, svc *svcLayer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed || r.err != nil {
+		return
+	}
+
+	if svc != nil && svc.spatial > LowLayer {
+		r.droppedPackets++
+		return
+	}
+
+	// The writer may keep the packet until the frame is complete, the buffer of the packet is reused
+	recorded := *packet
+	recorded.SequenceNumber = packet.SequenceNumber - r.droppedPackets
+	recorded.Payload = append([]byte(nil), packet.Payload...)
+
+	if err := r.writer.WriteRTP(&recorded); err != nil {
+		log.Error().Err(err).Str("service", "recorder").Str("ID", string(r.TrackID)).Str("file", r.Filename).Msg("write")
+		r.err = err
+	}
+}
+
+// Close finishes the file, it returns the error which stopped the recording
+func (r *TrackRecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return r.err
+	}
+	r.closed = true
+	r.finishedAt = time.Now()
+
+	if err := r.writer.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+
+	return r.err
+}
+
+// FinishedAt is zero until the recorder is closed
+func (r *TrackRecorder) FinishedAt() time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.finishedAt
+}
+
+// Size returns the size of the file written so far
+func (r *TrackRecorder) Size() int64 {
+	info, err := os.Stat(r.Filename)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// vp9Writer writes the VP9 frames into IVF, the IVF writer of pion supports VP8 and AV1 only
+type vp9Writer struct {
+	file    *os.File
+	codec   webrtc.RTPCodecParameters
+	builder *samplebuilder.SampleBuilder
+
+	seenKeyframe bool
+	frames       uint32
+	lastTS       uint32
+	// 64 bits timestamp of the last frame, the RTP timestamp wraps around
+	timestamp uint64
+}
+
+func newVP9Writer(filename string, codec webrtc.RTPCodecParameters) (*vp9Writer, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &vp9Writer{
+		file:    file,
+		codec:   codec,
+		builder: samplebuilder.New(recorderMaxLate, &codecs.VP9Packet{}, codec.ClockRate),
+	}
+
+	if err := w.writeHeader(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *vp9Writer) writeHeader() error {
+	header := make([]byte, ivfHeaderSize)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)             // version
+	binary.LittleEndian.PutUint16(header[6:], ivfHeaderSize) // header size
+	copy(header[8:], "VP90")
+	// the width and the height are left zero, the decoder reads them from the keyframe
+	binary.LittleEndian.PutUint32(header[16:], ivfTimebase) // timebase denominator
+	binary.LittleEndian.PutUint32(header[20:], 1)           // timebase numerator
+	binary.LittleEndian.PutUint32(header[24:], w.frames)    // frame count, updated on close
+
+	_, err := w.file.WriteAt(header, 0)
+
+	return err
+}
+
+func (w *vp9Writer) WriteRTP(packet *rtp.Packet) error {
+	// The decoder needs the keyframe first
+	if !w.seenKeyframe {
+		if !isKeyframe(w.codec, packet) {
+			return nil
+		}
+		w.seenKeyframe = true
+		w.lastTS = packet.Timestamp
+	}
+
+	w.builder.Push(packet)
+
+	for sample := w.builder.Pop(); sample != nil; sample = w.builder.Pop() {
+		if err := w.writeFrame(sample.Data, sample.PacketTimestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *vp9Writer) writeFrame(frame []byte, ts uint32) error {
+	if delta := int32(ts - w.lastTS); delta > 0 {
+		w.timestamp += uint64(delta)
+	}
+	w.lastTS = ts
+
+	frameHeader := make([]byte, 12)
+	binary.LittleEndian.PutUint32(frameHeader[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(frameHeader[4:], w.timestamp*ivfTimebase/uint64(w.codec.ClockRate))
+
+	if _, err := w.file.Write(frameHeader); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		return err
+	}
+	w.frames++
+
+	return nil
+}
+
+func (w *vp9Writer) Close() error {
+	if err := w.writeHeader(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
@@ -1,8 +1,14 @@
 package rtc
 
 import (
+	"encoding/json"
 	"errors"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
 
 	"github.com/isqad/livelook-sfu/internal/config"
 	"github.com/isqad/livelook-sfu/internal/core"
@@ -11,7 +17,13 @@ import (
 )
 
 var (
-	errNoParticipant = errors.New("participant is not initialized")
+	errNoParticipant    = errors.New("participant is not initialized")
+	errSelfSubscribe    = errors.New("participant can't subscribe to own stream")
+	errNotRoomOwner     = errors.New("only the owner of the room can manage its publishers")
+	errSelfInvite       = errors.New("owner of the room can't be invited")
+	errAlreadyPublisher = errors.New("user publishes into the room already")
+	errNotInvited       = errors.New("user is not invited into the room")
+	errRemoveOwner      = errors.New("owner can't be removed from the room")
 )
 
 type Room struct {
@@ -20,6 +32,14 @@ type Room struct {
 	rtcCfg       config.WebRTCConfig
 	lock         sync.RWMutex
 	participants map[core.UserSessionID]*Participant
+	subscribers  map[core.UserSessionID]*Participant
+	// viewers without the signaling, see WHEPViewer
+	whepViewers map[core.UserSessionID]*WHEPViewer
+	// users invited to publish into the room
+	invites     map[core.UserSessionID]struct{}
+	audioLevels *AudioLevelObserver
+	// who may send into the lossy data channel of the room
+	lossyDataPolicy rpc.LossyDataPolicy
 
 	rpcSink eventbus.Publisher
 }
@@ -30,21 +50,599 @@ func NewRoom(
 	rtcConfig config.WebRTCConfig,
 	rpcSink eventbus.Publisher,
 ) *Room {
-	return &Room{
+	r := &Room{
 		ID:           userID,
 		cfg:          peerConfig,
 		rtcCfg:       rtcConfig,
 		participants: make(map[core.UserSessionID]*Participant),
+		subscribers:  make(map[core.UserSessionID]*Participant),
+		whepViewers:  make(map[core.UserSessionID]*WHEPViewer),
+		invites:      make(map[core.UserSessionID]struct{}),
+		audioLevels:  NewAudioLevelObserver(),
 		rpcSink:      rpcSink,
+
+		lossyDataPolicy: rpc.LossyDataPublishers,
 	}
+
+	r.audioLevels.OnSpeakersChanged(r.sendActiveSpeakers)
+	r.audioLevels.Start()
+
+	return r
 }
 
 func (r *Room) Join(participant *Participant) {
+	participant.AddTrackListener(r.ID, r.onTrackPublished, r.onTrackMuted)
+
 	r.lock.Lock()
 	r.participants[participant.ID] = participant
 	r.lock.Unlock()
 }
 
+func (r *Room) Participant(userID core.UserSessionID) *Participant {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.participants[userID]
+}
+
+// Stats returns the statistics of every participant publishing into the room
+func (r *Room) Stats() []ParticipantStats {
+	r.lock.RLock()
+	participants := make([]*Participant, 0, len(r.participants))
+	for _, p := range r.participants {
+		participants = append(participants, p)
+	}
+	r.lock.RUnlock()
+
+	stats := make([]ParticipantStats, 0, len(participants))
+	for _, p := range participants {
+		stats = append(stats, p.Stats())
+	}
+
+	return stats
+}
+
+// Invite allows the user to publish into the room, only the owner of the room can invite
+func (r *Room) Invite(inviterID core.UserSessionID, userID core.UserSessionID) error {
+	if inviterID != r.ID {
+		return errNotRoomOwner
+	}
+	if userID == r.ID {
+		return errSelfInvite
+	}
+
+	r.lock.Lock()
+	if _, ok := r.participants[userID]; ok {
+		r.lock.Unlock()
+		return errAlreadyPublisher
+	}
+	r.invites[userID] = struct{}{}
+	r.lock.Unlock()
+
+	return r.rpcSink.PublishClient(userID, rpc.NewPublisherInvitedRpc(r.ID))
+}
+
+// AddPublisher joins the invited participant as a co-publisher.
+// The co-publisher receives the tracks of the other publishers and they receive its tracks.
+func (r *Room) AddPublisher(publisher *Participant) error {
+	r.lock.Lock()
+	if _, ok := r.invites[publisher.ID]; !ok {
+		r.lock.Unlock()
+		return errNotInvited
+	}
+	delete(r.invites, publisher.ID)
+	delete(r.subscribers, publisher.ID)
+	others := r.publishersExcept(publisher.ID)
+	r.participants[publisher.ID] = publisher
+	receivers := r.receiversExcept(publisher.ID)
+	r.lock.Unlock()
+
+	publisher.AddTrackListener(r.ID, r.onTrackPublished, r.onTrackMuted)
+
+	if err := publisher.SubscribeTo(publishedTracks(others)...); err != nil {
+		log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("publisher", string(publisher.ID)).Msg("subscribe co-publisher")
+	}
+
+	tracks := publisher.PublishedTracks()
+	for _, track := range tracks {
+		r.listenAudioLevels(publisher.ID, track)
+	}
+	for _, receiver := range receivers {
+		if err := receiver.SubscribeTo(tracks...); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("subscriber", string(receiver.ID)).Msg("subscribe to co-publisher")
+		}
+	}
+
+	r.sendRoomTracks()
+
+	return nil
+}
+
+// RemovePublisher removes the co-publisher from the room. The owner removes anyone, a co-publisher removes itself.
+func (r *Room) RemovePublisher(requesterID core.UserSessionID, publisherID core.UserSessionID) error {
+	if requesterID != r.ID && requesterID != publisherID {
+		return errNotRoomOwner
+	}
+	if publisherID == r.ID {
+		return errRemoveOwner
+	}
+
+	r.lock.Lock()
+	publisher := r.participants[publisherID]
+	if publisher == nil {
+		delete(r.invites, publisherID)
+		r.lock.Unlock()
+		return errNoParticipant
+	}
+	delete(r.participants, publisherID)
+	others := r.publishersExcept(publisherID)
+	receivers := r.receiversExcept(publisherID)
+	r.lock.Unlock()
+
+	publisher.RemoveTrackListener(r.ID)
+	tracks := publisher.PublishedTracks()
+	for _, track := range tracks {
+		track.RemoveAudioLevelListener(r.ID)
+	}
+	r.audioLevels.Remove(publisherID)
+
+	if !publisher.IsClosed() {
+		if err := publisher.UnsubscribeFrom(publishedTracks(others)...); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("publisher", string(publisherID)).Msg("unsubscribe co-publisher")
+		}
+	}
+
+	for _, receiver := range receivers {
+		if err := receiver.UnsubscribeFrom(tracks...); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("subscriber", string(receiver.ID)).Msg("unsubscribe from co-publisher")
+		}
+	}
+
+	r.sendRoomTracks()
+
+	return nil
+}
+
+// IsPublisher is true when the user publishes into the room
+func (r *Room) IsPublisher(userID core.UserSessionID) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	_, ok := r.participants[userID]
+
+	return ok
+}
+
+// PublishersCount returns the number of the publishers including the owner
+func (r *Room) PublishersCount() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return len(r.participants)
+}
+
+// Subscribe attaches the subscriber to all tracks published in the room
+func (r *Room) Subscribe(subscriber *Participant) error {
+	if subscriber.ID == r.ID {
+		return errSelfSubscribe
+	}
+
+	r.lock.Lock()
+	if r.participants[r.ID] == nil {
+		r.lock.Unlock()
+		return errNoParticipant
+	}
+	// Co-publishers receive the tracks of the room already
+	if _, ok := r.participants[subscriber.ID]; ok {
+		r.lock.Unlock()
+		return nil
+	}
+	r.subscribers[subscriber.ID] = subscriber
+	publishers := r.publishersExcept(subscriber.ID)
+	r.lock.Unlock()
+
+	if err := subscriber.SubscribeTo(publishedTracks(publishers)...); err != nil {
+		return err
+	}
+
+	return r.rpcSink.PublishClient(subscriber.ID, rpc.NewRoomTracksRpc(r.ID, r.roomTracks()))
+}
+
+// Unsubscribe detaches the subscriber from all tracks published in the room
+func (r *Room) Unsubscribe(subscriberID core.UserSessionID) error {
+	r.lock.Lock()
+	subscriber := r.subscribers[subscriberID]
+	delete(r.subscribers, subscriberID)
+	publishers := r.publishersExcept(subscriberID)
+	r.lock.Unlock()
+
+	if subscriber == nil {
+		return nil
+	}
+
+	return subscriber.UnsubscribeFrom(publishedTracks(publishers)...)
+}
+
+// SetVideoQuality chooses the simulcast or SVC layers the subscriber receives
+func (r *Room) SetVideoQuality(subscriberID core.UserSessionID, quality rpc.VideoQuality, temporal rpc.VideoQuality) error {
+	r.lock.RLock()
+	subscriber := r.subscribers[subscriberID]
+	if subscriber == nil {
+		subscriber = r.participants[subscriberID]
+	}
+	publishers := r.publishersExcept(subscriberID)
+	r.lock.RUnlock()
+
+	if subscriber == nil {
+		return errNoParticipant
+	}
+
+	subscriber.SetVideoQuality(publishedTracks(publishers), quality, temporal)
+
+	return nil
+}
+
+// AddWHEPViewer answers the offer of the viewer with the tracks published into the room
+func (r *Room) AddWHEPViewer(viewer *WHEPViewer, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	r.lock.Lock()
+	if r.participants[r.ID] == nil {
+		r.lock.Unlock()
+		return nil, errNoParticipant
+	}
+	r.whepViewers[viewer.ID] = viewer
+	publishers := r.publishersExcept("")
+	r.lock.Unlock()
+
+	viewer.OnClose(func(v *WHEPViewer) {
+		r.lock.Lock()
+		delete(r.whepViewers, v.ID)
+		r.lock.Unlock()
+	})
+
+	answer, err := viewer.Answer(offer, publishedTracks(publishers))
+	if err != nil {
+		viewer.Close()
+		return nil, err
+	}
+
+	return answer, nil
+}
+
+func (r *Room) WHEPViewer(viewerID core.UserSessionID) (*WHEPViewer, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	viewer := r.whepViewers[viewerID]
+	if viewer == nil {
+		return nil, errNoWHEPViewer
+	}
+
+	return viewer, nil
+}
+
+// detachWHEPViewers removes the WHEP viewers from the room, the lock must be held
+func (r *Room) detachWHEPViewers() []*WHEPViewer {
+	viewers := make([]*WHEPViewer, 0, len(r.whepViewers))
+	for id, viewer := range r.whepViewers {
+		viewers = append(viewers, viewer)
+		delete(r.whepViewers, id)
+	}
+
+	return viewers
+}
+
+// RemoveSubscriber forgets the subscriber without renegotiation, i.e. when it has been closed
+func (r *Room) RemoveSubscriber(subscriberID core.UserSessionID) {
+	r.lock.Lock()
+	delete(r.subscribers, subscriberID)
+	delete(r.invites, subscriberID)
+	r.lock.Unlock()
+}
+
+func (r *Room) HandleAnswer(userID core.UserSessionID, params rpc.SDPParams) error {
+	r.lock.RLock()
+	participant := r.participants[userID]
+	r.lock.RUnlock()
+
+	if participant == nil {
+		return errNoParticipant
+	}
+
+	return participant.HandleAnswer(params)
+}
+
+// publishersExcept returns the publishers of the room but the given one, the lock must be held
+func (r *Room) publishersExcept(userID core.UserSessionID) []*Participant {
+	publishers := make([]*Participant, 0, len(r.participants))
+	for id, publisher := range r.participants {
+		if id != userID {
+			publishers = append(publishers, publisher)
+		}
+	}
+
+	return publishers
+}
+
+// receiversExcept returns the subscribers and the publishers of the room but the given one,
+// removing the closed subscribers. The lock must be held.
+func (r *Room) receiversExcept(userID core.UserSessionID) []*Participant {
+	receivers := r.publishersExcept(userID)
+	for id, subscriber := range r.subscribers {
+		if subscriber.IsClosed() {
+			delete(r.subscribers, id)
+			continue
+		}
+		if id != userID {
+			receivers = append(receivers, subscriber)
+		}
+	}
+
+	return receivers
+}
+
+func publishedTracks(publishers []*Participant) []*MediaTrack {
+	tracks := make([]*MediaTrack, 0)
+	for _, publisher := range publishers {
+		tracks = append(tracks, publisher.PublishedTracks()...)
+	}
+
+	return tracks
+}
+
+// onTrackPublished attaches a newly published track to the subscribers and the other publishers
+func (r *Room) onTrackPublished(publisher *Participant, track *MediaTrack) {
+	r.listenAudioLevels(publisher.ID, track)
+
+	r.lock.Lock()
+	receivers := r.receiversExcept(publisher.ID)
+	r.lock.Unlock()
+
+	for _, receiver := range receivers {
+		if err := receiver.SubscribeTo(track); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("subscriber", string(receiver.ID)).Msg("subscribe to published track")
+		}
+	}
+
+	r.sendRoomTracks()
+}
+
+// listenAudioLevels passes the audio levels of the track to the active speakers of the room
+func (r *Room) listenAudioLevels(publisherID core.UserSessionID, track *MediaTrack) {
+	if track.Kind != webrtc.RTPCodecTypeAudio {
+		return
+	}
+
+	track.AddAudioLevelListener(r.ID, func(level uint8) {
+		r.audioLevels.Observe(publisherID, level)
+	})
+}
+
+// roomTracks lists the tracks of every publisher of the room
+func (r *Room) roomTracks() []rpc.PublisherTracks {
+	r.lock.RLock()
+	publishers := r.publishersExcept("")
+	r.lock.RUnlock()
+
+	sort.Slice(publishers, func(i, j int) bool {
+		return publishers[i].ID < publishers[j].ID
+	})
+
+	result := make([]rpc.PublisherTracks, 0, len(publishers))
+	for _, publisher := range publishers {
+		tracks := make([]rpc.TrackInfo, 0)
+		for _, track := range publisher.PublishedTracks() {
+			tracks = append(tracks, rpc.TrackInfo{
+				ID:       string(track.ID),
+				StreamID: track.StreamID,
+				Kind:     track.Kind.String(),
+				Muted:    track.IsMuted(),
+			})
+		}
+		result = append(result, rpc.PublisherTracks{UserID: publisher.ID, Tracks: tracks})
+	}
+
+	return result
+}
+
+// sendRoomTracks announces the current tracks of the room to its members
+func (r *Room) sendRoomTracks() {
+	message := rpc.NewRoomTracksRpc(r.ID, r.roomTracks())
+
+	for _, id := range r.members() {
+		if err := r.rpcSink.PublishClient(id, message); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("member", string(id)).Msg("send room tracks")
+		}
+	}
+}
+
+// MuteTrack mutes or unmutes the track of the publisher of the room, every track if the ID is empty.
+// The publisher mutes its own tracks, the owner of the room force-mutes the tracks of the co-publishers.
+func (r *Room) MuteTrack(requesterID core.UserSessionID, publisherID core.UserSessionID, trackID MediaTrackID, muted bool) error {
+	if requesterID != publisherID && requesterID != r.ID {
+		return errNotRoomOwner
+	}
+
+	return r.setTrackMuted(publisherID, trackID, muted, requesterID != publisherID)
+}
+
+// ForceMuteTrack mutes or unmutes the track of the publisher on behalf of the admin
+func (r *Room) ForceMuteTrack(publisherID core.UserSessionID, trackID MediaTrackID, muted bool) error {
+	return r.setTrackMuted(publisherID, trackID, muted, true)
+}
+
+func (r *Room) setTrackMuted(publisherID core.UserSessionID, trackID MediaTrackID, muted bool, moderator bool) error {
+	r.lock.RLock()
+	publisher := r.participants[publisherID]
+	r.lock.RUnlock()
+
+	if publisher == nil {
+		return errNoParticipant
+	}
+
+	return publisher.SetTrackMuted(trackID, muted, moderator)
+}
+
+// AddForwardTarget forwards the track of the publisher of the room to the external receiver
+func (r *Room) AddForwardTarget(publisherID core.UserSessionID, trackID MediaTrackID, params ForwardTargetParams) (*ForwardTarget, error) {
+	publisher := r.Participant(publisherID)
+	if publisher == nil {
+		return nil, errNoParticipant
+	}
+
+	return publisher.AddForwardTarget(trackID, params)
+}
+
+// RemoveForwardTarget stops forwarding the track of any publisher of the room to the receiver
+func (r *Room) RemoveForwardTarget(targetID string) error {
+	r.lock.RLock()
+	publishers := r.publishersExcept("")
+	r.lock.RUnlock()
+
+	for _, publisher := range publishers {
+		for _, mt := range publisher.PublishedTracks() {
+			if mt.RemoveForwardTarget(targetID) {
+				return nil
+			}
+		}
+	}
+
+	return errForwardTargetNotFound
+}
+
+// ForwardTargets lists the forward targets of the tracks of every publisher of the room
+func (r *Room) ForwardTargets() []ForwardTargetInfo {
+	r.lock.RLock()
+	publishers := r.publishersExcept("")
+	r.lock.RUnlock()
+
+	targets := make([]ForwardTargetInfo, 0)
+	for _, publisher := range publishers {
+		for _, mt := range publisher.PublishedTracks() {
+			for _, target := range mt.ForwardTargets() {
+				targets = append(targets, target.Info())
+			}
+		}
+	}
+
+	return targets
+}
+
+// onTrackMuted announces the mute state of the track to the members of the room
+func (r *Room) onTrackMuted(publisher *Participant, track *MediaTrack) {
+	muted, forced := track.MuteState()
+	message := rpc.NewTrackMutedRpc(rpc.TrackMutedParams{
+		RoomID:  r.ID,
+		UserID:  publisher.ID,
+		TrackID: string(track.ID),
+		Muted:   muted,
+		Forced:  forced,
+	})
+
+	for _, id := range r.members() {
+		if err := r.rpcSink.PublishClient(id, message); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("member", string(id)).Msg("send track muted")
+		}
+	}
+}
+
+// members returns the IDs of the publishers and the subscribers of the room
+func (r *Room) members() []core.UserSessionID {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	members := make([]core.UserSessionID, 0, len(r.participants)+len(r.subscribers))
+	for id := range r.participants {
+		members = append(members, id)
+	}
+	for id := range r.subscribers {
+		members = append(members, id)
+	}
+
+	return members
+}
+
+// sendActiveSpeakers notifies the participants and the subscribers of the room about the speakers
+func (r *Room) sendActiveSpeakers(speakers []SpeakerLevel) {
+	activeSpeakers := make([]rpc.ActiveSpeaker, 0, len(speakers))
+	for _, speaker := range speakers {
+		activeSpeakers = append(activeSpeakers, rpc.ActiveSpeaker{UserID: speaker.UserID, Level: speaker.Level})
+	}
+	message := rpc.NewActiveSpeakersRpc(r.ID, activeSpeakers)
+
+	for _, id := range r.members() {
+		if err := r.rpcSink.PublishClient(id, message); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("member", string(id)).Msg("send active speakers")
+		}
+	}
+}
+
+// SendData delivers the data packet of the member to the other members of the room
+func (r *Room) SendData(sender *Participant, packet *DataPacket) error {
+	r.lock.Lock()
+	_, isPublisher := r.participants[sender.ID]
+	_, isSubscriber := r.subscribers[sender.ID]
+	if !isPublisher && !isSubscriber {
+		r.lock.Unlock()
+		return errNotRoomMember
+	}
+	if packet.lossy && !r.canSendLossyData(sender.ID, isPublisher) {
+		r.lock.Unlock()
+		return errLossyDataForbidden
+	}
+	receivers := r.receiversExcept(sender.ID)
+	r.lock.Unlock()
+
+	packet.RoomID = r.ID
+	packet.From = sender.ID
+	packet.Timestamp = time.Now().UnixMilli()
+
+	data, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	for _, receiver := range receivers {
+		if err := receiver.SendData(data, packet.lossy); err != nil {
+			log.Debug().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("member", string(receiver.ID)).Msg("send data packet")
+		}
+	}
+
+	return nil
+}
+
+// SetLossyDataPolicy changes who may send into the lossy data channel, only the owner of the room can change it
+func (r *Room) SetLossyDataPolicy(requesterID core.UserSessionID, policy rpc.LossyDataPolicy) error {
+	if requesterID != r.ID {
+		return errNotRoomOwner
+	}
+
+	switch policy {
+	case rpc.LossyDataPublishers, rpc.LossyDataEveryone, rpc.LossyDataOwner, rpc.LossyDataNobody:
+	default:
+		return errLossyDataPolicy
+	}
+
+	r.lock.Lock()
+	r.lossyDataPolicy = policy
+	r.lock.Unlock()
+
+	return nil
+}
+
+// canSendLossyData applies the policy of the lossy data channel to the member, the lock must be held
+func (r *Room) canSendLossyData(userID core.UserSessionID, isPublisher bool) bool {
+	switch r.lossyDataPolicy {
+	case rpc.LossyDataEveryone:
+		return true
+	case rpc.LossyDataPublishers:
+		return isPublisher
+	case rpc.LossyDataOwner:
+		return userID == r.ID
+	default:
+		return false
+	}
+}
+
 func (r *Room) HandleOffer(userID core.UserSessionID, params rpc.SDPParams) error {
 	r.lock.RLock()
 	participant := r.participants[userID]
@@ -83,22 +681,118 @@ func (r *Room) PublishStream(userID core.UserSessionID) error {
 	return nil
 }
 
-// StopStream sends messages to participants about stop host's stream
+// StartRecording writes the tracks published by the owner of the room into the files
+func (r *Room) StartRecording(params RecordingParams) error {
+	owner := r.Participant(r.ID)
+	if owner == nil {
+		return errNoParticipant
+	}
+
+	return owner.StartRecording(params)
+}
+
+func (r *Room) StopRecording() error {
+	owner := r.Participant(r.ID)
+	if owner == nil {
+		return errNoParticipant
+	}
+
+	return owner.StopRecording()
+}
+
+// StopStream ends the broadcast of the room, only the owner of the room can stop it.
+// The viewers are detached from the tracks of the room and notified, the co-publishers stay in the room.
 func (r *Room) StopStream(userID core.UserSessionID) error {
+	if userID != r.ID {
+		return errNotRoomOwner
+	}
+
+	r.lock.Lock()
+	owner := r.participants[r.ID]
+	if owner == nil {
+		r.lock.Unlock()
+		return errNoParticipant
+	}
+	viewers := r.detachSubscribers()
+	whepViewers := r.detachWHEPViewers()
+	publishers := r.publishersExcept("")
+	r.lock.Unlock()
+
+	r.endStream(viewers, publishedTracks(publishers), rpc.StreamEndStopped)
+
+	// The WHEP viewers learn about the end of the stream from the closed connection
+	for _, viewer := range whepViewers {
+		viewer.Close()
+	}
+
+	// The recording ends with the stream, the owner may be not recorded
+	_ = owner.StopRecording()
+
 	return nil
 }
 
+// detachSubscribers removes the subscribers from the room and returns the open ones, the lock must be held
+func (r *Room) detachSubscribers() []*Participant {
+	subscribers := make([]*Participant, 0, len(r.subscribers))
+	for id, subscriber := range r.subscribers {
+		if !subscriber.IsClosed() {
+			subscribers = append(subscribers, subscriber)
+		}
+		delete(r.subscribers, id)
+	}
+
+	return subscribers
+}
+
+// endStream detaches the viewers from the tracks of the room and tells them that the stream has ended
+func (r *Room) endStream(viewers []*Participant, tracks []*MediaTrack, reason rpc.StreamEndReason) {
+	message := rpc.NewStreamEndedRpc(r.ID, reason)
+
+	for _, viewer := range viewers {
+		if err := viewer.UnsubscribeFrom(tracks...); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("subscriber", string(viewer.ID)).Msg("unsubscribe from ended stream")
+		}
+
+		if err := r.rpcSink.PublishClient(viewer.ID, message); err != nil {
+			log.Error().Err(err).Str("service", "room").Str("ID", string(r.ID)).Str("subscriber", string(viewer.ID)).Msg("send stream ended")
+		}
+	}
+}
+
+// Close closes the owner of the room. The co-publishers and the viewers are detached from the tracks
+// of the room and notified that the host has left.
 func (r *Room) Close() error {
+	r.audioLevels.Stop()
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	participant := r.participants[r.ID]
+	coPublishers := r.publishersExcept(r.ID)
+	for _, coPublisher := range coPublishers {
+		delete(r.participants, coPublisher.ID)
+	}
+	subscribers := r.detachSubscribers()
+	whepViewers := r.detachWHEPViewers()
+	r.lock.Unlock()
 
-	// Send to all paticipants but current the close signal
+	for _, viewer := range whepViewers {
+		viewer.Close()
+	}
 
-	participant := r.participants[r.ID]
 	if participant == nil {
 		return errNoParticipant
 	}
 
+	// Co-publishers stay in their own rooms
+	for _, coPublisher := range coPublishers {
+		coPublisher.RemoveTrackListener(r.ID)
+	}
+	tracks := append(participant.PublishedTracks(), publishedTracks(coPublishers)...)
+	for _, track := range tracks {
+		track.RemoveAudioLevelListener(r.ID)
+	}
+	r.endStream(coPublishers, participant.PublishedTracks(), rpc.StreamEndHostLeft)
+	r.endStream(subscribers, tracks, rpc.StreamEndHostLeft)
+
 	participant.Close()
 
 	return nil
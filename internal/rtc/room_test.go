@@ -0,0 +1,178 @@
+package rtc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/eventbus"
+	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
+)
+
+// testSink collects the RPCs sent to the clients
+type testSink struct {
+	lock     sync.Mutex
+	messages map[core.UserSessionID][]rpc.Rpc
+}
+
+func newTestSink() *testSink {
+	return &testSink{messages: make(map[core.UserSessionID][]rpc.Rpc)}
+}
+
+func (s *testSink) PublishClient(userID core.UserSessionID, message rpc.Rpc) error {
+	s.lock.Lock()
+	s.messages[userID] = append(s.messages[userID], message)
+	s.lock.Unlock()
+
+	return nil
+}
+
+func (s *testSink) PublishServer(message eventbus.ServerMessage) error {
+	return nil
+}
+
+// newTestParticipant returns the participant without the publisher transport,
+// its subscriber transport is created on the first subscription
+func newTestParticipant(t *testing.T, id core.UserSessionID, sink eventbus.Publisher) *Participant {
+	conf := config.NewConfig()
+	rtcConf, err := config.NewWebRTCConfig(conf)
+	require.NoError(t, err)
+
+	p := &Participant{
+		ID:               id,
+		sink:             sink,
+		enabledCodecs:    conf.Peer.EnabledCodecs,
+		rtcConf:          rtcConf,
+		publishedTracks:  make(map[MediaTrackID]*MediaTrack),
+		subscribedTracks: make(map[MediaTrackID]*DownTrack),
+		trackListeners:   make(map[core.UserSessionID]func(*Participant, *MediaTrack)),
+		muteListeners:    make(map[core.UserSessionID]func(*Participant, *MediaTrack)),
+		failedTransports: make(map[rpc.SignalingTarget]struct{}),
+	}
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// publishTestAudio publishes the Opus track of the participant into its rooms
+func publishTestAudio(p *Participant, id MediaTrackID) *MediaTrack {
+	track := NewMediaTrack(MediaTrackParams{
+		TrackID:  id,
+		StreamID: string(p.ID),
+		Kind:     webrtc.RTPCodecTypeAudio,
+		Codec: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    webrtc.MimeTypeOpus,
+				ClockRate:   48000,
+				Channels:    2,
+				SDPFmtpLine: "minptime=10;useinbandfec=1",
+			},
+			PayloadType: 111,
+		},
+		Transcoder: &fakeTranscoderTransport{},
+	})
+
+	p.Lock()
+	p.publishedTracks[track.ID] = track
+	listeners := p.publishedTrackListeners()
+	p.Unlock()
+
+	p.notifyTrackPublished(track, listeners, nil)
+
+	return track
+}
+
+func subscribedTo(p *Participant, track *MediaTrack) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	_, ok := p.subscribedTracks[track.ID]
+
+	return ok
+}
+
+func newTestRoom(t *testing.T, owner *Participant, sink eventbus.Publisher) *Room {
+	room := NewRoom(owner.ID, config.PeerConfig{}, config.WebRTCConfig{}, sink)
+	t.Cleanup(room.audioLevels.Stop)
+	room.Join(owner)
+
+	return room
+}
+
+func TestRoomCoPublisherLeaves(t *testing.T) {
+	sink := newTestSink()
+	host := newTestParticipant(t, "host", sink)
+	coHost := newTestParticipant(t, "co-host", sink)
+	viewer := newTestParticipant(t, "viewer", sink)
+
+	hostRoom := newTestRoom(t, host, sink)
+	coHostRoom := newTestRoom(t, coHost, sink)
+
+	hostTrack := publishTestAudio(host, "host-audio")
+	coHostTrack := publishTestAudio(coHost, "co-host-audio")
+
+	require.NoError(t, hostRoom.Invite(host.ID, coHost.ID))
+	require.NoError(t, hostRoom.AddPublisher(coHost))
+	require.NoError(t, hostRoom.Subscribe(viewer))
+
+	require.True(t, subscribedTo(viewer, coHostTrack))
+	require.True(t, subscribedTo(host, coHostTrack))
+	require.True(t, subscribedTo(coHost, hostTrack))
+
+	// The session of the co-host is closed: it leaves the co-published room, then its own room is closed
+	require.NoError(t, hostRoom.RemovePublisher(coHost.ID, coHost.ID))
+	require.NoError(t, coHostRoom.Close())
+
+	assert.False(t, subscribedTo(viewer, coHostTrack))
+	assert.False(t, subscribedTo(host, coHostTrack))
+	assert.True(t, subscribedTo(viewer, hostTrack))
+	assert.Empty(t, coHostTrack.DownTracks())
+	assert.False(t, hostRoom.IsPublisher(coHost.ID))
+}
+
+func observesAudioLevels(room *Room, userID core.UserSessionID) bool {
+	room.audioLevels.lock.Lock()
+	defer room.audioLevels.lock.Unlock()
+
+	_, ok := room.audioLevels.levels[userID]
+
+	return ok
+}
+
+func TestRoomAudioLevelsOfCoPublisher(t *testing.T) {
+	sink := newTestSink()
+	host := newTestParticipant(t, "host", sink)
+	coHost := newTestParticipant(t, "co-host", sink)
+
+	hostRoom := newTestRoom(t, host, sink)
+	coHostRoom := newTestRoom(t, coHost, sink)
+
+	published := publishTestAudio(coHost, "co-host-audio")
+	require.NoError(t, hostRoom.Invite(host.ID, coHost.ID))
+	require.NoError(t, hostRoom.AddPublisher(coHost))
+	// The track published into both rooms doesn't take the levels away from the own room of the co-host
+	publishedAfterJoin := publishTestAudio(coHost, "co-host-mic")
+
+	for _, track := range []*MediaTrack{published, publishedAfterJoin} {
+		hostRoom.audioLevels.Remove(coHost.ID)
+		coHostRoom.audioLevels.Remove(coHost.ID)
+
+		track.observeAudioLevel([]byte{10})
+
+		assert.True(t, observesAudioLevels(hostRoom, coHost.ID), "track %s", track.ID)
+		assert.True(t, observesAudioLevels(coHostRoom, coHost.ID), "track %s", track.ID)
+	}
+
+	require.NoError(t, hostRoom.RemovePublisher(coHost.ID, coHost.ID))
+	coHostRoom.audioLevels.Remove(coHost.ID)
+
+	published.observeAudioLevel([]byte{10})
+
+	assert.False(t, observesAudioLevels(hostRoom, coHost.ID))
+	assert.True(t, observesAudioLevels(coHostRoom, coHost.ID))
+}
@@ -0,0 +1,82 @@
+package rtc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// rtxPayloadType finds the RTX payload type associated with the payload type of the codec
+func rtxPayloadType(codecs []webrtc.RTPCodecParameters, payloadType webrtc.PayloadType) (webrtc.PayloadType, bool) {
+	apt := fmt.Sprintf("apt=%d", payloadType)
+	for _, codec := range codecs {
+		if strings.EqualFold(codec.MimeType, mimeTypeRTX) && codec.SDPFmtpLine == apt {
+			return codec.PayloadType, true
+		}
+	}
+
+	return 0, false
+}
+
+// addRTXStreams signals the RTX streams in the offer. Pion does not send RTX,
+// so the repair SSRCs are added next to the media SSRCs they protect (RFC 4588, section 8.7).
+func addRTXStreams(desc webrtc.SessionDescription, rtxSSRCs map[webrtc.SSRC]webrtc.SSRC) (webrtc.SessionDescription, error) {
+	if len(rtxSSRCs) == 0 {
+		return desc, nil
+	}
+
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return desc, err
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		grouped := make(map[webrtc.SSRC]bool)
+		extra := make([]sdp.Attribute, 0)
+
+		for _, attr := range media.Attributes {
+			if attr.Key != sdp.AttrKeySSRC {
+				continue
+			}
+
+			fields := strings.SplitN(attr.Value, " ", 2)
+			ssrc, err := strconv.ParseUint(fields[0], 10, 32)
+			if err != nil {
+				continue
+			}
+
+			rtxSSRC, ok := rtxSSRCs[webrtc.SSRC(ssrc)]
+			if !ok {
+				continue
+			}
+
+			if !grouped[webrtc.SSRC(ssrc)] {
+				grouped[webrtc.SSRC(ssrc)] = true
+				extra = append(extra, sdp.Attribute{
+					Key:   sdp.AttrKeySSRCGroup,
+					Value: fmt.Sprintf("%s %d %d", sdp.SemanticTokenFlowIdentification, ssrc, rtxSSRC),
+				})
+			}
+
+			// the repair stream shares cname and msid with the media one
+			if len(fields) == 2 {
+				extra = append(extra, sdp.Attribute{
+					Key:   sdp.AttrKeySSRC,
+					Value: fmt.Sprintf("%d %s", rtxSSRC, fields[1]),
+				})
+			}
+		}
+
+		media.Attributes = append(media.Attributes, extra...)
+	}
+
+	raw, err := parsed.Marshal()
+	if err != nil {
+		return desc, err
+	}
+
+	return webrtc.SessionDescription{Type: desc.Type, SDP: string(raw)}, nil
+}
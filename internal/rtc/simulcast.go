@@ -0,0 +1,174 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/rtp/pkg/obu"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
+)
+
+// RIDs of the simulcast layers sent by the publisher
+const (
+	QuarterResolutionRID = "q"
+	HalfResolutionRID    = "h"
+	FullResolutionRID    = "f"
+)
+
+// Spatial layers of the simulcast track
+const (
+	InvalidLayer = -1
+	LowLayer     = 0
+	MediumLayer  = 1
+	HighLayer    = 2
+
+	maxSpatialLayers = 3
+)
+
+// NAL unit types of H.264 (RFC 6184)
+const (
+	naluTypeIDR   = 5
+	naluTypeSPS   = 7
+	naluTypeSTAPA = 24
+	naluTypeFUA   = 28
+
+	naluTypeMask = 0x1f
+	fuStartBit   = 0x80
+)
+
+// AV1 aggregation header and OBU header (AV1 RTP specification)
+const (
+	av1ZMask     = 0x80
+	av1WMask     = 0x30
+	av1WShift    = 4
+	av1NMask     = 0x08
+	obuTypeMask  = 0x78
+	obuTypeShift = 3
+
+	obuSequenceHeader = 1
+)
+
+// layerFromRID maps RID of the simulcast stream to the spatial layer.
+// Track without RID is not a simulcast one, it is published as a single low layer.
+func layerFromRID(rid string) int {
+	switch rid {
+	case "", QuarterResolutionRID:
+		return LowLayer
+	case HalfResolutionRID:
+		return MediumLayer
+	case FullResolutionRID:
+		return HighLayer
+	default:
+		return InvalidLayer
+	}
+}
+
+// layerFromQuality maps requested video quality of the subscriber to the spatial layer
+func layerFromQuality(quality rpc.VideoQuality) int {
+	switch quality {
+	case rpc.VideoQualityLow:
+		return LowLayer
+	case rpc.VideoQualityMedium:
+		return MediumLayer
+	default:
+		return HighLayer
+	}
+}
+
+// isKeyframe checks whether the packet starts a keyframe,
+// so the subscriber can be switched to another layer without artifacts
+func isKeyframe(codec webrtc.RTPCodecParameters, packet *rtp.Packet) bool {
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		vp8 := &codecs.VP8Packet{}
+		if _, err := vp8.Unmarshal(packet.Payload); err != nil {
+			return false
+		}
+		// The first partition of the frame, P bit of the payload header is 0 for keyframes
+		return vp8.S == 1 && vp8.PID == 0 && len(vp8.Payload) > 0 && vp8.Payload[0]&0x01 == 0
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		vp9 := &codecs.VP9Packet{}
+		if _, err := vp9.Unmarshal(packet.Payload); err != nil {
+			return false
+		}
+		// The beginning of the picture without the inter-picture prediction, the base layer if it is scalable
+		return !vp9.P && vp9.B && (!vp9.L || vp9.SID == 0)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264Keyframe(packet.Payload)
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		return isAV1Keyframe(packet.Payload)
+	default:
+		// Audio and unknown codecs can be switched at any packet
+		return true
+	}
+}
+
+// isH264Keyframe checks whether the packet carries the SPS or starts the IDR picture,
+// the NAL unit may be single, aggregated into STAP-A or fragmented into FU-A
+func isH264Keyframe(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	switch naluType := payload[0] & naluTypeMask; naluType {
+	case naluTypeIDR, naluTypeSPS:
+		return true
+	case naluTypeSTAPA:
+		// Every aggregated NAL unit is prefixed by its 16-bit size
+		for offset := 1; offset+2 < len(payload); {
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+			if size == 0 || offset+size > len(payload) {
+				return false
+			}
+			if t := payload[offset] & naluTypeMask; t == naluTypeIDR || t == naluTypeSPS {
+				return true
+			}
+			offset += size
+		}
+	case naluTypeFUA:
+		// The first fragment of the NAL unit, the FU header carries its type
+		if len(payload) < 2 || payload[1]&fuStartBit == 0 {
+			return false
+		}
+		t := payload[1] & naluTypeMask
+		return t == naluTypeIDR || t == naluTypeSPS
+	}
+
+	return false
+}
+
+// isAV1Keyframe checks whether the packet starts the coded video sequence or its first OBU is the sequence header,
+// the keyframe is sent after the sequence header
+func isAV1Keyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+
+	if payload[0]&av1NMask != 0 {
+		return true
+	}
+	// The first OBU continues the fragment of the previous packet
+	if payload[0]&av1ZMask != 0 {
+		return false
+	}
+
+	offset := 1
+	// The OBU is prefixed by its length unless it is the only one in the packet
+	if (payload[0]&av1WMask)>>av1WShift != 1 {
+		_, n, err := obu.ReadLeb128(payload[offset:])
+		if err != nil {
+			return false
+		}
+		offset += int(n)
+	}
+	if offset >= len(payload) {
+		return false
+	}
+
+	return (payload[offset]&obuTypeMask)>>obuTypeShift == obuSequenceHeader
+}
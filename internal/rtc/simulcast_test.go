@@ -0,0 +1,47 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsKeyframe(t *testing.T) {
+	av1 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1}}
+	h264 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}}
+
+	tests := []struct {
+		name     string
+		codec    webrtc.RTPCodecParameters
+		payload  []byte
+		keyframe bool
+	}{
+		{"H.264 single IDR", h264, []byte{0x65, 0x88, 0x84}, true},
+		{"H.264 single SPS", h264, []byte{0x67, 0x42, 0xc0, 0x1f}, true},
+		{"H.264 single non-IDR", h264, []byte{0x41, 0x9a}, false},
+		{"H.264 STAP-A with SPS and PPS", h264, []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}, true},
+		{"H.264 STAP-A with SEI and IDR", h264, []byte{0x78, 0x00, 0x02, 0x06, 0x05, 0x00, 0x02, 0x65, 0x88}, true},
+		{"H.264 STAP-A with non-IDR", h264, []byte{0x78, 0x00, 0x02, 0x41, 0x9a}, false},
+		{"H.264 STAP-A truncated", h264, []byte{0x78, 0x00, 0x05, 0x65}, false},
+		{"H.264 FU-A start of IDR", h264, []byte{0x7c, 0x85, 0x88}, true},
+		{"H.264 FU-A middle of IDR", h264, []byte{0x7c, 0x05, 0x88}, false},
+		{"H.264 FU-A start of non-IDR", h264, []byte{0x7c, 0x81, 0x9a}, false},
+		{"H.264 empty", h264, []byte{}, false},
+		{"AV1 new coded video sequence", av1, []byte{0x18, 0x0a, 0x0b}, true},
+		{"AV1 single sequence header", av1, []byte{0x10, 0x0a, 0x0b}, true},
+		{"AV1 sequence header with length", av1, []byte{0x20, 0x02, 0x0a, 0x0b, 0x03, 0x32, 0x00, 0x00}, true},
+		{"AV1 sequence header with two byte length", av1, []byte{0x00, 0x81, 0x00, 0x0a, 0x0b}, true},
+		{"AV1 frame", av1, []byte{0x10, 0x32, 0x00}, false},
+		{"AV1 continued fragment", av1, []byte{0x90, 0x0a, 0x0b}, false},
+		{"AV1 truncated length", av1, []byte{0x20, 0x80}, false},
+		{"Opus", webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}}, []byte{0x01}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.keyframe, isKeyframe(tt.codec, &rtp.Packet{Payload: tt.payload}))
+		})
+	}
+}
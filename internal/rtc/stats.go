@@ -0,0 +1,313 @@
+package rtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/telemetry"
+)
+
+const (
+	// how often the statistics of the received streams are reported to Prometheus
+	statsReportInterval = 5 * time.Second
+
+	directionInbound  = "inbound"
+	directionOutbound = "outbound"
+
+	// seconds between 1900 (NTP epoch) and 1970 (Unix epoch)
+	ntpEpochOffset = 2208988800
+)
+
+// TrackStats is the snapshot of the RTP statistics of the published track or of the forwarded one
+type TrackStats struct {
+	TrackID      MediaTrackID       `json:"track_id"`
+	Kind         string             `json:"kind"`
+	Direction    string             `json:"direction"`
+	Codec        string             `json:"codec"`
+	SubscriberID core.UserSessionID `json:"subscriber_id,omitempty"`
+	Packets      uint64             `json:"packets"`
+	Bytes        uint64             `json:"bytes"`
+	PacketsLost  int64              `json:"packets_lost"`
+	// lost fraction of the last interval, 0..1
+	FractionLost float64 `json:"fraction_lost"`
+	// seconds
+	Jitter float64 `json:"jitter"`
+	// seconds, known for the forwarded tracks only
+	RTT     float64 `json:"rtt,omitempty"`
+	Bitrate int     `json:"bitrate"`
+	NACKs   uint64  `json:"nacks"`
+	PLIs    uint64  `json:"plis"`
+	FIRs    uint64  `json:"firs"`
+	// retransmitted packets of the forwarded track
+	Retransmits uint64 `json:"retransmits,omitempty"`
+	// keyframe requests sent to the publisher of the published track
+	KeyframeRequests uint64 `json:"keyframe_requests,omitempty"`
+	// packets of the published track delivered to the transcoder and dropped by its transport
+	TranscoderPackets uint64 `json:"transcoder_packets,omitempty"`
+	TranscoderDropped uint64 `json:"transcoder_dropped,omitempty"`
+	// the highest received layer of the published track, the forwarded layer of the other one
+	SpatialLayer int `json:"spatial_layer"`
+	// the forwarding of the published track to every subscriber
+	Subscribers []TrackStats `json:"subscribers,omitempty"`
+}
+
+// ParticipantStats is the snapshot of the statistics of the tracks the participant publishes and receives
+type ParticipantStats struct {
+	UserID     core.UserSessionID `json:"user_id"`
+	Published  []TrackStats       `json:"published"`
+	Subscribed []TrackStats       `json:"subscribed"`
+}
+
+// receiveStats counts the packets of the received RTP stream, the loss and the jitter (RFC 3550, appendix A)
+type receiveStats struct {
+	lock      sync.Mutex
+	clockRate uint32
+
+	started     bool
+	baseSN      uint16
+	maxSN       uint16
+	cycles      uint32
+	packets     uint64
+	bytes       uint64
+	lastTransit int32
+	// in the timestamp units
+	jitter float64
+
+	// the state of the previous report
+	reportedAt    time.Time
+	expectedPrior uint64
+	receivedPrior uint64
+	bytesPrior    uint64
+	lostPrior     int64
+	fractionLost  float64
+}
+
+func newReceiveStats(clockRate uint32) *receiveStats {
+	return &receiveStats{
+		clockRate:  clockRate,
+		reportedAt: time.Now(),
+	}
+}
+
+func (s *receiveStats) Update(hdr *rtp.Header, size int, arrival time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.packets++
+	s.bytes += uint64(size)
+
+	if !s.started {
+		s.started = true
+		s.baseSN = hdr.SequenceNumber
+		s.maxSN = hdr.SequenceNumber
+	} else if diff := hdr.SequenceNumber - s.maxSN; diff > 0 && diff < 1<<15 {
+		if hdr.SequenceNumber < s.maxSN {
+			s.cycles += 1 << 16
+		}
+		s.maxSN = hdr.SequenceNumber
+	}
+
+	if s.clockRate == 0 {
+		return
+	}
+
+	arrivalTS := uint32(arrival.UnixNano() * int64(s.clockRate) / int64(time.Second))
+	transit := int32(arrivalTS - hdr.Timestamp)
+	if s.packets > 1 {
+		d := float64(transit - s.lastTransit)
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.lastTransit = transit
+}
+
+func (s *receiveStats) expected() uint64 {
+	if !s.started {
+		return 0
+	}
+
+	return uint64(s.cycles) + uint64(s.maxSN) - uint64(s.baseSN) + 1
+}
+
+// Snapshot fills the counters of the stream
+func (s *receiveStats) Snapshot(stats *TrackStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats.Packets += s.packets
+	stats.Bytes += s.bytes
+	stats.PacketsLost += int64(s.expected()) - int64(s.packets)
+	if s.fractionLost > stats.FractionLost {
+		stats.FractionLost = s.fractionLost
+	}
+	if jitter := s.jitterSeconds(); jitter > stats.Jitter {
+		stats.Jitter = jitter
+	}
+}
+
+func (s *receiveStats) jitterSeconds() float64 {
+	if s.clockRate == 0 {
+		return 0
+	}
+
+	return s.jitter / float64(s.clockRate)
+}
+
+// Report sends the statistics of the last interval to Prometheus once in statsReportInterval
+func (s *receiveStats) Report(now time.Time) {
+	s.lock.Lock()
+	if now.Sub(s.reportedAt) < statsReportInterval {
+		s.lock.Unlock()
+		return
+	}
+	s.reportedAt = now
+
+	expected := s.expected()
+	expectedInterval := expected - s.expectedPrior
+	receivedInterval := s.packets - s.receivedPrior
+	bytesInterval := s.bytes - s.bytesPrior
+	lost := int64(expected) - int64(s.packets)
+	lostInterval := lost - s.lostPrior
+
+	s.fractionLost = 0
+	if expectedInterval > 0 && lostInterval > 0 {
+		s.fractionLost = float64(lostInterval) / float64(expectedInterval)
+	}
+
+	s.expectedPrior = expected
+	s.receivedPrior = s.packets
+	s.bytesPrior = s.bytes
+	s.lostPrior = lost
+	fractionLost := s.fractionLost
+	jitter := s.jitterSeconds()
+	s.lock.Unlock()
+
+	if lostInterval < 0 {
+		lostInterval = 0
+	}
+
+	telemetry.RTPPackets(directionInbound, int(receivedInterval), int(bytesInterval), int(lostInterval))
+	telemetry.RTPFractionLost(directionInbound, fractionLost)
+	telemetry.RTPJitter(directionInbound, jitter)
+}
+
+// sendStats counts the packets forwarded to the subscriber and the feedback of the subscriber
+type sendStats struct {
+	lock sync.Mutex
+
+	packets     uint64
+	bytes       uint64
+	retransmits uint64
+	nacks       uint64
+	plis        uint64
+	firs        uint64
+
+	// the last receiver report of the subscriber
+	packetsLost  int64
+	fractionLost float64
+	jitter       float64
+	rtt          float64
+
+	packetsReported uint64
+	bytesReported   uint64
+	lostReported    int64
+}
+
+// Sent counts every packet written to the subscriber, the retransmitted and padding ones included
+func (s *sendStats) Sent(size int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.packets++
+	s.bytes += uint64(size)
+}
+
+func (s *sendStats) Retransmitted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.retransmits++
+}
+
+// Feedback counts the RTCP packet of the subscriber
+func (s *sendStats) Feedback(packet rtcp.Packet) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch packet.(type) {
+	case *rtcp.TransportLayerNack:
+		s.nacks++
+	case *rtcp.PictureLossIndication:
+		s.plis++
+	case *rtcp.FullIntraRequest:
+		s.firs++
+	}
+}
+
+// ReceiverReport applies the report of the subscriber about the forwarded stream
+func (s *sendStats) ReceiverReport(report rtcp.ReceptionReport, clockRate uint32, now time.Time) {
+	s.lock.Lock()
+	s.packetsLost = int64(report.TotalLost)
+	s.fractionLost = float64(report.FractionLost) / 256
+	if clockRate != 0 {
+		s.jitter = float64(report.Jitter) / float64(clockRate)
+	}
+
+	// RTT from the last sender report: the middle 32 bits of NTP time, 1/65536 seconds
+	hasRTT := false
+	if report.LastSenderReport != 0 {
+		delay := uint32(ntpTime(now)>>16) - report.LastSenderReport - report.Delay
+		if delay < 1<<31 {
+			s.rtt = float64(delay) / 65536
+			hasRTT = true
+		}
+	}
+
+	packets := s.packets - s.packetsReported
+	bytes := s.bytes - s.bytesReported
+	lost := s.packetsLost - s.lostReported
+	s.packetsReported = s.packets
+	s.bytesReported = s.bytes
+	s.lostReported = s.packetsLost
+
+	fractionLost, jitter, rtt := s.fractionLost, s.jitter, s.rtt
+	s.lock.Unlock()
+
+	telemetry.RTPPackets(directionOutbound, int(packets), int(bytes), int(lost))
+	telemetry.RTPFractionLost(directionOutbound, fractionLost)
+	telemetry.RTPJitter(directionOutbound, jitter)
+	if hasRTT {
+		telemetry.RTPRoundTripTime(rtt)
+	}
+}
+
+// Snapshot fills the counters of the forwarded stream
+func (s *sendStats) Snapshot(stats *TrackStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats.Packets = s.packets
+	stats.Bytes = s.bytes
+	stats.Retransmits = s.retransmits
+	stats.NACKs = s.nacks
+	stats.PLIs = s.plis
+	stats.FIRs = s.firs
+	stats.PacketsLost = s.packetsLost
+	stats.FractionLost = s.fractionLost
+	stats.Jitter = s.jitter
+	stats.RTT = s.rtt
+}
+
+// ntpTime converts the time into the 64 bits NTP timestamp
+func ntpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+
+	return seconds<<32 | fraction
+}
@@ -1,16 +1,48 @@
 package rtc
 
 import (
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+)
+
+const (
+	probeInterval       = 100 * time.Millisecond
+	mediaProbeInterval  = 5 * time.Second
+	maxPaddingPerProbe  = 10
+	probeBitrateDivisor = 2
 )
 
+// StreamAllocator distributes the estimated bandwidth of the subscriber transport between its tracks.
+// It chooses the simulcast layers of the video tracks and pauses them when the bandwidth collapses.
 type StreamAllocator struct {
-	bwe cc.BandwidthEstimator
+	lock     sync.Mutex
+	config   config.CongestionControlConfig
+	bwe      cc.BandwidthEstimator
+	estimate int
+	tracks   map[MediaTrackID]*DownTrack
+	// bitrate required for the next layer upgrade, zero if every track receives its max layer
+	deficit int
+	// extra bandwidth given to the allocation by the media probe
+	probeBoost  int
+	lastProbeAt time.Time
+
+	stop    chan struct{}
+	stopped bool
 }
 
-func NewStreamAllocator() *StreamAllocator {
-	s := &StreamAllocator{}
+func NewStreamAllocator(conf config.CongestionControlConfig) *StreamAllocator {
+	s := &StreamAllocator{
+		config: conf,
+		tracks: make(map[MediaTrackID]*DownTrack),
+		stop:   make(chan struct{}),
+	}
 
 	return s
 }
@@ -22,7 +54,262 @@ func (s *StreamAllocator) SetBandwidthEstimator(bwe cc.BandwidthEstimator) {
 	s.bwe = bwe
 }
 
+// Start runs the probing of the bandwidth when the tracks need more than estimated
+func (s *StreamAllocator) Start() {
+	if !s.config.Enabled {
+		return
+	}
+
+	go s.probeLoop()
+}
+
+func (s *StreamAllocator) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+}
+
+// AddTrack puts the track under control of the allocator
+func (s *StreamAllocator) AddTrack(downTrack *DownTrack) {
+	if !s.config.Enabled {
+		return
+	}
+
+	downTrack.setManaged(true)
+
+	s.lock.Lock()
+	s.tracks[downTrack.mediaTrack.ID] = downTrack
+	s.lock.Unlock()
+
+	s.Allocate()
+}
+
+func (s *StreamAllocator) RemoveTrack(downTrack *DownTrack) {
+	if !s.config.Enabled {
+		return
+	}
+
+	s.lock.Lock()
+	delete(s.tracks, downTrack.mediaTrack.ID)
+	s.lock.Unlock()
+
+	s.Allocate()
+}
+
+// Allocate redistributes the current estimate, i.e. when the subscriber preferences have changed
+func (s *StreamAllocator) Allocate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.allocate()
+}
+
 // called when target bitrate changes (send side bandwidth estimation)
 func (s *StreamAllocator) onTargetBitrateChange(bitrate int) {
-	log.Debug().Msgf("bitrate changed: %d", bitrate)
+	log.Debug().Str("service", "streamAllocator").Msgf("bitrate changed: %d", bitrate)
+
+	if !s.config.Enabled {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.estimate = bitrate
+	s.probeBoost = 0
+	s.allocate()
+}
+
+type trackAllocation struct {
+	downTrack *DownTrack
+	layers    []int
+	// index of the allocated layer in layers
+	current int
+	paused  bool
+}
+
+func (a *trackAllocation) bitrate() int {
+	if a.paused || len(a.layers) == 0 {
+		return 0
+	}
+
+	return a.downTrack.mediaTrack.LayerBitrate(a.layers[a.current])
+}
+
+// nextBitrateDelta returns the extra bitrate needed for the next layer, -1 if there is no next layer
+func (a *trackAllocation) nextBitrateDelta() int {
+	if a.paused {
+		return a.downTrack.mediaTrack.LayerBitrate(a.layers[0])
+	}
+	if a.current+1 >= len(a.layers) || a.layers[a.current+1] > a.downTrack.MaxLayer() {
+		return -1
+	}
+
+	return a.downTrack.mediaTrack.LayerBitrate(a.layers[a.current+1]) - a.bitrate()
+}
+
+func (s *StreamAllocator) allocate() {
+	if s.stopped || len(s.tracks) == 0 {
+		return
+	}
+
+	// No estimation yet, give the subscriber what it asked for
+	if s.estimate == 0 {
+		for _, dt := range s.tracks {
+			dt.Resume()
+			dt.SetTargetLayer(dt.MaxLayer())
+		}
+		s.deficit = 0
+		return
+	}
+
+	available := s.estimate + s.probeBoost
+	if available < int(s.config.MinChannelCapacity) {
+		available = int(s.config.MinChannelCapacity)
+	}
+
+	ids := make([]string, 0, len(s.tracks))
+	for id := range s.tracks {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	// Audio is never paused and goes first
+	allocations := make([]*trackAllocation, 0, len(s.tracks))
+	for _, id := range ids {
+		dt := s.tracks[MediaTrackID(id)]
+		if dt.mediaTrack.Kind != webrtc.RTPCodecTypeVideo {
+			available -= dt.mediaTrack.LayerBitrate(LowLayer)
+			continue
+		}
+
+		layers := dt.mediaTrack.Layers()
+		if len(layers) == 0 {
+			continue
+		}
+		allocations = append(allocations, &trackAllocation{downTrack: dt, layers: layers})
+	}
+
+	// Every video track starts from the lowest layer
+	used := 0
+	for _, a := range allocations {
+		used += a.bitrate()
+	}
+
+	if s.config.AllowPause {
+		for i := len(allocations) - 1; i >= 0 && used > available; i-- {
+			used -= allocations[i].bitrate()
+			allocations[i].paused = true
+		}
+	}
+
+	// Upgrade the tracks one layer at a time while the bandwidth allows
+	for upgraded := true; upgraded; {
+		upgraded = false
+		for _, a := range allocations {
+			delta := a.nextBitrateDelta()
+			if delta < 0 || used+delta > available {
+				continue
+			}
+
+			if a.paused {
+				a.paused = false
+			} else {
+				a.current++
+			}
+			used += delta
+			upgraded = true
+		}
+	}
+
+	// The smallest upgrade that did not fit is the target of the probing
+	s.deficit = 0
+	for _, a := range allocations {
+		if delta := a.nextBitrateDelta(); delta > 0 && (s.deficit == 0 || delta < s.deficit) {
+			s.deficit = delta
+		}
+	}
+
+	for _, a := range allocations {
+		if a.paused {
+			a.downTrack.Pause()
+			continue
+		}
+		a.downTrack.SetTargetLayer(a.layers[a.current])
+		a.downTrack.Resume()
+	}
+}
+
+// probeLoop pushes the estimate up when the tracks are limited by the bandwidth
+func (s *StreamAllocator) probeLoop() {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.probe()
+		}
+	}
+}
+
+func (s *StreamAllocator) probe() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.deficit == 0 || s.estimate == 0 {
+		return
+	}
+
+	switch s.config.ProbeMode {
+	case config.CongestionControlProbeModeMedia:
+		// Optimistically upgrade a track, the next estimate confirms or reverts the upgrade
+		if time.Since(s.lastProbeAt) < mediaProbeInterval {
+			return
+		}
+		s.lastProbeAt = time.Now()
+		s.probeBoost = s.deficit
+		s.allocate()
+	default:
+		s.probePadding()
+	}
+}
+
+// probePadding sends padding on a forwarded video track at the rate of the deficit
+func (s *StreamAllocator) probePadding() {
+	var target *DownTrack
+	for _, dt := range s.tracks {
+		if dt.mediaTrack.Kind == webrtc.RTPCodecTypeVideo && !dt.IsPaused() {
+			target = dt
+			break
+		}
+	}
+	if target == nil {
+		return
+	}
+
+	probeBitrate := s.deficit
+	if limit := s.estimate / probeBitrateDivisor; probeBitrate > limit {
+		probeBitrate = limit
+	}
+
+	bytesPerProbe := int(float64(probeBitrate) / 8 * probeInterval.Seconds())
+	count := bytesPerProbe / paddingSize
+	if count == 0 {
+		count = 1
+	}
+	if count > maxPaddingPerProbe {
+		count = maxPaddingPerProbe
+	}
+
+	if err := target.WritePadding(count); err != nil {
+		log.Debug().Err(err).Str("service", "streamAllocator").Msg("write padding")
+	}
 }
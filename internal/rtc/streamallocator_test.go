@@ -0,0 +1,180 @@
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+// newTestMediaTrack returns the track publishing the layers with the measured bitrates
+func newTestMediaTrack(id MediaTrackID, kind webrtc.RTPCodecType, bitrates ...int) *MediaTrack {
+	track := NewMediaTrack(MediaTrackParams{TrackID: id, Kind: kind})
+	for layer, bitrate := range bitrates {
+		track.layerSSRCs[layer] = webrtc.SSRC(layer + 1)
+		meter := &track.layerBitrates[layer]
+		meter.bitrate = bitrate
+		meter.updatedAt = time.Now()
+	}
+
+	return track
+}
+
+func newTestDownTrack(t *testing.T, track *MediaTrack, maxLayer int) *DownTrack {
+	downTrack, err := NewDownTrack(core.UserSessionID("subscriber"), track)
+	require.NoError(t, err)
+	downTrack.maxLayer = maxLayer
+
+	return downTrack
+}
+
+type allocationResult struct {
+	layer  int
+	paused bool
+}
+
+func allocationOf(downTrack *DownTrack) allocationResult {
+	return allocationResult{layer: downTrack.TargetLayer(), paused: downTrack.IsPaused()}
+}
+
+func TestStreamAllocatorAllocate(t *testing.T) {
+	simulcast := []int{150_000, 500_000, 1_500_000}
+
+	tests := []struct {
+		name        string
+		allowPause  bool
+		minCapacity int64
+		estimate    int
+		audio       int
+		maxLayers   [2]int
+		want        [2]allocationResult
+		deficit     int
+	}{
+		{
+			name:      "no estimate",
+			estimate:  0,
+			maxLayers: [2]int{HighLayer, MediumLayer},
+			want:      [2]allocationResult{{HighLayer, false}, {MediumLayer, false}},
+		},
+		{
+			name:      "estimate above the max layers",
+			estimate:  5_000_000,
+			maxLayers: [2]int{HighLayer, HighLayer},
+			want:      [2]allocationResult{{HighLayer, false}, {HighLayer, false}},
+		},
+		{
+			name:      "max layer of the subscriber",
+			estimate:  5_000_000,
+			maxLayers: [2]int{HighLayer, LowLayer},
+			want:      [2]allocationResult{{HighLayer, false}, {LowLayer, false}},
+		},
+		{
+			name:      "tracks are upgraded evenly",
+			estimate:  1_200_000,
+			maxLayers: [2]int{HighLayer, HighLayer},
+			want:      [2]allocationResult{{MediumLayer, false}, {MediumLayer, false}},
+			deficit:   1_000_000,
+		},
+		{
+			name:      "audio goes first",
+			estimate:  1_200_000,
+			audio:     300_000,
+			maxLayers: [2]int{HighLayer, HighLayer},
+			want:      [2]allocationResult{{MediumLayer, false}, {LowLayer, false}},
+			deficit:   350_000,
+		},
+		{
+			name:      "low layers exceed the estimate without pause",
+			estimate:  200_000,
+			maxLayers: [2]int{HighLayer, HighLayer},
+			want:      [2]allocationResult{{LowLayer, false}, {LowLayer, false}},
+			deficit:   350_000,
+		},
+		{
+			name:       "low layers exceed the estimate with pause",
+			allowPause: true,
+			estimate:   200_000,
+			maxLayers:  [2]int{HighLayer, HighLayer},
+			// the paused track keeps the target layer of the subscriber
+			want:    [2]allocationResult{{LowLayer, false}, {HighLayer, true}},
+			deficit: 150_000,
+		},
+		{
+			name:       "estimate below the pause threshold of both tracks",
+			allowPause: true,
+			estimate:   100_000,
+			maxLayers:  [2]int{HighLayer, HighLayer},
+			want:       [2]allocationResult{{HighLayer, true}, {HighLayer, true}},
+			deficit:    150_000,
+		},
+		{
+			name:        "min channel capacity prevents the pause",
+			allowPause:  true,
+			minCapacity: 300_000,
+			estimate:    100_000,
+			maxLayers:   [2]int{HighLayer, HighLayer},
+			want:        [2]allocationResult{{LowLayer, false}, {LowLayer, false}},
+			deficit:     350_000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStreamAllocator(config.CongestionControlConfig{
+				Enabled:            true,
+				AllowPause:         tt.allowPause,
+				MinChannelCapacity: tt.minCapacity,
+			})
+
+			if tt.audio > 0 {
+				s.AddTrack(newTestDownTrack(t, newTestMediaTrack("audio", webrtc.RTPCodecTypeAudio, tt.audio), LowLayer))
+			}
+			first := newTestDownTrack(t, newTestMediaTrack("video-1", webrtc.RTPCodecTypeVideo, simulcast...), tt.maxLayers[0])
+			second := newTestDownTrack(t, newTestMediaTrack("video-2", webrtc.RTPCodecTypeVideo, simulcast...), tt.maxLayers[1])
+			s.AddTrack(first)
+			s.AddTrack(second)
+
+			s.onTargetBitrateChange(tt.estimate)
+
+			assert.Equal(t, tt.want, [2]allocationResult{allocationOf(first), allocationOf(second)})
+			assert.Equal(t, tt.deficit, s.deficit)
+		})
+	}
+}
+
+func TestStreamAllocatorFollowsEstimate(t *testing.T) {
+	s := NewStreamAllocator(config.CongestionControlConfig{Enabled: true, AllowPause: true})
+
+	first := newTestDownTrack(t, newTestMediaTrack("video-1", webrtc.RTPCodecTypeVideo, 150_000, 500_000, 1_500_000), HighLayer)
+	second := newTestDownTrack(t, newTestMediaTrack("video-2", webrtc.RTPCodecTypeVideo, 150_000, 500_000, 1_500_000), HighLayer)
+	s.AddTrack(first)
+	s.AddTrack(second)
+
+	steps := []struct {
+		estimate int
+		want     [2]allocationResult
+	}{
+		// Shrinking
+		{5_000_000, [2]allocationResult{{HighLayer, false}, {HighLayer, false}}},
+		{2_500_000, [2]allocationResult{{HighLayer, false}, {MediumLayer, false}}},
+		{1_000_000, [2]allocationResult{{MediumLayer, false}, {MediumLayer, false}}},
+		{700_000, [2]allocationResult{{MediumLayer, false}, {LowLayer, false}}},
+		{300_000, [2]allocationResult{{LowLayer, false}, {LowLayer, false}}},
+		{200_000, [2]allocationResult{{LowLayer, false}, {LowLayer, true}}},
+		{100_000, [2]allocationResult{{LowLayer, true}, {LowLayer, true}}},
+		// Growing
+		{160_000, [2]allocationResult{{LowLayer, false}, {LowLayer, true}}},
+		{650_000, [2]allocationResult{{MediumLayer, false}, {LowLayer, false}}},
+		{3_000_000, [2]allocationResult{{HighLayer, false}, {HighLayer, false}}},
+	}
+
+	for _, step := range steps {
+		s.onTargetBitrateChange(step.estimate)
+		assert.Equal(t, step.want, [2]allocationResult{allocationOf(first), allocationOf(second)}, "estimate %d", step.estimate)
+	}
+}
@@ -0,0 +1,199 @@
+package rtc
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// Temporal layers of the SVC track
+const (
+	maxTemporalLayers = 3
+
+	// the frame dependency template ID is 6 bits, the offset wraps around it
+	ddTemplateIDs = 64
+)
+
+var (
+	errShortDependencyDescriptor = errors.New("dependency descriptor is too short")
+	errNoDependencyStructure     = errors.New("template dependency structure is not received yet")
+	errUnknownDependencyTemplate = errors.New("unknown frame dependency template")
+)
+
+// svcLayer is the position of the packet in the scalable video stream
+type svcLayer struct {
+	spatial  int
+	temporal int
+	// the packet ends the frame of its spatial layer
+	endOfFrame bool
+	// the subscriber can switch up to a higher spatial layer on this picture
+	keyframe bool
+	// the subscriber can switch up to a higher temporal layer on this frame
+	switchUp bool
+}
+
+// svcParser reads the layers of the VP9 SVC stream from the payload descriptor
+// and the layers of the AV1 one from the dependency descriptor extension.
+// A parser belongs to a single RTP stream.
+type svcParser struct {
+	mimeType string
+	ddExtID  uint8
+	// the last template structure sent by the publisher with a keyframe
+	ddStructure *ddStructure
+}
+
+// newSVCParser returns nil if the codec is not scalable or its layers can't be read
+func newSVCParser(codec webrtc.RTPCodecParameters, ddExtID uint8) *svcParser {
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP9):
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeAV1) && ddExtID != 0:
+	default:
+		return nil
+	}
+
+	return &svcParser{
+		mimeType: strings.ToLower(codec.MimeType),
+		ddExtID:  ddExtID,
+	}
+}
+
+// Parse returns the layer of the packet, false if the packet has no layer information
+func (p *svcParser) Parse(packet *rtp.Packet) (svcLayer, bool) {
+	if p.mimeType == strings.ToLower(webrtc.MimeTypeVP9) {
+		return parseVP9Layer(packet)
+	}
+
+	payload := packet.GetExtension(p.ddExtID)
+	if payload == nil {
+		return svcLayer{}, false
+	}
+
+	layer, structure, err := parseDependencyDescriptor(payload, p.ddStructure)
+	if err != nil {
+		return svcLayer{}, false
+	}
+	p.ddStructure = structure
+
+	return layer, true
+}
+
+func parseVP9Layer(packet *rtp.Packet) (svcLayer, bool) {
+	vp9 := &codecs.VP9Packet{}
+	if _, err := vp9.Unmarshal(packet.Payload); err != nil || !vp9.L {
+		return svcLayer{}, false
+	}
+
+	return svcLayer{
+		spatial:    int(vp9.SID),
+		temporal:   int(vp9.TID),
+		endOfFrame: vp9.E,
+		keyframe:   !vp9.P && vp9.SID == 0,
+		switchUp:   vp9.U,
+	}, true
+}
+
+// ddStructure keeps the layers of the frame dependency templates of the AV1 stream
+type ddStructure struct {
+	templateIDOffset int
+	spatialIDs       []int
+	temporalIDs      []int
+}
+
+// parseDependencyDescriptor reads the dependency descriptor extension (AV1 RTP specification, appendix A).
+// The structure attached to the descriptor replaces the previous one.
+func parseDependencyDescriptor(buf []byte, structure *ddStructure) (svcLayer, *ddStructure, error) {
+	if len(buf) < 3 {
+		return svcLayer{}, structure, errShortDependencyDescriptor
+	}
+
+	r := &bitReader{buf: buf}
+	r.readBits(1) // start_of_frame
+	endOfFrame := r.readBits(1) == 1
+	templateID := int(r.readBits(6))
+	r.readBits(16) // frame_number
+
+	keyframe := false
+	if len(buf) > 3 {
+		structurePresent := r.readBits(1) == 1
+		// active_decode_targets_present, custom_dtis, custom_fdiffs and custom_chains flags
+		r.readBits(4)
+
+		if structurePresent {
+			structure = readTemplateLayers(r)
+			keyframe = true
+		}
+	}
+	if r.err != nil {
+		return svcLayer{}, structure, r.err
+	}
+
+	if structure == nil {
+		return svcLayer{}, structure, errNoDependencyStructure
+	}
+
+	index := (templateID + ddTemplateIDs - structure.templateIDOffset) % ddTemplateIDs
+	if index >= len(structure.spatialIDs) {
+		return svcLayer{}, structure, errUnknownDependencyTemplate
+	}
+
+	return svcLayer{
+		spatial:    structure.spatialIDs[index],
+		temporal:   structure.temporalIDs[index],
+		endOfFrame: endOfFrame,
+		keyframe:   keyframe,
+		// the templates of the L1T3 and L3T3 modes allow to switch up on every frame
+		switchUp: true,
+	}, structure, nil
+}
+
+// readTemplateLayers reads the beginning of template_dependency_structure, the rest is not needed to drop layers
+func readTemplateLayers(r *bitReader) *ddStructure {
+	structure := &ddStructure{
+		templateIDOffset: int(r.readBits(6)),
+	}
+	r.readBits(5) // dt_cnt_minus_one
+
+	spatialID, temporalID := 0, 0
+	for r.err == nil && len(structure.spatialIDs) < ddTemplateIDs {
+		structure.spatialIDs = append(structure.spatialIDs, spatialID)
+		structure.temporalIDs = append(structure.temporalIDs, temporalID)
+
+		nextLayerIdc := r.readBits(2)
+		if nextLayerIdc == 1 {
+			temporalID++
+		} else if nextLayerIdc == 2 {
+			temporalID = 0
+			spatialID++
+		} else if nextLayerIdc == 3 {
+			break
+		}
+	}
+
+	return structure
+}
+
+// bitReader reads the big-endian bit fields, the first error stops the reading
+type bitReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) readBits(n int) uint32 {
+	var value uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.buf)*8 {
+			r.err = errShortDependencyDescriptor
+			return 0
+		}
+
+		bit := (r.buf[r.pos/8] >> (7 - uint(r.pos%8))) & 0x01
+		value = value<<1 | uint32(bit)
+		r.pos++
+	}
+
+	return value
+}
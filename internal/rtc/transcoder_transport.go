@@ -0,0 +1,165 @@
+package rtc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/transcode"
+)
+
+const (
+	TranscoderTransportUDP  = "udp"
+	TranscoderTransportUnix = transcode.TransportUnix
+
+	// the socket of the transcoder input is dialed again not more often than this
+	transcoderRedialInterval = time.Second
+	// the packet is dropped if the transcoder doesn't read the socket for this long
+	transcoderWriteTimeout = 5 * time.Millisecond
+)
+
+var (
+	errUnknownTranscoderTransport = errors.New("unknown transport of the transcoder")
+	errTranscoderNotConnected     = errors.New("transcoder socket is not connected")
+	errTranscoderTransportClosed  = errors.New("transcoder transport is closed")
+)
+
+// TranscoderTransport delivers the RTP packets of the published track to the input of the transcoder
+type TranscoderTransport interface {
+	// Write sends the single packet, the packet is dropped on error. The buffer is reused after the call.
+	Write(packet []byte) error
+	Close() error
+}
+
+// TranscoderDialer connects the tracks of the participant to the inputs of the transcoder,
+// the input is the port of the codec in the transcoder SDP
+type TranscoderDialer interface {
+	Dial(userID core.UserSessionID, port int) (TranscoderTransport, error)
+}
+
+// NewTranscoderDialer returns the dialer of the transport of the config: UDP to the host of the transcoder
+// or the unix datagram sockets of the transcode daemon on the same host
+func NewTranscoderDialer(conf config.TranscoderConfig) (TranscoderDialer, error) {
+	switch conf.Transport {
+	case "", TranscoderTransportUDP:
+		return &udpTranscoderDialer{host: conf.Host}, nil
+	case TranscoderTransportUnix:
+		return &unixTranscoderDialer{dir: conf.SocketDir}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownTranscoderTransport, conf.Transport)
+	}
+}
+
+type udpTranscoderDialer struct {
+	host string
+}
+
+func (d *udpTranscoderDialer) Dial(userID core.UserSessionID, port int) (TranscoderTransport, error) {
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(d.host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpTranscoderTransport{conn: conn}, nil
+}
+
+// udpTranscoderTransport sends the packets to the port ffmpeg listens on,
+// the write fails with "connection refused" until ffmpeg is started
+type udpTranscoderTransport struct {
+	conn *net.UDPConn
+}
+
+func (t *udpTranscoderTransport) Write(packet []byte) error {
+	_, err := t.conn.Write(packet)
+
+	return err
+}
+
+func (t *udpTranscoderTransport) Close() error {
+	return t.conn.Close()
+}
+
+type unixTranscoderDialer struct {
+	dir string
+}
+
+// Dial returns the transport connecting lazily, the transcoder creates the socket after the participant is joined
+func (d *unixTranscoderDialer) Dial(userID core.UserSessionID, port int) (TranscoderTransport, error) {
+	return &unixTranscoderTransport{
+		addr: &net.UnixAddr{Name: transcode.SocketPath(d.dir, userID, port), Net: "unixgram"},
+	}, nil
+}
+
+// unixTranscoderTransport sends the packets to the unix datagram socket of the transcode daemon on the same host.
+// The daemon relays them to ffmpeg over the loopback UDP, the SDP demuxer of ffmpeg reads RTP over UDP only.
+type unixTranscoderTransport struct {
+	addr *net.UnixAddr
+
+	lock     sync.Mutex
+	conn     *net.UnixConn
+	dialedAt time.Time
+	closed   bool
+}
+
+func (t *unixTranscoderTransport) Write(packet []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return errTranscoderTransportClosed
+	}
+
+	if t.conn == nil {
+		now := time.Now()
+		if now.Sub(t.dialedAt) < transcoderRedialInterval {
+			return errTranscoderNotConnected
+		}
+		t.dialedAt = now
+
+		conn, err := net.DialUnix("unixgram", nil, t.addr)
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+	}
+
+	// The datagram socket blocks when the transcoder falls behind, the forwarding to the subscribers must not
+	if err := t.conn.SetWriteDeadline(time.Now().Add(transcoderWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := t.conn.Write(packet); err != nil {
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			// The transcoder has exited, its next socket is dialed again
+			_ = t.conn.Close()
+			t.conn = nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (t *unixTranscoderTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+	if t.conn == nil {
+		return nil
+	}
+	conn := t.conn
+	t.conn = nil
+
+	return conn.Close()
+}
@@ -0,0 +1,133 @@
+package rtc
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/transcode"
+)
+
+func TestNewTranscoderDialer(t *testing.T) {
+	tests := []struct {
+		transport string
+		err       error
+	}{
+		{"", nil},
+		{TranscoderTransportUDP, nil},
+		{TranscoderTransportUnix, nil},
+		{"channel", errUnknownTranscoderTransport},
+		{"tcp", errUnknownTranscoderTransport},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			_, err := NewTranscoderDialer(config.TranscoderConfig{Transport: tt.transport, Host: "127.0.0.1"})
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestUDPTranscoderTransport(t *testing.T) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dialer, err := NewTranscoderDialer(config.TranscoderConfig{Transport: TranscoderTransportUDP, Host: "127.0.0.1"})
+	require.NoError(t, err)
+
+	transport, err := dialer.Dial(core.UserSessionID("user"), listener.LocalAddr().(*net.UDPAddr).Port)
+	require.NoError(t, err)
+
+	require.NoError(t, transport.Write([]byte{1, 2, 3}))
+
+	buf := make([]byte, 16)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := listener.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, buf[:n])
+
+	require.NoError(t, transport.Close())
+	assert.Error(t, transport.Write([]byte{4}))
+	assert.NotPanics(t, func() { _ = transport.Close() })
+}
+
+func TestUnixTranscoderTransport(t *testing.T) {
+	dir := t.TempDir()
+	userID := core.UserSessionID("user")
+	port := 5002
+
+	dialer, err := NewTranscoderDialer(config.TranscoderConfig{Transport: TranscoderTransportUnix, SocketDir: dir})
+	require.NoError(t, err)
+
+	transport, err := dialer.Dial(userID, port)
+	require.NoError(t, err)
+	unix := transport.(*unixTranscoderTransport)
+	assert.Equal(t, dir+"/user-"+strconv.Itoa(port)+".sock", unix.addr.Name)
+
+	// The transcoder has not created the socket yet
+	assert.Error(t, transport.Write([]byte{1}))
+	// The socket is not dialed again within the interval
+	assert.ErrorIs(t, transport.Write([]byte{1}), errTranscoderNotConnected)
+
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: transcode.SocketPath(dir, userID, port), Net: "unixgram"})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	unix.dialedAt = time.Time{}
+	require.NoError(t, transport.Write([]byte{1, 2, 3}))
+
+	buf := make([]byte, 16)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := listener.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, buf[:n])
+
+	require.NoError(t, transport.Close())
+	assert.ErrorIs(t, transport.Write([]byte{4}), errTranscoderTransportClosed)
+	assert.NoError(t, transport.Close())
+}
+
+// fakeTranscoderTransport fails the writes while it is set to fail
+type fakeTranscoderTransport struct {
+	fail    bool
+	packets [][]byte
+}
+
+func (t *fakeTranscoderTransport) Write(packet []byte) error {
+	if t.fail {
+		return errTranscoderNotConnected
+	}
+	t.packets = append(t.packets, append([]byte(nil), packet...))
+
+	return nil
+}
+
+func (t *fakeTranscoderTransport) Close() error {
+	return nil
+}
+
+func TestMediaTrackWriteTranscoderCountsDrops(t *testing.T) {
+	transport := &fakeTranscoderTransport{}
+	track := &MediaTrack{ID: MediaTrackID("track"), transcoder: transport}
+
+	track.writeTranscoder([]byte{1})
+	transport.fail = true
+	track.writeTranscoder([]byte{2})
+	track.writeTranscoder([]byte{3})
+	assert.Equal(t, uint64(1), track.transcoderPackets.Load())
+	assert.Equal(t, uint64(2), track.transcoderDropped.Load())
+	assert.True(t, track.transcoderFailing.Load())
+
+	transport.fail = false
+	track.writeTranscoder([]byte{4})
+	assert.Equal(t, uint64(2), track.transcoderPackets.Load())
+	assert.False(t, track.transcoderFailing.Load())
+	assert.Equal(t, [][]byte{{1}, {4}}, transport.packets)
+}
@@ -1,6 +1,7 @@
 package rtc
 
 import (
+	"errors"
 	"sync"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/isqad/livelook-sfu/internal/config"
 	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
 	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -19,6 +21,12 @@ const (
 	iceDisconnectedTimeout     = 10 * time.Second // compatible for ice-lite with firefox client
 	iceFailedTimeout           = 25 * time.Second // pion's default
 	iceKeepaliveInterval       = 2 * time.Second  // pion's default
+	// how long the answer without the trickle ICE waits for the local candidates
+	iceGatheringTimeout = 2 * time.Second
+)
+
+var (
+	errNoOfferHandler = errors.New("offer handler is not set")
 )
 
 type PCTransport struct {
@@ -27,9 +35,20 @@ type PCTransport struct {
 	pc *webrtc.PeerConnection
 	me *webrtc.MediaEngine
 
+	enabledCodecs config.EnabledCodecs
+
 	// stream allocator for subscriber PC
 	streamAllocator   *StreamAllocator
 	pendingCandidates []webrtc.ICECandidateInit
+
+	// server-initiated negotiation (subscriber PC)
+	onOffer             func(offer webrtc.SessionDescription) error
+	renegotiationQueued bool
+	iceRestartQueued    bool
+
+	// RTX SSRCs of the sent tracks keyed by their media SSRCs, signaled in the offers
+	rtx      bool
+	rtxSSRCs map[webrtc.SSRC]webrtc.SSRC
 }
 
 type TransportParams struct {
@@ -51,14 +70,18 @@ func NewPCTransport(params TransportParams) (*PCTransport, error) {
 	t := &PCTransport{
 		pc:                pc,
 		me:                me,
+		enabledCodecs:     params.EnabledCodecs,
 		pendingCandidates: make([]webrtc.ICECandidateInit, 0),
+		rtxSSRCs:          make(map[webrtc.SSRC]webrtc.SSRC),
 	}
 
 	if params.Target == rpc.Receiver {
-		t.streamAllocator = NewStreamAllocator()
+		t.rtx = params.Config.Subscriber.RTX
+		t.streamAllocator = NewStreamAllocator(params.Config.CongestionControl)
 		if bwe != nil {
 			t.streamAllocator.SetBandwidthEstimator(bwe)
 		}
+		t.streamAllocator.Start()
 	}
 
 	t.pc.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
@@ -83,7 +106,7 @@ func newPeerConnection(
 	}
 
 	log.Debug().Str("service", "pcTransport").Msgf("create new peer connection for %s", params.Target)
-	me, ir, err := createMediaEngine(params.EnabledCodecs, directionConfig, params.Target)
+	me, ir, err := createMediaEngine(params.EnabledCodecs, directionConfig, params.Target, onBandwidthEstimator)
 	if err != nil {
 		log.Error().Err(err).Str("service", "pcTransport").Msg("")
 		return nil, nil, err
@@ -125,13 +148,13 @@ func (t *PCTransport) AddICECandidate(candidate webrtc.ICECandidateInit) error {
 }
 
 func (t *PCTransport) SetRemoteDescription(sdp webrtc.SessionDescription) error {
+	t.Lock()
+	defer t.Unlock()
+
 	if err := t.pc.SetRemoteDescription(sdp); err != nil {
 		return err
 	}
 
-	t.Lock()
-	defer t.Unlock()
-
 	for _, candidate := range t.pendingCandidates {
 		if err := t.pc.AddICECandidate(candidate); err != nil {
 			return err
@@ -140,9 +163,189 @@ func (t *PCTransport) SetRemoteDescription(sdp webrtc.SessionDescription) error
 
 	t.pendingCandidates = make([]webrtc.ICECandidateInit, 0)
 
+	// The answer to our offer has been applied, send an offer that was queued meanwhile
+	if sdp.Type == webrtc.SDPTypeAnswer && t.renegotiationQueued {
+		iceRestart := t.iceRestartQueued
+		t.renegotiationQueued = false
+		t.iceRestartQueued = false
+		return t.createAndSendOffer(iceRestart)
+	}
+
 	return nil
 }
 
+// OnOffer sets the handler which delivers offers created by Negotiate to the remote peer
+func (t *PCTransport) OnOffer(f func(offer webrtc.SessionDescription) error) {
+	t.Lock()
+	t.onOffer = f
+	t.Unlock()
+}
+
+// Negotiate creates a new offer and sends it to the remote peer.
+// If the previous offer is not answered yet, the negotiation is queued until the answer arrives.
+func (t *PCTransport) Negotiate() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.pc.SignalingState() != webrtc.SignalingStateStable {
+		t.renegotiationQueued = true
+		return nil
+	}
+
+	return t.createAndSendOffer(false)
+}
+
+// RestartICE creates an offer with the new ICE credentials, i.e. when the connection has failed.
+// The offer is queued like in Negotiate.
+func (t *PCTransport) RestartICE() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.pc.SignalingState() != webrtc.SignalingStateStable {
+		t.renegotiationQueued = true
+		t.iceRestartQueued = true
+		return nil
+	}
+
+	return t.createAndSendOffer(true)
+}
+
+func (t *PCTransport) createAndSendOffer(iceRestart bool) error {
+	if t.onOffer == nil {
+		return errNoOfferHandler
+	}
+
+	offer, err := t.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: iceRestart})
+	if err != nil {
+		return err
+	}
+
+	if err := t.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	desc, err := addRTXStreams(*t.pc.LocalDescription(), t.rtxSSRCs)
+	if err != nil {
+		return err
+	}
+
+	return t.onOffer(desc)
+}
+
+// AnswerGathered answers the applied remote offer. The answer carries the local candidates gathered
+// up to iceGatheringTimeout, it serves the clients without the trickle ICE signaling.
+func (t *PCTransport) AnswerGathered() (webrtc.SessionDescription, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	answer, err := t.pc.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(t.pc)
+	if err := t.pc.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	select {
+	case <-gathered:
+	case <-time.After(iceGatheringTimeout):
+		log.Warn().Str("service", "pcTransport").Msg("ICE gathering timeout, answer with the gathered candidates")
+	}
+
+	return addRTXStreams(*t.pc.LocalDescription(), t.rtxSSRCs)
+}
+
+// PreferCodecs orders the codecs of the remote offer by the preference of the SFU,
+// so the answer makes the client send the best common codec
+func (t *PCTransport) PreferCodecs() error {
+	remote := t.pc.RemoteDescription()
+	if remote == nil {
+		return nil
+	}
+
+	// The description of the peer connection caches the parsed SDP, the copy is parsed instead
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(remote.SDP)); err != nil {
+		return err
+	}
+
+	for _, transceiver := range t.pc.GetTransceivers() {
+		mid := transceiver.Mid()
+		if mid == "" {
+			continue
+		}
+
+		for _, media := range parsed.MediaDescriptions {
+			if value, ok := media.Attribute(sdp.AttrKeyMID); !ok || value != mid {
+				continue
+			}
+
+			codecs := preferredCodecs(t.enabledCodecs, codecsFromMedia(parsed, media))
+			if len(codecs) == 0 {
+				break
+			}
+			if err := transceiver.SetCodecPreferences(codecs); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// RTXEnabled is true when the transport retransmits over RTX streams
+func (t *PCTransport) RTXEnabled() bool {
+	return t.rtx
+}
+
+// AddRTXStream signals the RTX stream of the sent media stream in the next offer
+func (t *PCTransport) AddRTXStream(ssrc, rtxSSRC webrtc.SSRC) {
+	t.Lock()
+	t.rtxSSRCs[ssrc] = rtxSSRC
+	t.Unlock()
+}
+
+func (t *PCTransport) RemoveRTXStream(ssrc webrtc.SSRC) {
+	t.Lock()
+	delete(t.rtxSSRCs, ssrc)
+	t.Unlock()
+}
+
 func (t *PCTransport) Close() {
+	if t.streamAllocator != nil {
+		t.streamAllocator.Stop()
+	}
 	_ = t.pc.Close()
 }
+
+// isICERestart is true when the offer changes the ICE credentials of the current remote description
+func isICERestart(current *webrtc.SessionDescription, offer *webrtc.SessionDescription) bool {
+	if current == nil {
+		return false
+	}
+
+	currentUfrag, offerUfrag := iceUfrag(current), iceUfrag(offer)
+
+	return currentUfrag != "" && offerUfrag != "" && currentUfrag != offerUfrag
+}
+
+func iceUfrag(desc *webrtc.SessionDescription) string {
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return ""
+	}
+
+	if ufrag, ok := parsed.Attribute("ice-ufrag"); ok {
+		return ufrag
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if ufrag, ok := media.Attribute("ice-ufrag"); ok {
+			return ufrag
+		}
+	}
+
+	return ""
+}
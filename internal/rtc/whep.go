@@ -0,0 +1,142 @@
+package rtc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
+)
+
+var (
+	errWHEPViewerClosed = errors.New("WHEP viewer is closed")
+	errNoWHEPViewer     = errors.New("WHEP viewer is not found")
+)
+
+// WHEPViewer receives the tracks of the room over the peer connection negotiated by the single offer
+// and answer of WHEP. There is no signaling channel, the tracks published after the answer are not received.
+type WHEPViewer struct {
+	ID core.UserSessionID
+
+	transport *PCTransport
+
+	lock       sync.Mutex
+	downTracks []*DownTrack
+	closed     bool
+	onClose    func(*WHEPViewer)
+}
+
+type WHEPViewerParams struct {
+	ID            core.UserSessionID
+	EnabledCodecs config.EnabledCodecs
+	RtcConf       *config.WebRTCConfig
+}
+
+func NewWHEPViewer(params WHEPViewerParams) (*WHEPViewer, error) {
+	transport, err := NewPCTransport(TransportParams{
+		EnabledCodecs: params.EnabledCodecs,
+		Config:        params.RtcConf,
+		Target:        rpc.Receiver,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &WHEPViewer{
+		ID:        params.ID,
+		transport: transport,
+	}
+
+	transport.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Debug().Str("service", "WHEPViewer").Str("ID", string(v.ID)).Str("state", state.String()).Msg("connection state changed")
+
+		// The viewer without signaling can't restart ICE
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			v.Close()
+		}
+	})
+
+	return v, nil
+}
+
+// Answer attaches the tracks to the transceivers of the offer and returns the answer with the gathered candidates
+func (v *WHEPViewer) Answer(offer webrtc.SessionDescription, tracks []*MediaTrack) (*webrtc.SessionDescription, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.closed {
+		return nil, errWHEPViewerClosed
+	}
+
+	if err := v.transport.SetRemoteDescription(offer); err != nil {
+		return nil, err
+	}
+
+	for _, mt := range tracks {
+		if !isSubscriberCodec(mt.Codec) {
+			continue
+		}
+
+		dt, err := NewDownTrack(v.ID, mt)
+		if err != nil {
+			return nil, err
+		}
+		if err := dt.Attach(v.transport); err != nil {
+			return nil, err
+		}
+
+		v.downTracks = append(v.downTracks, dt)
+		mt.AddDownTrack(dt)
+		v.transport.streamAllocator.AddTrack(dt)
+	}
+
+	answer, err := v.transport.AnswerGathered()
+	if err != nil {
+		return nil, err
+	}
+
+	return &answer, nil
+}
+
+func (v *WHEPViewer) AddICECandidate(candidate webrtc.ICECandidateInit) error {
+	return v.transport.AddICECandidate(candidate)
+}
+
+// OnClose sets the handler called once the viewer is closed
+func (v *WHEPViewer) OnClose(f func(*WHEPViewer)) {
+	v.lock.Lock()
+	v.onClose = f
+	v.lock.Unlock()
+}
+
+// Close detaches the viewer from the published tracks and closes its peer connection
+func (v *WHEPViewer) Close() {
+	v.lock.Lock()
+	if v.closed {
+		v.lock.Unlock()
+		return
+	}
+	v.closed = true
+	downTracks := v.downTracks
+	v.downTracks = nil
+	onClose := v.onClose
+	v.lock.Unlock()
+
+	log.Debug().Str("service", "WHEPViewer").Str("ID", string(v.ID)).Msg("close")
+
+	for _, dt := range downTracks {
+		dt.mediaTrack.RemoveDownTrack(v.ID)
+		v.transport.streamAllocator.RemoveTrack(dt)
+	}
+
+	// Closing the peer connection may block while the candidates are gathered
+	go v.transport.Close()
+
+	if onClose != nil {
+		onClose(v)
+	}
+}
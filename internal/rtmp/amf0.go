@@ -0,0 +1,228 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// AMF0 markers of the values used by the commands of the publishers
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0a
+	amf0Date        = 0x0b
+	amf0LongString  = 0x0c
+)
+
+var errAMF0Marker = errors.New("unsupported AMF0 marker")
+
+// amfObject is the AMF0 object or the ECMA array, it is encoded with the sorted keys
+type amfObject map[string]interface{}
+
+// amfUndefined is encoded as the undefined marker, nil is encoded as null
+type amfUndefined struct{}
+
+// decodeAMF0 reads the values of the command or of the data message until the end of the payload
+func decodeAMF0(payload []byte) ([]interface{}, error) {
+	r := bytes.NewReader(payload)
+	values := make([]interface{}, 0, 4)
+
+	for r.Len() > 0 {
+		value, err := readAMF0Value(r)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func readAMF0Value(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf0Number:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amf0Boolean:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return b != 0, nil
+	case amf0String:
+		return readAMF0String(r)
+	case amf0LongString:
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		return readAMF0Bytes(r, int(size))
+	case amf0Object:
+		return readAMF0Properties(r)
+	case amf0ECMAArray:
+		// The count is a hint only, the properties end with the object end marker
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return readAMF0Properties(r)
+	case amf0StrictArray:
+		var count uint32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, count)
+		for i := uint32(0); i < count; i++ {
+			value, err := readAMF0Value(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case amf0Date:
+		// milliseconds and the time zone
+		var millis uint64
+		if err := binary.Read(r, binary.BigEndian, &millis); err != nil {
+			return nil, err
+		}
+		if _, err := r.Seek(2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(millis), nil
+	case amf0Null:
+		return nil, nil
+	case amf0Undefined:
+		return amfUndefined{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", errAMF0Marker, marker)
+	}
+}
+
+func readAMF0String(r *bytes.Reader) (string, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", err
+	}
+
+	return readAMF0Bytes(r, int(size))
+}
+
+func readAMF0Bytes(r *bytes.Reader, size int) (string, error) {
+	if size > r.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func readAMF0Properties(r *bytes.Reader) (amfObject, error) {
+	object := amfObject{}
+
+	for {
+		key, err := readAMF0String(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "" {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if marker == amf0ObjectEnd {
+				return object, nil
+			}
+			if err := r.UnreadByte(); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := readAMF0Value(r)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+	}
+}
+
+// encodeAMF0 writes the values of the command, the numbers are float64
+func encodeAMF0(values ...interface{}) []byte {
+	buf := &bytes.Buffer{}
+	for _, value := range values {
+		writeAMF0Value(buf, value)
+	}
+
+	return buf.Bytes()
+}
+
+func writeAMF0Value(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		buf.WriteByte(amf0Number)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case int:
+		writeAMF0Value(buf, float64(v))
+	case bool:
+		buf.WriteByte(amf0Boolean)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf.WriteByte(amf0LongString)
+			_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+			buf.WriteString(v)
+			return
+		}
+		buf.WriteByte(amf0String)
+		writeAMF0String(buf, v)
+	case amfObject:
+		buf.WriteByte(amf0Object)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeAMF0String(buf, key)
+			writeAMF0Value(buf, v[key])
+		}
+		writeAMF0String(buf, "")
+		buf.WriteByte(amf0ObjectEnd)
+	case amfUndefined:
+		buf.WriteByte(amf0Undefined)
+	default:
+		buf.WriteByte(amf0Null)
+	}
+}
+
+func writeAMF0String(buf *bytes.Buffer, s string) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
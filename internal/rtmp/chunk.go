@@ -0,0 +1,313 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Types of the RTMP messages
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAcknowledgement  = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+const (
+	// chunk size of the both directions until it is set by the peer
+	defaultChunkSize = 128
+	// the chunks of the peer are not larger, the chunks of the server are of this size
+	maxChunkSize = 65536
+	// the audio and the video messages are not larger
+	maxMessageSize = 4 * 1024 * 1024
+
+	extendedTimestamp = 0xffffff
+)
+
+var (
+	errChunkSize   = errors.New("invalid chunk size")
+	errMessageSize = errors.New("message is too large")
+	errChunkFormat = errors.New("chunk continues unknown message")
+)
+
+// message is the reassembled RTMP message of the chunk stream
+type message struct {
+	typeID    uint8
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// chunkStream is the state of the chunk stream ID, the headers of the next chunks are relative to it
+type chunkStream struct {
+	started        bool
+	timestamp      uint32
+	timestampDelta uint32
+	extended       bool
+	length         uint32
+	typeID         uint8
+	streamID       uint32
+	// the payload of the message received so far
+	payload []byte
+}
+
+// chunkReader reassembles the messages of the interleaved chunk streams
+type chunkReader struct {
+	r         *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	// bytes read from the connection, they are acknowledged to the peer
+	received uint64
+	header   [11]byte
+}
+
+func newChunkReader(r *bufio.Reader) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+func (c *chunkReader) setChunkSize(size uint32) error {
+	if size < 1 || size > maxChunkSize {
+		return errChunkSize
+	}
+	c.chunkSize = size
+
+	return nil
+}
+
+// readMessage reads the chunks until a message is complete
+func (c *chunkReader) readMessage() (*message, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			return msg, nil
+		}
+	}
+}
+
+// abort drops the partial message of the chunk stream
+func (c *chunkReader) abort(csid uint32) {
+	if cs, ok := c.streams[csid]; ok {
+		cs.payload = nil
+	}
+}
+
+func (c *chunkReader) readChunk() (*message, error) {
+	b, err := c.readByte()
+	if err != nil {
+		return nil, err
+	}
+	format := b >> 6
+	csid := uint32(b & 0x3f)
+
+	switch csid {
+	case 0:
+		if _, err := c.read(c.header[:1]); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(c.header[0])
+	case 1:
+		if _, err := c.read(c.header[:2]); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(c.header[0]) + uint32(c.header[1])*256
+	}
+
+	cs, ok := c.streams[csid]
+	if !ok {
+		cs = &chunkStream{}
+		c.streams[csid] = cs
+	}
+	if format != 0 && !cs.started {
+		return nil, errChunkFormat
+	}
+
+	var timestamp uint32
+	switch format {
+	case 0:
+		if _, err := c.read(c.header[:11]); err != nil {
+			return nil, err
+		}
+		timestamp = uint24(c.header[0:3])
+		cs.length = uint24(c.header[3:6])
+		cs.typeID = c.header[6]
+		cs.streamID = binary.LittleEndian.Uint32(c.header[7:11])
+	case 1:
+		if _, err := c.read(c.header[:7]); err != nil {
+			return nil, err
+		}
+		timestamp = uint24(c.header[0:3])
+		cs.length = uint24(c.header[3:6])
+		cs.typeID = c.header[6]
+	case 2:
+		if _, err := c.read(c.header[:3]); err != nil {
+			return nil, err
+		}
+		timestamp = uint24(c.header[0:3])
+	}
+
+	if format != 3 {
+		cs.extended = timestamp == extendedTimestamp
+	}
+	// The continuation chunk repeats the extended timestamp of the message
+	if cs.extended {
+		if _, err := c.read(c.header[:4]); err != nil {
+			return nil, err
+		}
+		if format != 3 {
+			timestamp = binary.BigEndian.Uint32(c.header[:4])
+		}
+	}
+
+	newMessage := cs.payload == nil
+	switch format {
+	case 0:
+		cs.timestamp = timestamp
+		cs.timestampDelta = 0
+	case 1, 2:
+		cs.timestampDelta = timestamp
+		cs.timestamp += timestamp
+	case 3:
+		// The chunk of the new message with the same header as the previous one
+		if newMessage {
+			cs.timestamp += cs.timestampDelta
+		}
+	}
+	cs.started = true
+
+	if cs.length > maxMessageSize {
+		return nil, errMessageSize
+	}
+	if newMessage {
+		cs.payload = make([]byte, 0, cs.length)
+	}
+
+	size := cs.length - uint32(len(cs.payload))
+	if size > c.chunkSize {
+		size = c.chunkSize
+	}
+	start := len(cs.payload)
+	cs.payload = cs.payload[:start+int(size)]
+	if _, err := c.read(cs.payload[start:]); err != nil {
+		return nil, err
+	}
+
+	if uint32(len(cs.payload)) < cs.length {
+		return nil, nil
+	}
+
+	msg := &message{
+		typeID:    cs.typeID,
+		streamID:  cs.streamID,
+		timestamp: cs.timestamp,
+		payload:   cs.payload,
+	}
+	cs.payload = nil
+
+	return msg, nil
+}
+
+func (c *chunkReader) readByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.received++
+	}
+
+	return b, err
+}
+
+func (c *chunkReader) read(b []byte) (int, error) {
+	n, err := io.ReadFull(c.r, b)
+	c.received += uint64(n)
+
+	return n, err
+}
+
+// chunkWriter splits the messages of the server into the chunks
+type chunkWriter struct {
+	w         *bufio.Writer
+	chunkSize uint32
+	header    [16]byte
+}
+
+func newChunkWriter(w *bufio.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:         w,
+		chunkSize: defaultChunkSize,
+	}
+}
+
+// writeMessage writes the message with the full header, the following chunks continue it
+func (c *chunkWriter) writeMessage(csid uint8, msg *message) error {
+	timestamp := msg.timestamp
+	if timestamp >= extendedTimestamp {
+		timestamp = extendedTimestamp
+	}
+
+	c.header[0] = csid & 0x3f
+	putUint24(c.header[1:4], timestamp)
+	putUint24(c.header[4:7], uint32(len(msg.payload)))
+	c.header[7] = msg.typeID
+	binary.LittleEndian.PutUint32(c.header[8:12], msg.streamID)
+	headerSize := 12
+	if timestamp == extendedTimestamp {
+		binary.BigEndian.PutUint32(c.header[12:16], msg.timestamp)
+		headerSize = 16
+	}
+	if _, err := c.w.Write(c.header[:headerSize]); err != nil {
+		return err
+	}
+
+	payload := msg.payload
+	for {
+		size := uint32(len(payload))
+		if size > c.chunkSize {
+			size = c.chunkSize
+		}
+		if _, err := c.w.Write(payload[:size]); err != nil {
+			return err
+		}
+		payload = payload[size:]
+		if len(payload) == 0 {
+			break
+		}
+
+		c.header[0] = 0xc0 | csid&0x3f
+		if err := c.w.WriteByte(c.header[0]); err != nil {
+			return err
+		}
+		if timestamp == extendedTimestamp {
+			if _, err := c.w.Write(c.header[12:16]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.w.Flush()
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
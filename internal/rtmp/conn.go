@@ -0,0 +1,363 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// chunk size of the messages of the server
+	serverChunkSize = 4096
+	// the peers acknowledge the received bytes every window
+	windowAckSize = 2500000
+	// the only stream of the connection, it is created for the publisher
+	publishStreamID = 1
+
+	// chunk streams of the messages of the server
+	csidControl = 2
+	csidCommand = 3
+	csidStatus  = 5
+
+	// user control event of the stream ready for the media
+	userControlStreamBegin = 0
+	// dynamic limit type of Set Peer Bandwidth
+	peerBandwidthDynamic = 2
+
+	// the publisher sends the media or the acknowledgements more often
+	ioTimeout = 30 * time.Second
+)
+
+var (
+	errAlreadyPublishing = errors.New("connection is publishing already")
+	errStreamDeleted     = errors.New("stream is deleted by the publisher")
+)
+
+// conn serves the single publisher, the messages are handled in the order of the chunk stream
+type conn struct {
+	netConn net.Conn
+	handler Handler
+	reader  *chunkReader
+	writer  *chunkWriter
+
+	// the window of the acknowledgements the peer asked for
+	ackWindow uint32
+	ackedAt   uint64
+
+	stream Stream
+	// the configurations received so far, the tracks are started with them on the first frame
+	info    StreamInfo
+	started bool
+	// the tracks the stream has been started with
+	videoStarted bool
+	audioStarted bool
+}
+
+func newConn(netConn net.Conn, handler Handler) *conn {
+	return &conn{
+		netConn: netConn,
+		handler: handler,
+	}
+}
+
+func (c *conn) serve() error {
+	defer c.close()
+
+	r := bufio.NewReaderSize(c.netConn, serverChunkSize)
+	w := bufio.NewWriterSize(c.netConn, serverChunkSize)
+
+	if err := c.netConn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return err
+	}
+	if err := serverHandshake(r, w); err != nil {
+		return err
+	}
+	c.reader = newChunkReader(r)
+	c.writer = newChunkWriter(w)
+
+	for {
+		if err := c.netConn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+			return err
+		}
+
+		msg, err := c.reader.readMessage()
+		if err != nil {
+			return err
+		}
+		if err := c.handleMessage(msg); err != nil {
+			return err
+		}
+		if err := c.acknowledge(); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) close() {
+	if c.stream != nil {
+		c.stream.Close()
+	}
+	if err := c.netConn.Close(); err != nil {
+		log.Debug().Err(err).Str("service", "rtmp").Str("addr", c.netConn.RemoteAddr().String()).Msg("close connection")
+	}
+}
+
+func (c *conn) handleMessage(msg *message) error {
+	switch msg.typeID {
+	case msgSetChunkSize:
+		if len(msg.payload) < 4 {
+			return errChunkSize
+		}
+		return c.reader.setChunkSize(binary.BigEndian.Uint32(msg.payload) & 0x7fffffff)
+	case msgAbort:
+		if len(msg.payload) >= 4 {
+			c.reader.abort(binary.BigEndian.Uint32(msg.payload))
+		}
+	case msgWindowAckSize:
+		if len(msg.payload) >= 4 {
+			c.ackWindow = binary.BigEndian.Uint32(msg.payload)
+		}
+	case msgCommandAMF3:
+		// The AMF3 command starts with the format byte, the values are AMF0
+		if len(msg.payload) > 0 {
+			return c.handleCommand(msg.payload[1:])
+		}
+	case msgCommandAMF0:
+		return c.handleCommand(msg.payload)
+	case msgVideo:
+		return c.handleVideo(msg)
+	case msgAudio:
+		return c.handleAudio(msg)
+	}
+
+	// The metadata and the acknowledgements of the peer are not used
+	return nil
+}
+
+// acknowledge reports the received bytes once the window of the peer is received
+func (c *conn) acknowledge() error {
+	if c.ackWindow == 0 || c.reader.received-c.ackedAt < uint64(c.ackWindow) {
+		return nil
+	}
+	c.ackedAt = c.reader.received
+
+	return c.writeControl(msgAcknowledgement, uint32(c.reader.received))
+}
+
+func (c *conn) handleCommand(payload []byte) error {
+	values, err := decodeAMF0(payload)
+	if err != nil {
+		return err
+	}
+	if len(values) < 2 {
+		return nil
+	}
+
+	name, _ := values[0].(string)
+	txID, _ := values[1].(float64)
+
+	log.Debug().Str("service", "rtmp").Str("addr", c.netConn.RemoteAddr().String()).Str("command", name).Msg("command")
+
+	switch name {
+	case "connect":
+		return c.handleConnect(txID)
+	case "releaseStream", "FCPublish", "FCUnpublish":
+		if txID == 0 {
+			return nil
+		}
+		return c.writeCommand(csidCommand, 0, "_result", txID, nil, amfUndefined{})
+	case "createStream":
+		return c.writeCommand(csidCommand, 0, "_result", txID, nil, publishStreamID)
+	case "publish":
+		if len(values) < 4 {
+			return nil
+		}
+		streamKey, _ := values[3].(string)
+		return c.handlePublish(streamKey)
+	case "deleteStream", "closeStream":
+		return errStreamDeleted
+	}
+
+	return nil
+}
+
+func (c *conn) handleConnect(txID float64) error {
+	if err := c.writeControl(msgWindowAckSize, windowAckSize); err != nil {
+		return err
+	}
+
+	peerBandwidth := make([]byte, 5)
+	binary.BigEndian.PutUint32(peerBandwidth, windowAckSize)
+	peerBandwidth[4] = peerBandwidthDynamic
+	if err := c.writer.writeMessage(csidControl, &message{typeID: msgSetPeerBandwidth, payload: peerBandwidth}); err != nil {
+		return err
+	}
+
+	if err := c.writeControl(msgSetChunkSize, serverChunkSize); err != nil {
+		return err
+	}
+	c.writer.chunkSize = serverChunkSize
+
+	return c.writeCommand(csidCommand, 0, "_result", txID,
+		amfObject{
+			"fmsVer":       "FMS/3,0,1,123",
+			"capabilities": 31,
+		},
+		amfObject{
+			"level":          "status",
+			"code":           "NetConnection.Connect.Success",
+			"description":    "Connection succeeded.",
+			"objectEncoding": 0,
+		},
+	)
+}
+
+// handlePublish authorizes the stream key, the query of the URL the encoder appends to it is dropped
+func (c *conn) handlePublish(streamKey string) error {
+	if c.stream != nil {
+		return errAlreadyPublishing
+	}
+	streamKey = strings.SplitN(streamKey, "?", 2)[0]
+
+	stream, err := c.handler.Publish(streamKey)
+	if err != nil {
+		if writeErr := c.writeStatus("error", "NetStream.Publish.BadName", err.Error()); writeErr != nil {
+			log.Debug().Err(writeErr).Str("service", "rtmp").Msg("write publish status")
+		}
+		return err
+	}
+	c.stream = stream
+
+	streamBegin := make([]byte, 6)
+	binary.BigEndian.PutUint16(streamBegin, userControlStreamBegin)
+	binary.BigEndian.PutUint32(streamBegin[2:], publishStreamID)
+	if err := c.writer.writeMessage(csidControl, &message{typeID: msgUserControl, payload: streamBegin}); err != nil {
+		return err
+	}
+
+	return c.writeStatus("status", "NetStream.Publish.Start", "Start publishing.")
+}
+
+func (c *conn) handleVideo(msg *message) error {
+	if c.stream == nil {
+		return nil
+	}
+
+	tag, err := parseVideoTag(msg.payload)
+	if err != nil {
+		return err
+	}
+
+	switch tag.packetType {
+	case avcSequenceHeader:
+		// The encoder may change the parameter sets, the following keyframes carry the new ones
+		config, err := parseAVCConfig(tag.data)
+		if err != nil {
+			return err
+		}
+		c.info.Video = config
+		return nil
+	case avcNALU:
+	default:
+		return nil
+	}
+
+	if err := c.start(); err != nil {
+		return err
+	}
+	if !c.videoStarted {
+		return nil
+	}
+
+	data, err := c.info.Video.annexB(tag.data, tag.keyframe)
+	if err != nil {
+		return err
+	}
+
+	return c.stream.WriteVideo(&VideoFrame{
+		Timestamp: time.Duration(int64(msg.timestamp)+int64(tag.compositionTime)) * time.Millisecond,
+		Keyframe:  tag.keyframe,
+		Data:      data,
+	})
+}
+
+func (c *conn) handleAudio(msg *message) error {
+	if c.stream == nil {
+		return nil
+	}
+
+	tag, err := parseAudioTag(msg.payload)
+	if err != nil {
+		return err
+	}
+
+	switch tag.packetType {
+	case aacSequenceHeader:
+		config, err := parseAACConfig(tag.data)
+		if err != nil {
+			return err
+		}
+		if c.audioStarted {
+			log.Warn().Str("service", "rtmp").Str("addr", c.netConn.RemoteAddr().String()).Msg("AAC config is changed, it is not renegotiated")
+			return nil
+		}
+		c.info.Audio = config
+		return nil
+	case aacRaw:
+	default:
+		return nil
+	}
+
+	if err := c.start(); err != nil {
+		return err
+	}
+	if !c.audioStarted || len(tag.data) == 0 {
+		return nil
+	}
+
+	return c.stream.WriteAudio(&AudioFrame{
+		Timestamp: time.Duration(msg.timestamp) * time.Millisecond,
+		Data:      tag.data,
+	})
+}
+
+// start publishes the tracks of the configurations received before the first frame,
+// the encoders send the configurations of every track first
+func (c *conn) start() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	c.videoStarted = c.info.Video != nil
+	c.audioStarted = c.info.Audio != nil
+
+	return c.stream.Start(c.info)
+}
+
+func (c *conn) writeControl(typeID uint8, value uint32) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, value)
+
+	return c.writer.writeMessage(csidControl, &message{typeID: typeID, payload: payload})
+}
+
+func (c *conn) writeCommand(csid uint8, streamID uint32, values ...interface{}) error {
+	return c.writer.writeMessage(csid, &message{
+		typeID:   msgCommandAMF0,
+		streamID: streamID,
+		payload:  encodeAMF0(values...),
+	})
+}
+
+func (c *conn) writeStatus(level string, code string, description string) error {
+	return c.writeCommand(csidStatus, publishStreamID, "onStatus", 0, nil, amfObject{
+		"level":       level,
+		"code":        code,
+		"description": description,
+	})
+}
@@ -0,0 +1,257 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FLV codecs of the audio and the video messages
+const (
+	flvCodecAVC = 7
+	flvCodecAAC = 10
+
+	flvFrameKey = 1
+
+	avcSequenceHeader = 0
+	avcNALU           = 1
+	aacSequenceHeader = 0
+	aacRaw            = 1
+
+	naluTypeSPS = 7
+)
+
+var (
+	errVideoCodec  = errors.New("video codec is not H.264")
+	errAudioCodec  = errors.New("audio codec is not AAC")
+	errTagTooShort = errors.New("FLV tag is too short")
+	errAVCConfig   = errors.New("invalid AVC decoder configuration")
+	errAACConfig   = errors.New("invalid AAC audio specific config")
+
+	annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+	aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+)
+
+// AVCConfig is the decoder configuration of the H.264 stream
+type AVCConfig struct {
+	SPS []byte
+	PPS []byte
+	// size of the length prefix of the NAL units of the frames
+	lengthSize int
+}
+
+// ProfileLevelID returns profile_idc, the constraint flags and level_idc of the SPS, hex encoded
+func (c *AVCConfig) ProfileLevelID() string {
+	return fmt.Sprintf("%02x%02x%02x", c.SPS[1], c.SPS[2], c.SPS[3])
+}
+
+// AACConfig is the AudioSpecificConfig of the AAC stream
+type AACConfig struct {
+	Config     []byte
+	SampleRate int
+	Channels   int
+}
+
+// StreamInfo lists the decoder configurations of the tracks, the track without the configuration is not published
+type StreamInfo struct {
+	Video *AVCConfig
+	Audio *AACConfig
+}
+
+// VideoFrame is the H.264 access unit in the Annex B format, the keyframe starts with SPS and PPS
+type VideoFrame struct {
+	// presentation time of the frame
+	Timestamp time.Duration
+	Keyframe  bool
+	Data      []byte
+}
+
+// AudioFrame is the raw AAC access unit
+type AudioFrame struct {
+	Timestamp time.Duration
+	Data      []byte
+}
+
+// videoTag is the payload of the video message
+type videoTag struct {
+	keyframe   bool
+	packetType uint8
+	// composition time offset of the frame, ms
+	compositionTime int32
+	data            []byte
+}
+
+func parseVideoTag(payload []byte) (*videoTag, error) {
+	if len(payload) < 5 {
+		return nil, errTagTooShort
+	}
+	if payload[0]&0x0f != flvCodecAVC {
+		return nil, errVideoCodec
+	}
+
+	// The composition time is a signed 24 bit integer
+	cts := int32(uint24(payload[2:5])<<8) >> 8
+
+	return &videoTag{
+		keyframe:        payload[0]>>4 == flvFrameKey,
+		packetType:      payload[1],
+		compositionTime: cts,
+		data:            payload[5:],
+	}, nil
+}
+
+// audioTag is the payload of the audio message
+type audioTag struct {
+	packetType uint8
+	data       []byte
+}
+
+func parseAudioTag(payload []byte) (*audioTag, error) {
+	if len(payload) < 2 {
+		return nil, errTagTooShort
+	}
+	if payload[0]>>4 != flvCodecAAC {
+		return nil, errAudioCodec
+	}
+
+	return &audioTag{
+		packetType: payload[1],
+		data:       payload[2:],
+	}, nil
+}
+
+// parseAVCConfig reads the first SPS and PPS of AVCDecoderConfigurationRecord
+func parseAVCConfig(record []byte) (*AVCConfig, error) {
+	if len(record) < 6 {
+		return nil, errAVCConfig
+	}
+
+	config := &AVCConfig{lengthSize: int(record[4]&0x03) + 1}
+	if config.lengthSize == 3 {
+		return nil, errAVCConfig
+	}
+
+	b := record[5:]
+	for _, sets := range []*[]byte{&config.SPS, &config.PPS} {
+		if len(b) < 1 {
+			return nil, errAVCConfig
+		}
+		count := int(b[0])
+		if sets == &config.SPS {
+			count &= 0x1f
+		}
+		b = b[1:]
+
+		for i := 0; i < count; i++ {
+			if len(b) < 2 {
+				return nil, errAVCConfig
+			}
+			size := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+size {
+				return nil, errAVCConfig
+			}
+			if *sets == nil {
+				*sets = append([]byte(nil), b[2:2+size]...)
+			}
+			b = b[2+size:]
+		}
+	}
+
+	// The profile and the level are read from the SPS
+	if len(config.SPS) < 4 || len(config.PPS) == 0 {
+		return nil, errAVCConfig
+	}
+
+	return config, nil
+}
+
+// annexB converts the length prefixed NAL units of the frame into the Annex B byte stream.
+// The parameter sets of the configuration are prepended to the keyframe without them,
+// the decoders joining the stream start from any keyframe.
+func (c *AVCConfig) annexB(data []byte, keyframe bool) ([]byte, error) {
+	frame := make([]byte, 0, len(data)+len(c.SPS)+len(c.PPS)+3*len(annexBStartCode))
+
+	hasParameterSets := false
+	for b := data; len(b) > 0; {
+		if len(b) < c.lengthSize {
+			return nil, errTagTooShort
+		}
+		size := 0
+		for _, v := range b[:c.lengthSize] {
+			size = size<<8 | int(v)
+		}
+		b = b[c.lengthSize:]
+		if size > len(b) {
+			return nil, errTagTooShort
+		}
+		if size > 0 && b[0]&0x1f == naluTypeSPS {
+			hasParameterSets = true
+		}
+		frame = append(frame, annexBStartCode...)
+		frame = append(frame, b[:size]...)
+		b = b[size:]
+	}
+
+	if !keyframe || hasParameterSets {
+		return frame, nil
+	}
+
+	withParameterSets := make([]byte, 0, cap(frame))
+	withParameterSets = append(withParameterSets, annexBStartCode...)
+	withParameterSets = append(withParameterSets, c.SPS...)
+	withParameterSets = append(withParameterSets, annexBStartCode...)
+	withParameterSets = append(withParameterSets, c.PPS...)
+
+	return append(withParameterSets, frame...), nil
+}
+
+// parseAACConfig reads the sample rate and the channels of AudioSpecificConfig
+func parseAACConfig(config []byte) (*AACConfig, error) {
+	r := &bitReader{b: config}
+
+	// audioObjectType, the escaped type is followed by 6 bits
+	if objectType := r.read(5); objectType == 31 {
+		r.read(6)
+	}
+
+	var sampleRate int
+	if frequencyIndex := int(r.read(4)); frequencyIndex == 0x0f {
+		sampleRate = int(r.read(24))
+	} else if frequencyIndex < len(aacSampleRates) {
+		sampleRate = aacSampleRates[frequencyIndex]
+	}
+	channels := int(r.read(4))
+
+	if r.overflow || sampleRate == 0 || channels == 0 {
+		return nil, errAACConfig
+	}
+
+	return &AACConfig{
+		Config:     append([]byte(nil), config...),
+		SampleRate: sampleRate,
+		Channels:   channels,
+	}, nil
+}
+
+// bitReader reads the big endian bit fields, it sets overflow instead of failing
+type bitReader struct {
+	b        []byte
+	pos      int
+	overflow bool
+}
+
+func (r *bitReader) read(bits int) uint32 {
+	var v uint32
+	for i := 0; i < bits; i++ {
+		if r.pos >= len(r.b)*8 {
+			r.overflow = true
+			return 0
+		}
+		v = v<<1 | uint32(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return v
+}
@@ -0,0 +1,50 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	rtmpVersion   = 3
+	handshakeSize = 1536
+)
+
+var errRTMPVersion = errors.New("unsupported RTMP version")
+
+// serverHandshake runs the simple handshake, S2 echoes C1. The encoders accept it
+// instead of the digest handshake of Flash, the digest is needed by the players of RTMPE only.
+func serverHandshake(r *bufio.Reader, w *bufio.Writer) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(r, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != rtmpVersion {
+		return errRTMPVersion
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = rtmpVersion
+	s1 := s0s1s2[1 : 1+handshakeSize]
+	binary.BigEndian.PutUint32(s1[0:4], uint32(time.Now().Unix()))
+	if _, err := rand.Read(s1[8:]); err != nil {
+		return err
+	}
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+
+	if _, err := w.Write(s0s1s2); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// C2 echoes S1, it is not checked
+	_, err := r.Discard(handshakeSize)
+
+	return err
+}
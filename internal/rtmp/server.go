@@ -0,0 +1,138 @@
+// Package rtmp implements the ingest of the encoders publishing H.264 and AAC over RTMP.
+// It serves the publishing clients only, the streams are played over WebRTC and HLS.
+package rtmp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrServerClosed = errors.New("rtmp: server closed")
+
+// Handler authorizes the publishers and receives their streams
+type Handler interface {
+	// Publish is called on the publish command with the stream key, the error rejects the publisher
+	Publish(streamKey string) (Stream, error)
+}
+
+// Stream receives the frames of the single publisher, the methods are called from its connection goroutine
+type Stream interface {
+	// Start is called once before the first frame with the configurations of the tracks,
+	// the frames of the tracks without the configuration are dropped
+	Start(info StreamInfo) error
+	WriteVideo(frame *VideoFrame) error
+	WriteAudio(frame *AudioFrame) error
+	// Close is called when the publisher stops or disconnects
+	Close()
+}
+
+// Server accepts the RTMP connections of the publishers
+type Server struct {
+	handler Handler
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewServer(handler Handler) *Server {
+	return &Server{
+		handler:   handler,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address, RTMP port is 1935
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts the connections until the server is closed, it returns ErrServerClosed then
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	log.Info().Str("service", "rtmp").Str("addr", l.Addr().String()).Msg("RTMP server started")
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.lock.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		if !s.trackConn(netConn) {
+			_ = netConn.Close()
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer s.untrackConn(netConn)
+
+			if err := newConn(netConn, s.handler).serve(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, errStreamDeleted) {
+				log.Error().Err(err).Str("service", "rtmp").Str("addr", netConn.RemoteAddr().String()).Msg("serve connection")
+			}
+		}()
+	}
+}
+
+// Close stops the listeners and disconnects the publishers
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	for netConn := range s.conns {
+		_ = netConn.Close()
+	}
+
+	return err
+}
+
+func (s *Server) trackConn(netConn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[netConn] = struct{}{}
+
+	return true
+}
+
+func (s *Server) untrackConn(netConn net.Conn) {
+	s.lock.Lock()
+	delete(s.conns, netConn)
+	s.lock.Unlock()
+}
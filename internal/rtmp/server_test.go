@@ -0,0 +1,242 @@
+package rtmp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStream struct {
+	info   chan StreamInfo
+	video  chan *VideoFrame
+	audio  chan *AudioFrame
+	closed chan struct{}
+}
+
+func (m *mockStream) Start(info StreamInfo) error {
+	m.info <- info
+	return nil
+}
+
+func (m *mockStream) WriteVideo(frame *VideoFrame) error {
+	m.video <- frame
+	return nil
+}
+
+func (m *mockStream) WriteAudio(frame *AudioFrame) error {
+	m.audio <- frame
+	return nil
+}
+
+func (m *mockStream) Close() {
+	close(m.closed)
+}
+
+type mockHandler struct {
+	stream *mockStream
+}
+
+func (m *mockHandler) Publish(streamKey string) (Stream, error) {
+	if streamKey != "secret" {
+		return nil, errors.New("stream key is not found")
+	}
+
+	return m.stream, nil
+}
+
+// testClient publishes over RTMP like the encoder does
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *chunkReader
+	writer *chunkWriter
+}
+
+func dialTestClient(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = rtmpVersion
+	_, err = w.Write(c0c1)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	_, err = io.ReadFull(r, s0s1s2)
+	require.NoError(t, err)
+	assert.Equal(t, byte(rtmpVersion), s0s1s2[0])
+
+	_, err = w.Write(s0s1s2[1 : 1+handshakeSize])
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	return &testClient{t: t, conn: conn, reader: newChunkReader(r), writer: newChunkWriter(w)}
+}
+
+func (c *testClient) command(values ...interface{}) {
+	require.NoError(c.t, c.writer.writeMessage(csidCommand, &message{typeID: msgCommandAMF0, payload: encodeAMF0(values...)}))
+}
+
+// waitCommand reads the messages of the server until the command
+func (c *testClient) waitCommand(name string) []interface{} {
+	for {
+		msg, err := c.reader.readMessage()
+		require.NoError(c.t, err)
+
+		switch msg.typeID {
+		case msgSetChunkSize:
+			require.NoError(c.t, c.reader.setChunkSize(uint24(msg.payload[1:4])))
+		case msgCommandAMF0:
+			values, err := decodeAMF0(msg.payload)
+			require.NoError(c.t, err)
+			if values[0] == name {
+				return values
+			}
+		}
+	}
+}
+
+func (c *testClient) media(typeID uint8, timestamp uint32, payload []byte) {
+	require.NoError(c.t, c.writer.writeMessage(6, &message{typeID: typeID, streamID: publishStreamID, timestamp: timestamp, payload: payload}))
+}
+
+func TestAMF0(t *testing.T) {
+	payload := encodeAMF0("connect", 1, amfObject{"app": "live", "tcUrl": "rtmp://localhost/live", "fpad": false}, nil, amfUndefined{})
+
+	values, err := decodeAMF0(payload)
+	require.NoError(t, err)
+
+	assert.Equal(t, []interface{}{
+		"connect",
+		float64(1),
+		amfObject{"app": "live", "tcUrl": "rtmp://localhost/live", "fpad": false},
+		nil,
+		amfUndefined{},
+	}, values)
+
+	_, err = decodeAMF0([]byte{0x02, 0x00, 0x05, 'a'})
+	assert.Error(t, err)
+}
+
+func TestParseAACConfig(t *testing.T) {
+	// AAC-LC, 44100 Hz, stereo
+	config, err := parseAACConfig([]byte{0x12, 0x10})
+	require.NoError(t, err)
+	assert.Equal(t, 44100, config.SampleRate)
+	assert.Equal(t, 2, config.Channels)
+
+	_, err = parseAACConfig([]byte{0x12})
+	assert.Error(t, err)
+}
+
+func TestAnnexB(t *testing.T) {
+	config, err := parseAVCConfig([]byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1, 0x00, 0x04, 0x67, 0x64, 0x00, 0x1f, 0x01, 0x00, 0x02, 0x68, 0xee})
+	require.NoError(t, err)
+	assert.Equal(t, "64001f", config.ProfileLevelID())
+
+	frame, err := config.annexB([]byte{0x00, 0x00, 0x00, 0x02, 0x65, 0x88}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x1f,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0xee,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88,
+	}, frame)
+
+	frame, err = config.annexB([]byte{0x00, 0x00, 0x00, 0x02, 0x41, 0x9a}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a}, frame)
+
+	_, err = config.annexB([]byte{0x00, 0x00, 0x00, 0x05, 0x41}, false)
+	assert.Error(t, err)
+}
+
+func TestServerPublish(t *testing.T) {
+	stream := &mockStream{
+		info:   make(chan StreamInfo, 1),
+		video:  make(chan *VideoFrame, 1),
+		audio:  make(chan *AudioFrame, 1),
+		closed: make(chan struct{}),
+	}
+	server := NewServer(&mockHandler{stream: stream})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	t.Run("unknown stream key", func(t *testing.T) {
+		c := dialTestClient(t, l.Addr().String())
+		defer c.conn.Close()
+
+		c.command("connect", 1, amfObject{"app": "live"})
+		c.waitCommand("_result")
+		c.command("publish", 2, nil, "wrong", "live")
+
+		status := c.waitCommand("onStatus")
+		assert.Equal(t, "NetStream.Publish.BadName", status[3].(amfObject)["code"])
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		c := dialTestClient(t, l.Addr().String())
+		defer c.conn.Close()
+
+		c.command("connect", 1, amfObject{"app": "live"})
+		c.waitCommand("_result")
+		c.command("createStream", 2, nil)
+		result := c.waitCommand("_result")
+		assert.Equal(t, float64(publishStreamID), result[3])
+
+		c.command("publish", 3, nil, "secret?token=1", "live")
+		status := c.waitCommand("onStatus")
+		assert.Equal(t, "NetStream.Publish.Start", status[3].(amfObject)["code"])
+
+		// AVC and AAC sequence headers followed by the frames
+		c.media(msgVideo, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00,
+			0x01, 0x42, 0xc0, 0x1f, 0xff, 0xe1, 0x00, 0x04, 0x67, 0x42, 0xc0, 0x1f, 0x01, 0x00, 0x02, 0x68, 0xce})
+		c.media(msgAudio, 0, []byte{0xaf, 0x00, 0x11, 0x90})
+		// The keyframe is larger than the chunk
+		nalu := make([]byte, 300)
+		nalu[0] = 0x65
+		c.media(msgVideo, 40, append([]byte{0x17, 0x01, 0x00, 0x00, 0x28, 0x00, 0x00, 0x01, 0x2c}, nalu...))
+		c.media(msgAudio, 42, []byte{0xaf, 0x01, 0x21, 0x10})
+
+		info := <-stream.info
+		require.NotNil(t, info.Video)
+		require.NotNil(t, info.Audio)
+		assert.Equal(t, "42c01f", info.Video.ProfileLevelID())
+		assert.Equal(t, 48000, info.Audio.SampleRate)
+		assert.Equal(t, 2, info.Audio.Channels)
+
+		video := <-stream.video
+		assert.True(t, video.Keyframe)
+		assert.Equal(t, 80*time.Millisecond, video.Timestamp)
+		assert.Equal(t, append([]byte{0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x1f, 0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x00, 0x00, 0x00, 0x01}, nalu...), video.Data)
+
+		audio := <-stream.audio
+		assert.Equal(t, 42*time.Millisecond, audio.Timestamp)
+		assert.Equal(t, []byte{0x21, 0x10}, audio.Data)
+
+		c.command("deleteStream", 4, nil, publishStreamID)
+		select {
+		case <-stream.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream is not closed")
+		}
+	})
+
+	require.NoError(t, server.Close())
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+}
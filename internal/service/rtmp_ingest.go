@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+	"github.com/isqad/livelook-sfu/internal/rtc"
+	"github.com/isqad/livelook-sfu/internal/rtmp"
+)
+
+var errUnknownStreamKey = errors.New("stream key is not found")
+
+// RTMPIngest publishes the streams of the encoders into the rooms of the owners of the stream keys
+type RTMPIngest struct {
+	users    core.UserStorer
+	sessions *SessionsManager
+}
+
+func NewRTMPIngest(users core.UserStorer, sessions *SessionsManager) *RTMPIngest {
+	return &RTMPIngest{
+		users:    users,
+		sessions: sessions,
+	}
+}
+
+// Publish authorizes the stream key, the session starts with the first frame of the encoder
+func (i *RTMPIngest) Publish(streamKey string) (rtmp.Stream, error) {
+	user, err := i.users.FindByStreamKey(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errUnknownStreamKey
+	}
+
+	log.Debug().Str("service", "rtmpIngest").Str("UserID", string(user.ID)).Msg("publish")
+
+	return &rtmpStream{
+		userID:   user.ID,
+		sessions: i.sessions,
+	}, nil
+}
+
+// rtmpStream feeds the frames of the encoder into the tracks of the participant
+type rtmpStream struct {
+	userID   core.UserSessionID
+	sessions *SessionsManager
+
+	participant *rtc.Participant
+	video       *rtc.IngestTrack
+	audio       *rtc.IngestTrack
+}
+
+func (s *rtmpStream) Start(info rtmp.StreamInfo) error {
+	codecs := rtc.IngestCodecs{}
+	if info.Video != nil {
+		codecs.Video = rtc.IngestH264Codec(info.Video.ProfileLevelID())
+	}
+	if info.Audio != nil {
+		codecs.Audio = rtc.IngestAACCodec(info.Audio.SampleRate, info.Audio.Channels, info.Audio.Config)
+	}
+
+	participant, err := s.sessions.PublishRTMP(s.userID, codecs)
+	if err != nil {
+		return err
+	}
+	s.participant = participant
+
+	if codecs.Video != nil {
+		if s.video, err = participant.PublishIngestTrack(webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	if codecs.Audio != nil {
+		if s.audio, err = participant.PublishIngestTrack(webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *rtmpStream) WriteVideo(frame *rtmp.VideoFrame) error {
+	if s.video == nil {
+		return nil
+	}
+
+	return s.video.WriteFrame(frame.Data, frame.Timestamp)
+}
+
+func (s *rtmpStream) WriteAudio(frame *rtmp.AudioFrame) error {
+	if s.audio == nil {
+		return nil
+	}
+
+	return s.audio.WriteFrame(frame.Data, frame.Timestamp)
+}
+
+// Close ends the session of the encoder, the tracks are closed with the participant
+func (s *rtmpStream) Close() {
+	if s.participant == nil {
+		return
+	}
+
+	if err := s.sessions.StopRTMP(s.participant); err != nil {
+		log.Error().Err(err).Str("service", "rtmpIngest").Str("UserID", string(s.userID)).Msg("stop RTMP")
+	}
+}
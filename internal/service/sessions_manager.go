@@ -3,8 +3,11 @@ package service
 import (
 	"errors"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog/log"
 
 	"github.com/isqad/livelook-sfu/internal/config"
@@ -13,10 +16,16 @@ import (
 	"github.com/isqad/livelook-sfu/internal/eventbus/rpc"
 	"github.com/isqad/livelook-sfu/internal/rtc"
 	"github.com/isqad/livelook-sfu/internal/telemetry"
+	"github.com/isqad/livelook-sfu/internal/turn"
 )
 
+// how often the transcoder ports of the participants left without being closed are reclaimed
+const portsReclaimInterval = time.Minute
+
 var (
-	errRoomNotInitialized = errors.New("room is not initialized")
+	errRoomNotInitialized        = errors.New("room is not initialized")
+	errParticipantNotInitialized = errors.New("participant is not initialized")
+	errAlreadyPublishing         = errors.New("user is already in session")
 )
 
 // SessionsManager управляет всеми сессиями пользователей
@@ -25,13 +34,17 @@ type SessionsManager struct {
 	rtcConfig *config.WebRTCConfig
 	router    *eventbus.Router
 
-	lock           sync.RWMutex
-	sessions       map[core.UserSessionID]*rtc.Room
-	portsAllocator *rtc.PortsAllocator
+	lock             sync.RWMutex
+	sessions         map[core.UserSessionID]*rtc.Room
+	portsAllocator   *rtc.PortsAllocator
+	transcoderDialer rtc.TranscoderDialer
+
+	rpcSink              eventbus.Publisher
+	sessionsRepository   core.SessionsDBStorer
+	recordingsRepository core.RecordingsDBStorer
+	nc                   *nats.Conn
 
-	rpcSink            eventbus.Publisher
-	sessionsRepository core.SessionsDBStorer
-	nc *nats.Conn
+	stop chan struct{}
 }
 
 func NewSessionsManager(
@@ -39,6 +52,7 @@ func NewSessionsManager(
 	router *eventbus.Router,
 	sink eventbus.Publisher,
 	sessionsRepository core.SessionsDBStorer,
+	recordingsRepository core.RecordingsDBStorer,
 	nc *nats.Conn,
 ) (*SessionsManager, error) {
 
@@ -47,25 +61,54 @@ func NewSessionsManager(
 		return nil, err
 	}
 
+	transcoderDialer, err := rtc.NewTranscoderDialer(cfg.RTC.Transcoder)
+	if err != nil {
+		return nil, err
+	}
+
+	portsAllocator, err := rtc.NewPortsAllocator(rtc.PortsAllocatorParams{
+		RangeStart: cfg.RTC.Transcoder.PortStart,
+		RangeEnd:   cfg.RTC.Transcoder.PortEnd,
+		LockDir:    cfg.RTC.Transcoder.PortsLockDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	s := &SessionsManager{
-		router:             router,
-		cfg:                cfg,
-		rtcConfig:          rtcConf,
-		rpcSink:            sink,
-		sessionsRepository: sessionsRepository,
-		sessions:           make(map[core.UserSessionID]*rtc.Room),
-		portsAllocator:     rtc.NewPortsAllocator(cfg.RTC.Transcoder.PortStart, cfg.RTC.Transcoder.PortEnd),
-		nc: nc,
+		router:               router,
+		cfg:                  cfg,
+		rtcConfig:            rtcConf,
+		rpcSink:              sink,
+		sessionsRepository:   sessionsRepository,
+		recordingsRepository: recordingsRepository,
+		sessions:             make(map[core.UserSessionID]*rtc.Room),
+		portsAllocator:       portsAllocator,
+		transcoderDialer:     transcoderDialer,
+		nc:                   nc,
+		stop:                 make(chan struct{}),
 	}
 
 	router.OnJoin(s.StartSession)
 	router.OnOffer(s.HandleOffer)
+	router.OnAnswer(s.HandleAnswer)
 	router.OnAddICECandidate(s.AddICECandidate)
 	router.OnCloseSession(s.CloseSession)
 	router.OnPublishStream(s.PublishStream)
 	router.OnStopStream(s.StopStream)
 	router.OnSubscribeStream(s.Subscribe)
 	router.OnSubscribeStreamCancel(s.Unsubscribe)
+	router.OnVideoQuality(s.SetVideoQuality)
+	router.OnInvitePublisher(s.InvitePublisher)
+	router.OnAcceptInvite(s.AcceptInvite)
+	router.OnRemovePublisher(s.RemovePublisher)
+	router.OnLossyDataPolicy(s.SetLossyDataPolicy)
+	router.OnMuteTrack(s.MuteTrack)
+	router.OnUnmuteTrack(s.UnmuteTrack)
+	router.OnStartRecording(s.StartRecording)
+	router.OnStopRecording(s.StopRecording)
+
+	go s.reclaimPorts()
 
 	return s, nil
 }
@@ -86,33 +129,272 @@ func (s *SessionsManager) StartSession(userID core.UserSessionID) error {
 		return err
 	}
 
+	// The client reconnects the signaling, resume the session; the media is restored by the ICE restart
+	if participant := room.Participant(userID); participant != nil && !participant.IsClosed() {
+		log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("resume session")
+
+		return s.rpcSink.PublishClient(userID, rpc.NewJoinRpc(turn.ICEServers(s.cfg.RTC.TURN, userID)))
+	}
+
+	// The credentials of the TURN server are issued for every user
+	iceServers := turn.ICEServers(s.cfg.RTC.TURN, userID)
+
+	if _, err := s.joinParticipant(room, userID, iceServers, nil); err != nil {
+		s.setOffline(userID)
+		s.dropRoom(userID, room)
+		return err
+	}
+
+	// Send Join RPC
+	msg := rpc.NewJoinRpc(iceServers)
+	if err := s.rpcSink.PublishClient(userID, msg); err != nil {
+		// The room closes the joined participant
+		s.setOffline(userID)
+		s.dropRoom(userID, room)
+		return err
+	}
+
+	telemetry.SessionStarted()
+
+	return nil
+}
+
+// joinParticipant creates the participant of the user and joins it into the own room of the user,
+// the participant of the ingest publishes the tracks of the encoder
+func (s *SessionsManager) joinParticipant(room *rtc.Room, userID core.UserSessionID, iceServers []webrtc.ICEServer, ingest *rtc.IngestCodecs) (*rtc.Participant, error) {
 	// RTC-конфиг копируется для каждого participant'а
 	rtcConf := *s.rtcConfig
+	rtcConf.Configuration.ICEServers = iceServers
 	options := rtc.ParticipantOptions{
-		UserID:         userID,
-		RpcSink:        s.rpcSink,
-		EnabledCodecs:  s.cfg.Peer.EnabledCodecs,
-		RtcConf:        &rtcConf,
-		PortsAllocator: s.portsAllocator,
-		NatsConn:       s.nc,
+		UserID:           userID,
+		RpcSink:          s.rpcSink,
+		EnabledCodecs:    s.cfg.Peer.EnabledCodecs,
+		RtcConf:          &rtcConf,
+		PortsAllocator:   s.portsAllocator,
+		TranscoderDialer: s.transcoderDialer,
+		NatsConn:         s.nc,
+		Ingest:           ingest,
 	}
 	participant, err := rtc.NewParticipant(options)
 	if err != nil {
-		participant.Close()
-		return err
+		return nil, err
 	}
 
 	room.Join(participant)
 
-	// Send Join RPC
-	msg := rpc.NewJoinRpc()
-	if err := s.rpcSink.PublishClient(userID, msg); err != nil {
-		participant.Close()
+	// The session is closed if the client has not reconnected during the grace period
+	participant.OnReconnectTimeout(func(p *rtc.Participant) {
+		if err := s.CloseSession(p.ID); err != nil {
+			log.Error().Str("service", "sessionsManager").Str("UserID", string(p.ID)).Err(err).Msg("close session after reconnect timeout")
+		}
+	})
+
+	participant.OnDataPacket(s.HandleDataPacket)
+
+	return participant, nil
+}
+
+// PublishWHIP starts the session of the broadcast software publishing over WHIP and returns the answer to its offer.
+// The answer carries the gathered ICE candidates, the session goes live right away.
+func (s *SessionsManager) PublishWHIP(userID core.UserSessionID, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("publish over WHIP")
+
+	room, err := s.findOrInitRoom(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if participant := room.Participant(userID); participant != nil && !participant.IsClosed() {
+		return nil, errAlreadyPublishing
+	}
+
+	if _, err := s.sessionsRepository.Save(&core.Session{UserID: userID}); err != nil {
+		s.dropRoom(userID, room)
+		return nil, err
+	}
+
+	// The broadcast software has no signaling of the TURN credentials
+	participant, err := s.joinParticipant(room, userID, s.rtcConfig.Configuration.ICEServers, nil)
+	if err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("whip", "error", "join").Add(1)
+		s.setOffline(userID)
+		s.dropRoom(userID, room)
+		return nil, err
+	}
+	telemetry.SessionStarted()
+
+	answer, err := participant.AnswerPublisher(offer)
+	if err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("whip", "error", "answer").Add(1)
+		if closeErr := s.CloseSession(userID); closeErr != nil {
+			log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(closeErr).Msg("close WHIP session")
+		}
+		return nil, err
+	}
+
+	if err := s.PublishStream(userID); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("whip", "error", "publish").Add(1)
+		if closeErr := s.CloseSession(userID); closeErr != nil {
+			log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(closeErr).Msg("close WHIP session")
+		}
+		return nil, err
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("whip", "success", "").Add(1)
+
+	return answer, nil
+}
+
+// AddWHIPCandidates adds the trickled ICE candidates of the WHIP publisher
+func (s *SessionsManager) AddWHIPCandidates(userID core.UserSessionID, candidates []webrtc.ICECandidateInit) error {
+	room, err := s.findRoom(userID)
+	if err != nil {
 		return err
 	}
 
+	for _, candidate := range candidates {
+		if err := room.AddICECandidate(userID, rpc.ICECandidateParams{ICECandidateInit: candidate, Target: rpc.Publisher}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StopWHIP ends the session of the WHIP publisher
+func (s *SessionsManager) StopWHIP(userID core.UserSessionID) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("stop WHIP")
+
+	if err := s.StopStream(userID); err != nil {
+		return err
+	}
+
+	return s.CloseSession(userID)
+}
+
+// PublishRTMP starts the session of the encoder publishing over RTMP, the session goes live right away.
+// The tracks of the codecs are published by the returned participant.
+func (s *SessionsManager) PublishRTMP(userID core.UserSessionID, codecs rtc.IngestCodecs) (*rtc.Participant, error) {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("publish over RTMP")
+
+	room, err := s.findOrInitRoom(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if participant := room.Participant(userID); participant != nil && !participant.IsClosed() {
+		return nil, errAlreadyPublishing
+	}
+
+	if _, err := s.sessionsRepository.Save(&core.Session{UserID: userID}); err != nil {
+		s.dropRoom(userID, room)
+		return nil, err
+	}
+
+	participant, err := s.joinParticipant(room, userID, s.rtcConfig.Configuration.ICEServers, &codecs)
+	if err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("rtmp", "error", "join").Add(1)
+		s.setOffline(userID)
+		s.dropRoom(userID, room)
+		return nil, err
+	}
 	telemetry.SessionStarted()
 
+	if err := s.PublishStream(userID); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("rtmp", "error", "publish").Add(1)
+		if closeErr := s.CloseSession(userID); closeErr != nil {
+			log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(closeErr).Msg("close RTMP session")
+		}
+		return nil, err
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("rtmp", "success", "").Add(1)
+
+	return participant, nil
+}
+
+// StopRTMP ends the session of the RTMP publisher, the session started by the user since then is kept
+func (s *SessionsManager) StopRTMP(participant *rtc.Participant) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(participant.ID)).Msg("stop RTMP")
+
+	room, err := s.findRoom(participant.ID)
+	if err != nil {
+		return err
+	}
+	if room.Participant(participant.ID) != participant {
+		return nil
+	}
+
+	if err := s.StopStream(participant.ID); err != nil {
+		return err
+	}
+
+	return s.CloseSession(participant.ID)
+}
+
+// SubscribeWHEP answers the offer of the WHEP viewer of the stream, it returns the ID of the viewer and the answer
+func (s *SessionsManager) SubscribeWHEP(streamID core.UserSessionID, offer webrtc.SessionDescription) (core.UserSessionID, *webrtc.SessionDescription, error) {
+	log.Debug().Str("service", "sessionsManager").Str("StreamID", string(streamID)).Msg("subscribe over WHEP")
+
+	room, err := s.findRoom(streamID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rtcConf := *s.rtcConfig
+	viewer, err := rtc.NewWHEPViewer(rtc.WHEPViewerParams{
+		ID:            core.UserSessionID("whep-" + uuid.New().String()),
+		EnabledCodecs: s.cfg.Peer.EnabledCodecs,
+		RtcConf:       &rtcConf,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	answer, err := room.AddWHEPViewer(viewer, offer)
+	if err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("whep", "error", "answer").Add(1)
+		return "", nil, err
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("whep", "success", "").Add(1)
+
+	return viewer.ID, answer, nil
+}
+
+// AddWHEPCandidates adds the trickled ICE candidates of the WHEP viewer
+func (s *SessionsManager) AddWHEPCandidates(streamID core.UserSessionID, viewerID core.UserSessionID, candidates []webrtc.ICECandidateInit) error {
+	room, err := s.findRoom(streamID)
+	if err != nil {
+		return err
+	}
+
+	viewer, err := room.WHEPViewer(viewerID)
+	if err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		if err := viewer.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SessionsManager) StopWHEP(streamID core.UserSessionID, viewerID core.UserSessionID) error {
+	room, err := s.findRoom(streamID)
+	if err != nil {
+		return err
+	}
+
+	viewer, err := room.WHEPViewer(viewerID)
+	if err != nil {
+		return err
+	}
+	viewer.Close()
+
 	return nil
 }
 
@@ -128,6 +410,18 @@ func (s *SessionsManager) HandleOffer(userID core.UserSessionID, params rpc.SDPP
 	return room.HandleOffer(userID, params)
 }
 
+func (s *SessionsManager) HandleAnswer(userID core.UserSessionID, params rpc.SDPParams) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("handle answer")
+
+	room, err := s.findRoom(userID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	return room.HandleAnswer(userID, params)
+}
+
 func (s *SessionsManager) AddICECandidate(userID core.UserSessionID, params rpc.ICECandidateParams) error {
 	room, err := s.findRoom(userID)
 	if err != nil {
@@ -147,6 +441,26 @@ func (s *SessionsManager) CloseSession(userID core.UserSessionID) error {
 		return err
 	}
 
+	s.lock.Lock()
+	delete(s.sessions, userID)
+	coPublishedRooms := make([]*rtc.Room, 0)
+	for _, r := range s.sessions {
+		r.RemoveSubscriber(userID)
+		if r.IsPublisher(userID) {
+			coPublishedRooms = append(coPublishedRooms, r)
+		}
+	}
+	s.lock.Unlock()
+
+	// The receivers of the co-published rooms are detached from the tracks before the participant closes them
+	for _, r := range coPublishedRooms {
+		if err := r.RemovePublisher(userID, userID); err != nil {
+			log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("RoomID", string(r.ID)).Err(err).Msg("remove co-publisher")
+			continue
+		}
+		s.updateBroadcastState(r)
+	}
+
 	if err := room.Close(); err != nil {
 		telemetry.ServiceOperationCounter.WithLabelValues("sessions", "error", "close").Add(1)
 		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("close session error")
@@ -157,10 +471,6 @@ func (s *SessionsManager) CloseSession(userID core.UserSessionID) error {
 		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("set offline errored")
 	}
 
-	s.lock.Lock()
-	delete(s.sessions, userID)
-	s.lock.Unlock()
-
 	telemetry.SessionStopped()
 
 	return nil
@@ -185,6 +495,8 @@ func (s *SessionsManager) PublishStream(userID core.UserSessionID) error {
 		return err
 	}
 
+	s.updateBroadcastState(room)
+
 	return nil
 }
 
@@ -213,20 +525,374 @@ func (s *SessionsManager) StopStream(userID core.UserSessionID) error {
 func (s *SessionsManager) Subscribe(userID core.UserSessionID, streamerUserID core.UserSessionID) error {
 	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("StreamerID", string(streamerUserID)).Msg("creating a subscription to the streaming")
 
+	viewerRoom, err := s.findRoom(userID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	viewer := viewerRoom.Participant(userID)
+	if viewer == nil {
+		return errParticipantNotInitialized
+	}
+
+	streamerRoom, err := s.findRoom(streamerUserID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("StreamerID", string(streamerUserID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	if err := streamerRoom.Subscribe(viewer); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("subscribe", "error", "subscribe").Add(1)
+		return err
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("subscribe", "success", "").Add(1)
+
 	return nil
 }
 
 func (s *SessionsManager) Unsubscribe(userID core.UserSessionID, streamerUserID core.UserSessionID) error {
 	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("StreamerID", string(streamerUserID)).Msg("cancel subscription to the streaming")
 
+	streamerRoom, err := s.findRoom(streamerUserID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("StreamerID", string(streamerUserID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	return streamerRoom.Unsubscribe(userID)
+}
+
+// SetVideoQuality switches the viewer to the simulcast layer of the streamer's video
+func (s *SessionsManager) SetVideoQuality(userID core.UserSessionID, params rpc.VideoQualityParams) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("StreamerID", string(params.UserID)).Str("quality", string(params.Quality)).Msg("set video quality")
+
+	streamerRoom, err := s.findRoom(params.UserID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("StreamerID", string(params.UserID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	return streamerRoom.SetVideoQuality(userID, params.Quality, params.Temporal)
+}
+
+// InvitePublisher invites the user to publish into the room of the streamer
+func (s *SessionsManager) InvitePublisher(userID core.UserSessionID, params rpc.InvitePublisherParams) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("InviteeID", string(params.UserID)).Msg("invite publisher")
+
+	room, err := s.findRoom(userID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	if _, err := s.findRoom(params.UserID); err != nil {
+		log.Error().Str("service", "sessionsManager").Str("InviteeID", string(params.UserID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	return room.Invite(userID, params.UserID)
+}
+
+// AcceptInvite joins the invited user to the room of the streamer as a co-publisher
+func (s *SessionsManager) AcceptInvite(userID core.UserSessionID, params rpc.RoomParams) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("RoomID", string(params.RoomID)).Msg("accept invite")
+
+	ownRoom, err := s.findRoom(userID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	publisher := ownRoom.Participant(userID)
+	if publisher == nil {
+		return errParticipantNotInitialized
+	}
+
+	room, err := s.findRoom(params.RoomID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("RoomID", string(params.RoomID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	if err := room.AddPublisher(publisher); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("co_publish", "error", "add_publisher").Add(1)
+		return err
+	}
+	telemetry.ServiceOperationCounter.WithLabelValues("co_publish", "success", "").Add(1)
+
+	s.updateBroadcastState(room)
+
 	return nil
 }
 
+// RemovePublisher removes the co-publisher from the room of the streamer
+func (s *SessionsManager) RemovePublisher(userID core.UserSessionID, params rpc.RemovePublisherParams) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("RoomID", string(params.RoomID)).Str("PublisherID", string(params.UserID)).Msg("remove publisher")
+
+	room, err := s.findRoom(params.RoomID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("RoomID", string(params.RoomID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	if err := room.RemovePublisher(userID, params.UserID); err != nil {
+		return err
+	}
+
+	s.updateBroadcastState(room)
+
+	return nil
+}
+
+// HandleDataPacket delivers the chat, reaction or app message of the participant to the room
+func (s *SessionsManager) HandleDataPacket(participant *rtc.Participant, packet *rtc.DataPacket) {
+	room, err := s.findRoom(packet.RoomID)
+	if err != nil {
+		log.Debug().Str("service", "sessionsManager").Str("UserID", string(participant.ID)).Str("RoomID", string(packet.RoomID)).Err(err).Msg("room not found")
+		return
+	}
+
+	if err := room.SendData(participant, packet); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("data_packet", "error", "send").Add(1)
+		log.Debug().Str("service", "sessionsManager").Str("UserID", string(participant.ID)).Str("RoomID", string(packet.RoomID)).Err(err).Msg("send data packet")
+		return
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("data_packet", "success", "").Add(1)
+}
+
+// SetLossyDataPolicy changes who may send into the lossy data channel of the streamer's room
+func (s *SessionsManager) SetLossyDataPolicy(userID core.UserSessionID, params rpc.LossyDataPolicyParams) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("policy", string(params.Policy)).Msg("set lossy data policy")
+
+	room, err := s.findRoom(userID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	return room.SetLossyDataPolicy(userID, params.Policy)
+}
+
+// MuteTrack stops forwarding the track of the publisher, see muteTrack
+func (s *SessionsManager) MuteTrack(userID core.UserSessionID, params rpc.MuteTrackParams) error {
+	return s.setTrackMuted(userID, params, true)
+}
+
+// UnmuteTrack resumes forwarding the track of the publisher
+func (s *SessionsManager) UnmuteTrack(userID core.UserSessionID, params rpc.MuteTrackParams) error {
+	return s.setTrackMuted(userID, params, false)
+}
+
+func (s *SessionsManager) setTrackMuted(userID core.UserSessionID, params rpc.MuteTrackParams, muted bool) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("PublisherID", string(params.UserID)).Str("TrackID", params.TrackID).Bool("muted", muted).Msg("set track muted")
+
+	roomID, publisherID := params.RoomID, params.UserID
+	if roomID == "" {
+		roomID = userID
+	}
+	if publisherID == "" {
+		publisherID = userID
+	}
+
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		log.Error().Str("service", "sessionsManager").Str("RoomID", string(roomID)).Err(err).Msg("room not found")
+		return err
+	}
+
+	if err := room.MuteTrack(userID, publisherID, rtc.MediaTrackID(params.TrackID), muted); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("mute_track", "error", "mute").Add(1)
+		return err
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("mute_track", "success", "").Add(1)
+
+	return nil
+}
+
+// ForceMuteTrack mutes or unmutes the track of the publisher of the stream on behalf of the admin
+func (s *SessionsManager) ForceMuteTrack(roomID core.UserSessionID, publisherID core.UserSessionID, trackID string, muted bool) error {
+	log.Info().Str("service", "sessionsManager").Str("RoomID", string(roomID)).Str("PublisherID", string(publisherID)).Str("TrackID", trackID).Bool("muted", muted).Msg("force mute track")
+
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+
+	return room.ForceMuteTrack(publisherID, rtc.MediaTrackID(trackID), muted)
+}
+
+// AddForwardTarget forwards the track of the publisher of the stream to the external receiver on behalf of the admin
+func (s *SessionsManager) AddForwardTarget(roomID core.UserSessionID, publisherID core.UserSessionID, trackID string, params rtc.ForwardTargetParams) (*rtc.ForwardTargetInfo, error) {
+	log.Info().Str("service", "sessionsManager").Str("RoomID", string(roomID)).Str("PublisherID", string(publisherID)).Str("TrackID", trackID).Str("host", params.Host).Int("port", params.Port).Msg("add forward target")
+
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := room.AddForwardTarget(publisherID, rtc.MediaTrackID(trackID), params)
+	if err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("add_forward_target", "error", "forward").Add(1)
+		return nil, err
+	}
+	telemetry.ServiceOperationCounter.WithLabelValues("add_forward_target", "success", "").Add(1)
+
+	info := target.Info()
+
+	return &info, nil
+}
+
+func (s *SessionsManager) RemoveForwardTarget(roomID core.UserSessionID, targetID string) error {
+	log.Info().Str("service", "sessionsManager").Str("RoomID", string(roomID)).Str("TargetID", targetID).Msg("remove forward target")
+
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+
+	return room.RemoveForwardTarget(targetID)
+}
+
+// ForwardTargets lists the forward targets of the tracks of the stream with their delivery counters
+func (s *SessionsManager) ForwardTargets(roomID core.UserSessionID) ([]rtc.ForwardTargetInfo, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return room.ForwardTargets(), nil
+}
+
+// StreamStats returns the statistics of the media of the streamer's room
+func (s *SessionsManager) StreamStats(userID core.UserSessionID) ([]rtc.ParticipantStats, error) {
+	room, err := s.findRoom(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return room.Stats(), nil
+}
+
+// StartRecording writes the tracks of the streamer into the files, every file is stored in the recordings table
+func (s *SessionsManager) StartRecording(userID core.UserSessionID) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("start recording")
+
+	room, err := s.findRoom(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := room.StartRecording(rtc.RecordingParams{
+		Dir: s.cfg.Recording.Dir,
+		OnStarted: func(recorder *rtc.TrackRecorder) {
+			s.recordingStarted(userID, recorder)
+		},
+		OnFinished: s.recordingFinished,
+	}); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("recording", "error", "start").Add(1)
+		return err
+	}
+
+	telemetry.ServiceOperationCounter.WithLabelValues("recording", "success", "").Add(1)
+
+	return nil
+}
+
+func (s *SessionsManager) StopRecording(userID core.UserSessionID) error {
+	log.Debug().Str("service", "sessionsManager").Str("UserID", string(userID)).Msg("stop recording")
+
+	room, err := s.findRoom(userID)
+	if err != nil {
+		return err
+	}
+
+	return room.StopRecording()
+}
+
+// Recordings returns the recorded files of the streamer, the latest first
+func (s *SessionsManager) Recordings(userID core.UserSessionID) ([]*core.Recording, error) {
+	return s.recordingsRepository.FindByUserID(userID)
+}
+
+func (s *SessionsManager) recordingStarted(userID core.UserSessionID, recorder *rtc.TrackRecorder) {
+	recording := &core.Recording{
+		UserID:    userID,
+		TrackID:   string(recorder.TrackID),
+		Kind:      recorder.Kind.String(),
+		MimeType:  recorder.MimeType,
+		Filename:  recorder.Filename,
+		StartedAt: recorder.StartedAt,
+	}
+
+	if err := s.recordingsRepository.Create(recording); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("database", "error", "recording_create").Add(1)
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Str("file", recorder.Filename).Err(err).Msg("create recording errored")
+	}
+}
+
+func (s *SessionsManager) recordingFinished(recorder *rtc.TrackRecorder, recordErr error) {
+	status := core.RecordingFinished
+	if recordErr != nil {
+		status = core.RecordingFailed
+	}
+
+	if err := s.recordingsRepository.Finish(recorder.Filename, recorder.Size(), status); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("database", "error", "recording_finish").Add(1)
+		log.Error().Str("service", "sessionsManager").Str("file", recorder.Filename).Err(err).Msg("finish recording errored")
+	}
+}
+
+// updateBroadcastState moves the session of the room owner between broadcast_single and broadcast_multi
+func (s *SessionsManager) updateBroadcastState(room *rtc.Room) {
+	state := core.SingleBroadcast
+	if room.PublishersCount() > 1 {
+		state = core.MultiBroadcast
+	}
+
+	if err := s.sessionsRepository.SetBroadcastState(room.ID, state); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("database", "error", "session_set_state").Add(1)
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(room.ID)).Err(err).Msg("set broadcast state errored")
+	}
+}
+
 // Close sends messages about terminate the server to all active clients
 func (s *SessionsManager) Close() error {
+	close(s.stop)
+
 	return nil
 }
 
+// reclaimPorts periodically releases the transcoder ports of the participants left without being closed
+func (s *SessionsManager) reclaimPorts() {
+	ticker := time.NewTicker(portsReclaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if reclaimed := s.portsAllocator.Reclaim(s.isActiveParticipant); reclaimed > 0 {
+				telemetry.ServiceOperationCounter.WithLabelValues("ports", "error", "leaked").Add(float64(reclaimed))
+			}
+		}
+	}
+}
+
+// isActiveParticipant is true while the participant is the open one of the own room of the user
+func (s *SessionsManager) isActiveParticipant(participant *rtc.Participant) bool {
+	room, err := s.findRoom(participant.ID)
+	if err != nil {
+		return false
+	}
+
+	return room.Participant(participant.ID) == participant && !participant.IsClosed()
+}
+
 func (s *SessionsManager) findOrInitRoom(userID core.UserSessionID) (*rtc.Room, error) {
 	s.lock.RLock()
 	room := s.sessions[userID]
@@ -245,6 +911,26 @@ func (s *SessionsManager) findOrInitRoom(userID core.UserSessionID) (*rtc.Room,
 	return room, nil
 }
 
+// dropRoom closes the room of the session failed to start, the room is removed unless it has been replaced meanwhile
+func (s *SessionsManager) dropRoom(userID core.UserSessionID, room *rtc.Room) {
+	// The room stops the audio levels, detaches the viewers and closes the participant if it has joined
+	_ = room.Close()
+
+	s.lock.Lock()
+	if s.sessions[userID] == room {
+		delete(s.sessions, userID)
+	}
+	s.lock.Unlock()
+}
+
+// setOffline sets the session saved for the failed start offline
+func (s *SessionsManager) setOffline(userID core.UserSessionID) {
+	if err := s.sessionsRepository.SetOffline(userID); err != nil {
+		telemetry.ServiceOperationCounter.WithLabelValues("database", "error", "session_set_offline").Add(1)
+		log.Error().Str("service", "sessionsManager").Str("UserID", string(userID)).Err(err).Msg("set offline errored")
+	}
+}
+
 func (s *SessionsManager) findRoom(userID core.UserSessionID) (*rtc.Room, error) {
 	s.lock.RLock()
 	room := s.sessions[userID]
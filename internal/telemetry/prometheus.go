@@ -5,8 +5,18 @@ import "github.com/prometheus/client_golang/prometheus"
 const livelookNamespace string = "livelook"
 
 var (
-	promSessionTotal        prometheus.Gauge
-	ServiceOperationCounter *prometheus.CounterVec
+	promSessionTotal          prometheus.Gauge
+	ServiceOperationCounter   *prometheus.CounterVec
+	promRetransmissionTotal   *prometheus.CounterVec
+	promKeyframeRequestTotal  *prometheus.CounterVec
+	promRTPPacketTotal        *prometheus.CounterVec
+	promRTPBytesTotal         *prometheus.CounterVec
+	promRTPPacketLostTotal    *prometheus.CounterVec
+	promRTPFractionLost       *prometheus.HistogramVec
+	promRTPJitter             *prometheus.HistogramVec
+	promRTPRoundTripTime      prometheus.Histogram
+	promTranscoderPacketTotal *prometheus.CounterVec
+	promTranscoderPorts       *prometheus.GaugeVec
 )
 
 func init() {
@@ -26,8 +36,120 @@ func init() {
 		[]string{"type", "status", "error_type"},
 	)
 
+	promRetransmissionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "retransmission_total",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"result", "mode"},
+	)
+
+	promKeyframeRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "keyframe_request_total",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"result", "reason"},
+	)
+
+	promRTPPacketTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "packet_total",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"direction"},
+	)
+
+	promRTPBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "bytes_total",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"direction"},
+	)
+
+	promRTPPacketLostTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "packet_lost_total",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"direction"},
+	)
+
+	promRTPFractionLost = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "fraction_lost",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+			Buckets:     []float64{0, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1},
+		},
+		[]string{"direction"},
+	)
+
+	promRTPJitter = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "jitter_seconds",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+			Buckets:     []float64{0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5},
+		},
+		[]string{"direction"},
+	)
+
+	promRTPRoundTripTime = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "rtp",
+			Name:        "rtt_seconds",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+			Buckets:     []float64{0.01, 0.025, 0.05, 0.1, 0.2, 0.3, 0.5, 1, 2},
+		},
+	)
+
+	promTranscoderPacketTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "transcoder",
+			Name:        "packet_total",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"result"},
+	)
+
+	promTranscoderPorts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   livelookNamespace,
+			Subsystem:   "transcoder",
+			Name:        "ports",
+			ConstLabels: prometheus.Labels{"node_id": "1"},
+		},
+		[]string{"state"},
+	)
+
 	prometheus.MustRegister(promSessionTotal)
 	prometheus.MustRegister(ServiceOperationCounter)
+	prometheus.MustRegister(promRetransmissionTotal)
+	prometheus.MustRegister(promKeyframeRequestTotal)
+	prometheus.MustRegister(promRTPPacketTotal)
+	prometheus.MustRegister(promRTPBytesTotal)
+	prometheus.MustRegister(promRTPPacketLostTotal)
+	prometheus.MustRegister(promRTPFractionLost)
+	prometheus.MustRegister(promRTPJitter)
+	prometheus.MustRegister(promRTPRoundTripTime)
+	prometheus.MustRegister(promTranscoderPacketTotal)
+	prometheus.MustRegister(promTranscoderPorts)
 }
 
 func SessionStarted() {
@@ -37,3 +159,64 @@ func SessionStarted() {
 func SessionStopped() {
 	promSessionTotal.Dec()
 }
+
+// PacketRetransmitted counts the packet resent on NACK, mode is "rtp" or "rtx"
+func PacketRetransmitted(mode string) {
+	promRetransmissionTotal.WithLabelValues("hit", mode).Inc()
+}
+
+// RetransmissionMissed counts the NACKed packet which is not in the cache anymore
+func RetransmissionMissed() {
+	promRetransmissionTotal.WithLabelValues("miss", "").Inc()
+}
+
+// KeyframeRequested counts the keyframe request sent to the publisher
+func KeyframeRequested(reason string) {
+	promKeyframeRequestTotal.WithLabelValues("sent", reason).Inc()
+}
+
+// KeyframeRequestThrottled counts the keyframe request dropped because the previous one is too recent
+func KeyframeRequestThrottled(reason string) {
+	promKeyframeRequestTotal.WithLabelValues("throttled", reason).Inc()
+}
+
+// RTPPackets counts the packets received from the publishers ("inbound") or forwarded to the subscribers ("outbound")
+func RTPPackets(direction string, packets, bytes, lost int) {
+	promRTPPacketTotal.WithLabelValues(direction).Add(float64(packets))
+	promRTPBytesTotal.WithLabelValues(direction).Add(float64(bytes))
+	if lost > 0 {
+		promRTPPacketLostTotal.WithLabelValues(direction).Add(float64(lost))
+	}
+}
+
+// RTPFractionLost observes the lost fraction of the stream in the last report interval
+func RTPFractionLost(direction string, fraction float64) {
+	promRTPFractionLost.WithLabelValues(direction).Observe(fraction)
+}
+
+// RTPJitter observes the interarrival jitter of the stream, seconds
+func RTPJitter(direction string, jitter float64) {
+	promRTPJitter.WithLabelValues(direction).Observe(jitter)
+}
+
+// RTPRoundTripTime observes the round trip time to the subscriber, seconds
+func RTPRoundTripTime(rtt float64) {
+	promRTPRoundTripTime.Observe(rtt)
+}
+
+// TranscoderPacket counts the packet of the published track sent to the transcoder or dropped by its transport
+func TranscoderPacket(delivered bool) {
+	if delivered {
+		promTranscoderPacketTotal.WithLabelValues("sent").Inc()
+		return
+	}
+	promTranscoderPacketTotal.WithLabelValues("dropped").Inc()
+}
+
+// TranscoderPorts sets the ports of the transcoder inputs on the host: used by the instance,
+// reserved by the other instances of the host and free
+func TranscoderPorts(used, reserved, free int) {
+	promTranscoderPorts.WithLabelValues("used").Set(float64(used))
+	promTranscoderPorts.WithLabelValues("reserved").Set(float64(reserved))
+	promTranscoderPorts.WithLabelValues("free").Set(float64(free))
+}
@@ -152,6 +152,15 @@ func (d *Daemon) startTranscoder(payload *Message) error {
 	}
 	f.Close()
 
+	// The SFU on the same host sends the packets to the unix sockets, they are relayed to the ports of ffmpeg
+	if viper.GetString("transcoder.transport") == TransportUnix {
+		relays, err := startUnixRelays(viper.GetString("transcoder.socket_dir"), payload.UserID, payload.SDP)
+		if err != nil {
+			return err
+		}
+		defer closeUnixRelays(relays)
+	}
+
 	m3u8FilePath := streamDir + "/stream.m3u8"
 
 	ffmpegCmd := exec.Command(
@@ -166,6 +175,9 @@ func (d *Daemon) startTranscoder(payload *Message) error {
 		"-bufsize", "6000k",
 		"-pix_fmt", "yuv420p",
 		"-g", "30",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-ar", "48000",
 		"-flags", "low_delay",
 		"-hls_time", "2",
 		"-hls_flags", "delete_segments",
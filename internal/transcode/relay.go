@@ -0,0 +1,108 @@
+package transcode
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pion/sdp/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+// TransportUnix is the transport of the SFU sending the packets to the unix datagram sockets of the daemon
+const TransportUnix = "unix"
+
+// SocketPath returns the unix datagram socket of the input of the transcoder of the user,
+// the input is the port of the codec in the transcoder SDP
+func SocketPath(dir string, userID core.UserSessionID, port int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%d.sock", userID, port))
+}
+
+// unixRelay passes the packets received on the unix datagram socket to the UDP port of ffmpeg,
+// the SDP demuxer of ffmpeg reads RTP over UDP only
+type unixRelay struct {
+	path string
+	in   *net.UnixConn
+	out  *net.UDPConn
+}
+
+// startUnixRelays creates the socket of every input of the transcoder SDP
+func startUnixRelays(dir string, userID core.UserSessionID, sessionSDP []byte) ([]*unixRelay, error) {
+	sd := &sdp.SessionDescription{}
+	if err := sd.Unmarshal(sessionSDP); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	relays := make([]*unixRelay, 0, len(sd.MediaDescriptions))
+	for _, md := range sd.MediaDescriptions {
+		port := md.MediaName.Port.Value
+
+		relay, err := newUnixRelay(SocketPath(dir, userID, port), port)
+		if err != nil {
+			closeUnixRelays(relays)
+			return nil, err
+		}
+		relays = append(relays, relay)
+
+		go relay.run()
+	}
+
+	return relays, nil
+}
+
+func closeUnixRelays(relays []*unixRelay) {
+	for _, relay := range relays {
+		relay.close()
+	}
+}
+
+func newUnixRelay(path string, port int) (*unixRelay, error) {
+	// The socket of the previous transcoder of the user is left if the daemon has been killed
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	in, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		in.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &unixRelay{path: path, in: in, out: out}, nil
+}
+
+func (r *unixRelay) run() {
+	buf := make([]byte, 1500)
+	for {
+		n, err := r.in.Read(buf)
+		if err != nil {
+			return
+		}
+
+		// ffmpeg may not listen yet, the packet is lost as it would be over UDP
+		if _, err := r.out.Write(buf[:n]); err != nil {
+			log.Debug().Err(err).Str("service", "transcode").Str("socket", r.path).Msg("relay packet")
+		}
+	}
+}
+
+func (r *unixRelay) close() {
+	if err := r.in.Close(); err != nil {
+		log.Error().Err(err).Str("service", "transcode").Str("socket", r.path).Msg("close relay")
+	}
+	r.out.Close()
+	os.Remove(r.path)
+}
@@ -0,0 +1,123 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pionturn "github.com/pion/turn/v2"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/isqad/livelook-sfu/internal/config"
+	"github.com/isqad/livelook-sfu/internal/core"
+)
+
+var (
+	errNoPublicIP = errors.New("public IP of the TURN server is not set")
+)
+
+// Server is the TURN server embedded into the SFU for the clients behind symmetric NATs
+type Server struct {
+	server *pionturn.Server
+}
+
+func NewServer(conf config.TURNConfig) (*Server, error) {
+	relayIP := net.ParseIP(conf.PublicIP)
+	if relayIP == nil {
+		return nil, errNoPublicIP
+	}
+
+	udpListener, err := net.ListenPacket("udp4", fmt.Sprintf("0.0.0.0:%d", conf.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm:       conf.Realm,
+		AuthHandler: newAuthHandler(conf.Secret),
+		PacketConnConfigs: []pionturn.PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &pionturn.RelayAddressGeneratorPortRange{
+					RelayAddress: relayIP,
+					Address:      "0.0.0.0",
+					MinPort:      conf.RelayPortStart,
+					MaxPort:      conf.RelayPortEnd,
+				},
+			},
+		},
+	})
+	if err != nil {
+		_ = udpListener.Close()
+		return nil, err
+	}
+
+	log.Info().Str("service", "turn").Int("port", conf.Port).Msg("TURN server started")
+
+	return &Server{server: server}, nil
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// Credentials issues the TURN credentials of the user valid for the configured TTL.
+// The username is "<expiration unix time>:<user ID>", the password is HMAC-SHA1 of the username
+// (TURN REST API), so the server checks them without any storage.
+func Credentials(conf config.TURNConfig, userID core.UserSessionID) (string, string) {
+	username := fmt.Sprintf("%d:%s", time.Now().Add(conf.CredentialTTL).Unix(), userID)
+
+	return username, password(conf.Secret, username)
+}
+
+// ICEServers returns the STUN servers and, if TURN is enabled, the TURN server with the credentials of the user
+func ICEServers(conf config.TURNConfig, userID core.UserSessionID) []webrtc.ICEServer {
+	iceServers := []webrtc.ICEServer{
+		{URLs: config.DefaultStunServers},
+	}
+
+	if !conf.Enabled {
+		return iceServers
+	}
+
+	username, credential := Credentials(conf, userID)
+	iceServers = append(iceServers, webrtc.ICEServer{
+		URLs:           []string{fmt.Sprintf("turn:%s:%d?transport=udp", conf.Host, conf.Port)},
+		Username:       username,
+		Credential:     credential,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	})
+
+	return iceServers
+}
+
+func password(secret string, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	_, _ = mac.Write([]byte(username))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newAuthHandler(secret string) pionturn.AuthHandler {
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		expiresAt, err := strconv.ParseInt(strings.SplitN(username, ":", 2)[0], 10, 64)
+		if err != nil {
+			log.Debug().Str("service", "turn").Str("username", username).Msg("invalid username")
+			return nil, false
+		}
+
+		if expiresAt < time.Now().Unix() {
+			log.Debug().Str("service", "turn").Str("username", username).Msg("expired credentials")
+			return nil, false
+		}
+
+		return pionturn.GenerateAuthKey(username, realm, password(secret, username)), true
+	}
+}